@@ -10,6 +10,11 @@ import (
 //go:embed hospital-mgmt.openapi.yaml
 var openapiSpec []byte
 
+// OpenApiSpec returns the embedded api description, the requests are validated by it
+func OpenApiSpec() []byte {
+    return openapiSpec
+}
+
 func HandleOpenApi(ctx *gin.Context) {
     ctx.Data(http.StatusOK, "application/yaml", openapiSpec)
 }
\ No newline at end of file
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// authDisabledFromEnv returns whether AMBULANCE_API_AUTH_DISABLED turns the authentication off,
+// meant for local development only
+func authDisabledFromEnv() bool {
+	value, ok := os.LookupEnv("AMBULANCE_API_AUTH_DISABLED")
+	if !ok || strings.TrimSpace(value) == "" {
+		return false
+	}
+	disabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		log.Warn().Str("AMBULANCE_API_AUTH_DISABLED", value).Msg("Invalid boolean, authentication stays enabled")
+		return false
+	}
+	return disabled
+}
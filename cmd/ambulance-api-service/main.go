@@ -2,18 +2,21 @@ package main
 
 import (
 	// "log"
+	"fmt"
+	"net/http"
 	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/psabol571/sarsabsim-webapi/api"
+	"github.com/psabol571/sarsabsim-webapi/internal/auth"
 	"github.com/psabol571/sarsabsim-webapi/internal/hospital_mgmt"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
 
 	"context"
 	"time"
 
 	"github.com/gin-contrib/cors"
-	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -22,6 +25,7 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 )
 
@@ -56,6 +60,16 @@ func main() {
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 	defer traceProvider.Shutdown(ctx)
 
+	// initialize metric exporter
+	metricReader, err := autoexport.NewMetricReader(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize metric exporter")
+	}
+
+	meterProvider := metricsdk.NewMeterProvider(metricsdk.WithReader(metricReader))
+	otel.SetMeterProvider(meterProvider)
+	defer meterProvider.Shutdown(ctx)
+
 	log.Info().Msg("Server started")
 
 	port := os.Getenv("AMBULANCE_API_PORT")
@@ -67,59 +81,172 @@ func main() {
 		gin.SetMode(gin.DebugMode)
 	}
 	engine := gin.New()
-	engine.Use(gin.Recovery())
+	// errors are answered with problem details, a panic too
+	engine.Use(gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		problem.RespondError(c, fmt.Errorf("panic: %v", recovered),
+			problem.New(http.StatusInternalServerError, problem.CodeInternalError, "The request could not be processed"))
+	}))
+	engine.NoRoute(func(c *gin.Context) {
+		problem.Respond(c, problem.New(http.StatusNotFound, problem.CodeRouteNotFound, "No route serves "+c.Request.URL.Path))
+	})
 	engine.Use(otelgin.Middleware("ambulance-webapi"))
 
 	corsMiddleware := cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "PUT", "POST", "DELETE", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{""},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "If-Match", "X-Hospital-Id"},
+		ExposeHeaders:    []string{"X-Total-Count", "Link", "ETag"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	})
 	engine.Use(corsMiddleware)
 
-	// setup context update middleware - Hospital management db services
-	departmentDbService := db_service.NewMongoService[hospital_mgmt.Department](db_service.MongoServiceConfig{
-		Collection: "departments",
-	})
+	// Hospital management db services
+	storage := storageFromEnv()
+	log.Info().Str("storage", storage).Msg("Using storage")
+
+	// every request works with the documents of one hospital
+	hospitalDbService := newCachedDbService[hospital_mgmt.Hospital](storage, "hospitals")
+	defer hospitalDbService.Disconnect(context.Background())
+
+	// every write of the collections is recorded in the append-only audit log and keeps a revision
+	// of the document, both stored next to them
+	auditDbService := hospital_mgmt.NewAppendOnlyService(
+		hospital_mgmt.NewTenantScopedService(newDbService[hospital_mgmt.AuditEntry](storage, "audit")))
+	defer auditDbService.Disconnect(context.Background())
+
+	departmentRevisionDbService := hospital_mgmt.NewTenantScopedService(
+		newDbService[hospital_mgmt.DepartmentRevision](storage, "department_revisions"))
+	defer departmentRevisionDbService.Disconnect(context.Background())
+
+	departmentStore := newCachedDbService[hospital_mgmt.Department](storage, "departments")
+	departmentDbService := hospital_mgmt.NewAuditedService(
+		hospital_mgmt.NewHistoryService(
+			hospital_mgmt.NewSoftDeleteService(hospital_mgmt.NewTenantScopedService(departmentStore)),
+			departmentRevisionDbService,
+		),
+		auditDbService,
+		hospital_mgmt.AuditEntityDepartment,
+	)
 	defer departmentDbService.Disconnect(context.Background())
 
-	bedDbService := db_service.NewMongoService[hospital_mgmt.Bed](db_service.MongoServiceConfig{
-		Collection: "beds",
-	})
+	bedRevisionDbService := hospital_mgmt.NewTenantScopedService(
+		newDbService[hospital_mgmt.BedRevision](storage, "bed_revisions"))
+	defer bedRevisionDbService.Disconnect(context.Background())
+
+	bedStore := newCachedDbService[hospital_mgmt.Bed](storage, "beds")
+	bedDbService := hospital_mgmt.NewAuditedService(
+		hospital_mgmt.NewHistoryService(
+			hospital_mgmt.NewSoftDeleteService(hospital_mgmt.NewTenantScopedService(bedStore)),
+			bedRevisionDbService,
+		),
+		auditDbService,
+		hospital_mgmt.AuditEntityBed,
+	)
 	defer bedDbService.Disconnect(context.Background())
 
-	patientDbService := db_service.NewMongoService[hospital_mgmt.Patient](db_service.MongoServiceConfig{
-		Collection: "patients",
-	})
+	patientRevisionDbService := hospital_mgmt.NewTenantScopedService(
+		newDbService[hospital_mgmt.PatientRevision](storage, "patient_revisions"))
+	defer patientRevisionDbService.Disconnect(context.Background())
+
+	patientStore := newCachedDbService[hospital_mgmt.Patient](storage, "patients")
+	patientDbService := hospital_mgmt.NewAuditedService(
+		hospital_mgmt.NewHistoryService(
+			hospital_mgmt.NewSoftDeleteService(hospital_mgmt.NewTenantScopedService(patientStore)),
+			patientRevisionDbService,
+		),
+		auditDbService,
+		hospital_mgmt.AuditEntityPatient,
+	)
 	defer patientDbService.Disconnect(context.Background())
 
-	engine.Use(func(ctx *gin.Context) {
-		// Set appropriate db service based on the request path
-		path := ctx.Request.URL.Path
-		if strings.Contains(path, "/api/departments/") && strings.HasSuffix(path, "/beds") {
-			// Handle /api/departments/:departmentId/beds
-			ctx.Set("db_service", bedDbService)
-		} else if strings.HasPrefix(path, "/api/departments") {
-			ctx.Set("db_service", departmentDbService)
-		} else if strings.HasPrefix(path, "/api/beds") {
-			ctx.Set("db_service", bedDbService)
-		} else if strings.HasPrefix(path, "/api/patients") {
-			ctx.Set("db_service", patientDbService)
+	// deleted documents can be restored until they are purged after the retention period
+	if retention, interval := purgeConfigFromEnv(); retention > 0 {
+		log.Info().Dur("retention", retention).Dur("interval", interval).Msg("Purging deleted documents")
+		go runPurge(ctx, retention, interval, map[string]purgeFunc{
+			"departments": func(ctx context.Context, deletedBefore time.Time) (int, error) {
+				return hospital_mgmt.PurgeDeleted(ctx, departmentStore, departmentRevisionDbService, auditDbService,
+					hospital_mgmt.AuditEntityDepartment, deletedBefore)
+			},
+			"beds": func(ctx context.Context, deletedBefore time.Time) (int, error) {
+				return hospital_mgmt.PurgeDeleted(ctx, bedStore, bedRevisionDbService, auditDbService,
+					hospital_mgmt.AuditEntityBed, deletedBefore)
+			},
+			"patients": func(ctx context.Context, deletedBefore time.Time) (int, error) {
+				return hospital_mgmt.PurgeDeleted(ctx, patientStore, patientRevisionDbService, auditDbService,
+					hospital_mgmt.AuditEntityPatient, deletedBefore)
+			},
+		})
+	}
+
+	// delete policies of references between collections
+	integrityPolicy := hospital_mgmt.DefaultIntegrityPolicy
+	for name, policy := range map[string]*hospital_mgmt.DeletePolicy{
+		"AMBULANCE_API_DELETE_POLICY_DEPARTMENT_BEDS": &integrityPolicy.DepartmentBeds,
+		"AMBULANCE_API_DELETE_POLICY_PATIENT_BEDS":    &integrityPolicy.PatientBeds,
+	} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
 		}
-		ctx.Next()
-	})
+		parsed, err := hospital_mgmt.ParseDeletePolicy(value)
+		if err != nil {
+			log.Warn().Str(name, value).Msgf("Invalid delete policy, using default: %s", *policy)
+			continue
+		}
+		*policy = parsed
+	}
 
-	// hospital management routings
+	// hospital management routings, the handlers are constructed with the db services they work with
 	hospitalHandleFunctions := &hospital_mgmt.ApiHandleFunctions{
-		DepartmentsAPI: hospital_mgmt.NewDepartmentsAPI(),
-		BedsAPI:        hospital_mgmt.NewBedsAPI(),
-		PatientsAPI:    hospital_mgmt.NewPatientsAPI(),
+		DepartmentsAPI: hospital_mgmt.NewDepartmentsAPI(
+			departmentDbService, bedDbService, departmentRevisionDbService, integrityPolicy),
+		BedsAPI: hospital_mgmt.NewBedsAPI(
+			bedDbService, departmentDbService, patientDbService, bedRevisionDbService),
+		PatientsAPI: hospital_mgmt.NewPatientsAPI(
+			patientDbService, bedDbService, departmentDbService, patientRevisionDbService, integrityPolicy),
+		AuditAPI: hospital_mgmt.NewAuditAPI(auditDbService),
+		HospitalsAPI: hospital_mgmt.NewHospitalsAPI(
+			hospitalDbService, departmentDbService, bedDbService, patientDbService),
+	}
+
+	// every request but the api description and the health check needs a bearer token, the roles
+	// of its user have to permit the route
+	if authDisabledFromEnv() {
+		log.Warn().Msg("Authentication is disabled, the api is open to anyone")
+	} else {
+		authMiddleware, err := auth.NewMiddleware(auth.Config{
+			ExemptPaths: []string{"/openapi", "/health"},
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize authentication")
+		}
+		if os.Getenv("AMBULANCE_API_AUTH_HS256_SECRET") == "" && os.Getenv("AMBULANCE_API_AUTH_JWKS_FILE") == "" {
+			log.Warn().Msg("No key verifying tokens is configured, every api request is refused")
+		}
+		engine.Use(authMiddleware, hospital_mgmt.NewAuthorizationMiddleware(*hospitalHandleFunctions))
+	}
+
+	// the hospital of the request is selected by the X-Hospital-Id header, the hospital_id
+	// claim of the token or the /api/hospitals/:hospitalId/ path prefix
+	engine.Use(hospital_mgmt.NewTenantMiddleware(hospitalDbService))
+
+	// requests are validated by the api description before the handlers run, outside of production
+	// the responses are validated too and their drift from the description is logged
+	validationMiddleware, err := hospital_mgmt.NewValidationMiddleware(*hospitalHandleFunctions, hospital_mgmt.ValidationConfig{
+		Spec:              api.OpenApiSpec(),
+		ValidateResponses: gin.IsDebugging(),
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize request validation")
 	}
+	engine.Use(validationMiddleware)
+
 	hospital_mgmt.NewRouterWithGinEngine(engine, *hospitalHandleFunctions)
 
 	engine.GET("/openapi", api.HandleOpenApi)
-	engine.Run(":" + port)
+	engine.GET("/health", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"status": "UP"})
+	})
+	http.ListenAndServe(":"+port, hospital_mgmt.NewHospitalPathHandler(engine))
 }
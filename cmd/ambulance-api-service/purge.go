@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultPurgeRetention = 30 * 24 * time.Hour
+	defaultPurgeInterval  = time.Hour
+)
+
+// purgeFunc removes the documents of a collection deleted before the time for good
+type purgeFunc func(ctx context.Context, deletedBefore time.Time) (int, error)
+
+// purgeConfigFromEnv returns how long deleted documents can be restored,
+// AMBULANCE_API_PURGE_RETENTION (0 keeps them forever), and how often they are purged,
+// AMBULANCE_API_PURGE_INTERVAL
+func purgeConfigFromEnv() (time.Duration, time.Duration) {
+	retention := defaultPurgeRetention
+	interval := defaultPurgeInterval
+	for name, setting := range map[string]*time.Duration{
+		"AMBULANCE_API_PURGE_RETENTION": &retention,
+		"AMBULANCE_API_PURGE_INTERVAL":  &interval,
+	} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || duration < 0 {
+			log.Warn().Str(name, value).Msgf("Invalid duration, using default: %s", *setting)
+			continue
+		}
+		*setting = duration
+	}
+	if interval <= 0 {
+		log.Warn().Msgf("Purge interval has to be positive, using default: %s", defaultPurgeInterval)
+		interval = defaultPurgeInterval
+	}
+	return retention, interval
+}
+
+// runPurge purges the collections every interval until the context is done
+func runPurge(ctx context.Context, retention time.Duration, interval time.Duration, purges map[string]purgeFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deletedBefore := time.Now().Add(-retention)
+		for collection, purge := range purges {
+			purged, err := purge(ctx, deletedBefore)
+			if err != nil {
+				log.Error().Err(err).Str("collection", collection).Msg("Failed to purge deleted documents")
+			}
+			if purged > 0 {
+				log.Info().Str("collection", collection).Int("purged", purged).Msg("Purged deleted documents")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
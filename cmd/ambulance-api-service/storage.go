@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	storageMongo    = "mongo"
+	storageMemory   = "memory"
+	storagePostgres = "postgres"
+	// file storage is selected with the path of the file, e.g. file:/var/lib/ambulance/ambulance.db
+	storageFilePrefix = "file:"
+)
+
+// storageFromEnv returns the storage backend selected by AMBULANCE_API_STORAGE, mongo by default
+func storageFromEnv() string {
+	storage := strings.TrimSpace(os.Getenv("AMBULANCE_API_STORAGE"))
+	// the path of the file storage keeps its case
+	if path, ok := cutPrefixFold(storage, storageFilePrefix); ok {
+		return storageFilePrefix + path
+	}
+	storage = strings.ToLower(storage)
+	switch storage {
+	case "":
+		return storageMongo
+	case storageMongo, storageMemory, storagePostgres:
+		return storage
+	default:
+		log.Warn().Str("AMBULANCE_API_STORAGE", storage).Msgf("Unknown storage, using default: %s", storageMongo)
+		return storageMongo
+	}
+}
+
+func cutPrefixFold(value string, prefix string) (string, bool) {
+	if len(value) < len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return value, false
+	}
+	return value[len(prefix):], true
+}
+
+// newDbService creates the db service of the collection on the selected storage
+func newDbService[DocType interface{}](storage string, collection string) db_service.DbService[DocType] {
+	if path, ok := strings.CutPrefix(storage, storageFilePrefix); ok {
+		// an empty path falls back to AMBULANCE_API_FILE_PATH
+		return db_service.NewFileService[DocType](db_service.FileServiceConfig{
+			Path:       path,
+			Collection: collection,
+		})
+	}
+
+	switch storage {
+	case storageMemory:
+		return db_service.NewMemoryService[DocType](db_service.MemoryServiceConfig{
+			Collection: collection,
+		})
+	case storagePostgres:
+		return db_service.NewPostgresService[DocType](db_service.PostgresServiceConfig{
+			Collection: collection,
+		})
+	default:
+		return db_service.NewMongoService[DocType](db_service.MongoServiceConfig{
+			Collection: collection,
+		})
+	}
+}
+
+// cacheConfigFromEnv returns the cache of the collection, AMBULANCE_API_CACHE_TTL_<COLLECTION>
+// overrides AMBULANCE_API_CACHE_TTL, caching is disabled by default
+func cacheConfigFromEnv(collection string) db_service.CacheConfig {
+	config := db_service.CacheConfig{Collection: collection}
+	for _, name := range []string{
+		"AMBULANCE_API_CACHE_TTL",
+		"AMBULANCE_API_CACHE_TTL_" + strings.ToUpper(collection),
+	} {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			log.Warn().Str(name, value).Msgf("Invalid cache TTL, using: %s", config.TTL)
+			continue
+		}
+		config.TTL = ttl
+	}
+	if value, ok := os.LookupEnv("AMBULANCE_API_CACHE_MAX_ENTRIES"); ok {
+		if maxEntries, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			config.MaxEntries = maxEntries
+		} else {
+			log.Warn().Str("AMBULANCE_API_CACHE_MAX_ENTRIES", value).Msg("Invalid cache size, using default")
+		}
+	}
+	return config
+}
+
+// newCachedDbService creates the db service of the collection behind its cache, all collections
+// are wrapped so the caches follow the transactions spanning several collections
+func newCachedDbService[DocType interface{}](storage string, collection string) db_service.DbService[DocType] {
+	config := cacheConfigFromEnv(collection)
+	if config.TTL > 0 {
+		log.Info().Str("collection", collection).Dur("ttl", config.TTL).Msg("Caching reads")
+	}
+	return db_service.NewCachedService(newDbService[DocType](storage, collection), config)
+}
@@ -0,0 +1,366 @@
+// One-off migration rewriting documents stored before the models got explicit bson tags.
+// The driver used to persist lowercased go field names (e.g. `departmentid`, `firstname`),
+// this command renames them to the persisted schema (e.g. `department_id`, `first_name`).
+// Afterwards it fills the normalized search fields of patients written before patient search.
+// With -hospital it assigns the documents stored before multi-hospital tenancy to the hospital.
+// Last it moves the documents of hospitals under the keys prefixed with their hospital.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/psabol571/sarsabsim-webapi/internal/hospital_mgmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func main() {
+	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: zerolog.TimeFormatUnix}
+	log.Logger = zerolog.New(output).With().
+		Str("service", "ambulance-wl-migrate-schema").
+		Timestamp().
+		Logger()
+
+	dryRun := flag.Bool("dry-run", false, "only report documents which would be rewritten")
+	hospital := flag.String("hospital", "", "id of the hospital the documents without one are assigned to")
+	flag.Parse()
+
+	collections := []struct {
+		name  string
+		model reflect.Type
+	}{
+		{"departments", reflect.TypeOf(hospital_mgmt.Department{})},
+		{"beds", reflect.TypeOf(hospital_mgmt.Bed{})},
+		{"patients", reflect.TypeOf(hospital_mgmt.Patient{})},
+	}
+
+	ctx := context.Background()
+	failed := false
+	for _, collection := range collections {
+		migrated, err := migrateCollection(ctx, collection.name, fieldNames(collection.model), *dryRun)
+		if err != nil {
+			log.Error().Err(err).Str("collection", collection.name).Msg("Migration failed")
+			failed = true
+			continue
+		}
+		log.Info().
+			Str("collection", collection.name).
+			Int("documents", migrated).
+			Bool("dry_run", *dryRun).
+			Msg("Collection migrated")
+	}
+
+	if !failed {
+		updated, err := backfillPatientSearchTerms(ctx, *dryRun)
+		if err != nil {
+			log.Error().Err(err).Msg("Backfill of patient search terms failed")
+			failed = true
+		} else {
+			log.Info().Int("documents", updated).Bool("dry_run", *dryRun).Msg("Patient search terms filled")
+		}
+	}
+
+	if !failed && *hospital != "" {
+		if err := assignHospital(ctx, *hospital, *dryRun); err != nil {
+			log.Error().Err(err).Str("hospital", *hospital).Msg("Assignment of the hospital failed")
+			failed = true
+		}
+	}
+
+	if !failed {
+		for _, collection := range hospitalCollections {
+			keyed, err := keyCollectionDocuments(ctx, collection, *dryRun)
+			if err != nil {
+				log.Error().Err(err).Str("collection", collection).Msg("Keying of the documents failed")
+				failed = true
+				break
+			}
+			log.Info().
+				Str("collection", collection).
+				Int("documents", keyed).
+				Bool("dry_run", *dryRun).
+				Msg("Documents keyed by their hospital")
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// hospitalCollections keep documents belonging to a hospital
+var hospitalCollections = []string{
+	"departments", "beds", "patients", "audit",
+	"department_revisions", "bed_revisions", "patient_revisions",
+}
+
+// assignHospital creates the hospital unless it exists and assigns the documents without
+// a hospital to it
+func assignHospital(ctx context.Context, hospitalId string, dryRun bool) error {
+	hospitals := db_service.NewMongoService[hospital_mgmt.Hospital](db_service.MongoServiceConfig{
+		Collection: "hospitals",
+	})
+	defer hospitals.Disconnect(ctx)
+
+	_, err := hospitals.FindDocument(ctx, hospitalId)
+	switch {
+	case err == db_service.ErrNotFound && !dryRun:
+		now := time.Now()
+		err = hospitals.CreateDocument(ctx, hospitalId, &hospital_mgmt.Hospital{
+			Id:        hospitalId,
+			Name:      hospitalId,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   1,
+		})
+		if err != nil {
+			return err
+		}
+		log.Info().Str("hospital", hospitalId).Msg("Hospital created")
+	case err == db_service.ErrNotFound:
+		log.Info().Str("hospital", hospitalId).Bool("dry_run", dryRun).Msg("Hospital created")
+	case err != nil:
+		return err
+	}
+
+	for _, collection := range hospitalCollections {
+		assigned, err := assignCollectionHospital(ctx, collection, hospitalId, dryRun)
+		if err != nil {
+			return err
+		}
+		log.Info().
+			Str("collection", collection).
+			Str("hospital", hospitalId).
+			Int("documents", assigned).
+			Bool("dry_run", dryRun).
+			Msg("Documents assigned to the hospital")
+	}
+	return nil
+}
+
+func assignCollectionHospital(ctx context.Context, collection string, hospitalId string, dryRun bool) (int, error) {
+	db := db_service.NewMongoService[bson.M](db_service.MongoServiceConfig{
+		Collection: collection,
+	})
+	defer db.Disconnect(ctx)
+
+	documents, err := db.FindDocumentsByFilter(ctx, bson.M{"hospital_id": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+
+	assigned := 0
+	for _, document := range documents {
+		id, ok := (*document)["id"].(string)
+		if !ok {
+			log.Warn().Str("collection", collection).Interface("_id", (*document)["_id"]).
+				Msg("Document without id cannot be assigned, skipping")
+			continue
+		}
+		assigned++
+		if dryRun {
+			continue
+		}
+		if err := db.UpdateDocumentFields(ctx, id, map[string]interface{}{"hospital_id": hospitalId}, nil); err != nil {
+			return assigned, err
+		}
+	}
+	return assigned, nil
+}
+
+// keyCollectionDocuments moves the documents of hospitals stored under their plain id under
+// the key prefixed with the hospital, the api finds them only there
+func keyCollectionDocuments(ctx context.Context, collection string, dryRun bool) (int, error) {
+	db := db_service.NewMongoService[bson.M](db_service.MongoServiceConfig{
+		Collection: collection,
+	})
+	defer db.Disconnect(ctx)
+
+	documents, err := db.FindDocumentsByFilter(ctx, bson.M{"hospital_id": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, err
+	}
+
+	keyed := 0
+	for _, document := range documents {
+		id, ok := (*document)["id"].(string)
+		hospitalId, _ := (*document)["hospital_id"].(string)
+		if !ok || hospitalId == "" {
+			log.Warn().Str("collection", collection).Interface("_id", (*document)["_id"]).
+				Msg("Document without id or hospital cannot be keyed, skipping")
+			continue
+		}
+		if strings.HasPrefix(id, hospital_mgmt.HospitalDocumentKey(hospitalId, "")) {
+			continue
+		}
+		keyed++
+		if dryRun {
+			continue
+		}
+		key := hospital_mgmt.HospitalDocumentKey(hospitalId, id)
+		if err := db.UpdateDocumentFields(ctx, id, map[string]interface{}{"id": key}, nil); err != nil {
+			return keyed, err
+		}
+	}
+	return keyed, nil
+}
+
+func migrateCollection(ctx context.Context, collection string, names map[string]string, dryRun bool) (int, error) {
+	db := db_service.NewMongoService[bson.M](db_service.MongoServiceConfig{
+		Collection: collection,
+	})
+	defer db.Disconnect(ctx)
+
+	documents, err := db.FindAllDocuments(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, document := range documents {
+		if !renameFields(*document, names) {
+			continue
+		}
+		id, ok := (*document)["id"].(string)
+		if !ok {
+			log.Warn().Str("collection", collection).Interface("_id", (*document)["_id"]).
+				Msg("Document without id cannot be migrated, skipping")
+			continue
+		}
+		migrated++
+		if dryRun {
+			continue
+		}
+		if err := db.UpdateDocument(ctx, id, document); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// normalizeName makes the legacy and the persisted field name comparable
+func normalizeName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// fieldNames collects bson names of the model fields, including nested structs,
+// keyed by their normalized form
+func fieldNames(model reflect.Type) map[string]string {
+	names := map[string]string{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || t.PkgPath() != model.PkgPath() {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("bson"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			names[normalizeName(name)] = name
+			collect(field.Type)
+		}
+	}
+	collect(model)
+	return names
+}
+
+// renameFields renames legacy keys of the document and all nested documents in place,
+// returns whether anything was renamed
+func renameFields(document map[string]interface{}, names map[string]string) bool {
+	renamed := false
+	for key, value := range document {
+		if renameNested(value, names) {
+			renamed = true
+		}
+		name, ok := names[normalizeName(key)]
+		if !ok || name == key {
+			continue
+		}
+		if _, exists := document[name]; exists {
+			continue // never overwrite a value already stored under the new name
+		}
+		document[name] = value
+		delete(document, key)
+		renamed = true
+	}
+	return renamed
+}
+
+func renameNested(value interface{}, names map[string]string) bool {
+	switch nested := value.(type) {
+	case bson.M:
+		return renameFields(nested, names)
+	case map[string]interface{}:
+		return renameFields(nested, names)
+	case bson.D:
+		renamed := false
+		for i, element := range nested {
+			if renameNested(element.Value, names) {
+				renamed = true
+			}
+			if name, ok := names[normalizeName(element.Key)]; ok && name != element.Key {
+				nested[i].Key = name
+				renamed = true
+			}
+		}
+		return renamed
+	case bson.A:
+		renamed := false
+		for _, item := range nested {
+			if renameNested(item, names) {
+				renamed = true
+			}
+		}
+		return renamed
+	case []interface{}:
+		renamed := false
+		for _, item := range nested {
+			if renameNested(item, names) {
+				renamed = true
+			}
+		}
+		return renamed
+	}
+	return false
+}
+
+func backfillPatientSearchTerms(ctx context.Context, dryRun bool) (int, error) {
+	db := db_service.NewMongoService[hospital_mgmt.Patient](db_service.MongoServiceConfig{
+		Collection: "patients",
+	})
+	defer db.Disconnect(ctx)
+
+	patients, err := db.FindAllDocuments(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, patient := range patients {
+		stored := patient.SearchTerms
+		patient.UpdateSearchTerms()
+		if patient.SearchTerms == stored {
+			continue
+		}
+		updated++
+		if dryRun {
+			continue
+		}
+		if err := db.UpdateDocument(ctx, patient.Id, patient); err != nil {
+			return updated, err
+		}
+	}
+	return updated, nil
+}
@@ -21,57 +21,105 @@ while(true) {
     }
 }
 
+// transactions require a replica set, initiate a single-node one on the first start
+const admin = connection.getDB("admin")
+const replicaSetStatus = admin.runCommand({ replSetGetStatus: 1 })
+if (replicaSetStatus.code === 94) { // NotYetInitialized
+    print(`Initiating replica set rs0 with member ${mongoHost}:${mongoPort}`)
+    admin.runCommand({
+        replSetInitiate: {
+            _id: "rs0",
+            members: [{ _id: 0, host: `${mongoHost}:${mongoPort}` }]
+        }
+    })
+}
+while (!admin.runCommand({ hello: 1 }).isWritablePrimary) {
+    print("Waiting for the replica set primary")
+    sleep(1000)
+}
+
 // Create database
 const db = connection.getDB(database)
 
+// Initialize hospitals collection, the documents of the other collections belong to one of them
+const hospitalsCollection = 'hospitals'
+if (!db.getCollectionNames().includes(hospitalsCollection)) {
+    db.createCollection(hospitalsCollection)
+    db[hospitalsCollection].createIndex({ "id": 1 }, { unique: true })
+    db[hospitalsCollection].createIndex({ "name": 1 })
+
+    db[hospitalsCollection].insertMany([
+        {
+            "id": "hospital-1",
+            "name": "Fakultná nemocnica",
+            "address": "Mickiewiczova 13, 813 69 Bratislava",
+            "created_at": new Date(),
+            "updated_at": new Date(),
+            "version": 1
+        }
+    ])
+}
+
 // Initialize departments collection
 const departmentsCollection = 'departments'
 if (!db.getCollectionNames().includes(departmentsCollection)) {
     db.createCollection(departmentsCollection)
     db[departmentsCollection].createIndex({ "id": 1 }, { unique: true })
+    // every query of the api is scoped to a hospital
+    db[departmentsCollection].createIndex({ "hospital_id": 1 })
     db[departmentsCollection].createIndex({ "name": 1 })
     db[departmentsCollection].createIndex({ "floor": 1 })
+    // soft deleted documents, found by the purge
+    db[departmentsCollection].createIndex({ "deleted_at": 1 }, { sparse: true })
 
-    // Insert sample departments
+    // Insert sample departments, actual_beds and occupied_beds count the sample beds below,
+    // the api maintains them from the beds collection. Documents of a hospital are stored
+    // under their id prefixed with the hospital, references between them use the plain ids.
     db[departmentsCollection].insertMany([
         {
-            "id": "internal-med",
+            "id": "hospital-1/internal-med",
+            "hospital_id": "hospital-1",
             "name": "Interné oddelenie",
             "description": "Oddelenie internej medicíny",
             "floor": 2,
             "capacity": {
                 "maximum_beds": 30,
-                "actual_beds": 25,
-                "occupied_beds": 20
+                "actual_beds": 2,
+                "occupied_beds": 0
             },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         },
         {
-            "id": "surgery",
+            "id": "hospital-1/surgery",
+            "hospital_id": "hospital-1",
             "name": "Chirurgické oddelenie",
             "description": "Oddelenie všeobecnej chirurgie",
             "floor": 3,
             "capacity": {
                 "maximum_beds": 25,
-                "actual_beds": 20,
-                "occupied_beds": 15
+                "actual_beds": 1,
+                "occupied_beds": 0
             },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         },
         {
-            "id": "pediatric",
+            "id": "hospital-1/pediatric",
+            "hospital_id": "hospital-1",
             "name": "Pediatrické oddelenie",
             "description": "Oddelenie detskej medicíny",
             "floor": 4,
             "capacity": {
                 "maximum_beds": 20,
-                "actual_beds": 18,
-                "occupied_beds": 12
+                "actual_beds": 1,
+                "occupied_beds": 0
             },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         }
     ])
 }
@@ -81,13 +129,19 @@ const bedsCollection = 'beds'
 if (!db.getCollectionNames().includes(bedsCollection)) {
     db.createCollection(bedsCollection)
     db[bedsCollection].createIndex({ "id": 1 }, { unique: true })
+    // every query of the api is scoped to a hospital
+    db[bedsCollection].createIndex({ "hospital_id": 1 })
     db[bedsCollection].createIndex({ "department_id": 1 })
     db[bedsCollection].createIndex({ "bed_type": 1 })
+    db[bedsCollection].createIndex({ "status.patient_id": 1 })
+    // soft deleted documents, found by the purge
+    db[bedsCollection].createIndex({ "deleted_at": 1 }, { sparse: true })
 
     // Insert sample beds
     db[bedsCollection].insertMany([
         {
-            "id": "int-101",
+            "id": "hospital-1/int-101",
+            "hospital_id": "hospital-1",
             "department_id": "internal-med",
             "bed_type": "standard",
             "bed_quality": 0.9,
@@ -96,10 +150,12 @@ if (!db.getCollectionNames().includes(bedsCollection)) {
                 "description": "Available"
             },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         },
         {
-            "id": "int-102",
+            "id": "hospital-1/int-102",
+            "hospital_id": "hospital-1",
             "department_id": "internal-med",
             "bed_type": "intensive",
             "bed_quality": 1.0,
@@ -108,10 +164,12 @@ if (!db.getCollectionNames().includes(bedsCollection)) {
                 "description": "Under maintenance"
             },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         },
         {
-            "id": "surg-201",
+            "id": "hospital-1/surg-201",
+            "hospital_id": "hospital-1",
             "department_id": "surgery",
             "bed_type": "post-op",
             "bed_quality": 0.95,
@@ -120,10 +178,12 @@ if (!db.getCollectionNames().includes(bedsCollection)) {
                 "description": "Available"
             },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         },
         {
-            "id": "ped-301",
+            "id": "hospital-1/ped-301",
+            "hospital_id": "hospital-1",
             "department_id": "pediatric",
             "bed_type": "children",
             "bed_quality": 0.85,
@@ -132,7 +192,8 @@ if (!db.getCollectionNames().includes(bedsCollection)) {
                 "description": "Available"
             },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         }
     ])
 }
@@ -142,13 +203,20 @@ const patientsCollection = 'patients'
 if (!db.getCollectionNames().includes(patientsCollection)) {
     db.createCollection(patientsCollection)
     db[patientsCollection].createIndex({ "id": 1 }, { unique: true })
+    // every query of the api is scoped to a hospital
+    db[patientsCollection].createIndex({ "hospital_id": 1 })
     db[patientsCollection].createIndex({ "last_name": 1 })
     db[patientsCollection].createIndex({ "birth_date": 1 })
+    db[patientsCollection].createIndex({ "search.last_name": 1, "search.first_name": 1 })
+    db[patientsCollection].createIndex({ "search.first_name": 1 })
+    // soft deleted documents, found by the purge
+    db[patientsCollection].createIndex({ "deleted_at": 1 }, { sparse: true })
 
     // Insert sample patients
     db[patientsCollection].insertMany([
         {
-            "id": "pat-001",
+            "id": "hospital-1/pat-001",
+            "hospital_id": "hospital-1",
             "first_name": "Ján",
             "last_name": "Novák",
             "birth_date": "1975-05-15",
@@ -161,11 +229,19 @@ if (!db.getCollectionNames().includes(patientsCollection)) {
                     "description": "Hospitalizácia pre srdcové problémy"
                 }
             ],
+            "search": {
+                "first_name": "jan",
+                "last_name": "novak",
+                "email": "jan.novak@email.sk",
+                "phone": "421903123456"
+            },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         },
         {
-            "id": "pat-002",
+            "id": "hospital-1/pat-002",
+            "hospital_id": "hospital-1",
             "first_name": "Eva",
             "last_name": "Kováčová",
             "birth_date": "1988-09-23",
@@ -178,11 +254,19 @@ if (!db.getCollectionNames().includes(patientsCollection)) {
                     "description": "Pooperačná starostlivosť"
                 }
             ],
+            "search": {
+                "first_name": "eva",
+                "last_name": "kovacova",
+                "email": "eva.kovacova@email.sk",
+                "phone": "421905789012"
+            },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         },
         {
-            "id": "pat-003",
+            "id": "hospital-1/pat-003",
+            "hospital_id": "hospital-1",
             "first_name": "Michal",
             "last_name": "Horváth",
             "birth_date": "2018-12-10",
@@ -193,12 +277,40 @@ if (!db.getCollectionNames().includes(patientsCollection)) {
                     "description": "Pediatrické vyšetrenie"
                 }
             ],
+            "search": {
+                "first_name": "michal",
+                "last_name": "horvath",
+                "email": "",
+                "phone": ""
+            },
             "created_at": new Date(),
-            "updated_at": new Date()
+            "updated_at": new Date(),
+            "version": 1
         }
     ])
 }
 
+// Initialize audit collection, entries are only ever inserted
+const auditCollection = 'audit'
+if (!db.getCollectionNames().includes(auditCollection)) {
+    db.createCollection(auditCollection)
+    db[auditCollection].createIndex({ "id": 1 }, { unique: true })
+    db[auditCollection].createIndex({ "entity": 1, "entity_id": 1, "id": 1 })
+    db[auditCollection].createIndex({ "actor": 1 })
+    db[auditCollection].createIndex({ "hospital_id": 1 })
+}
+
+// Initialize revision collections, one revision is inserted by every write of the documents
+for (const revisionCollection of ['department_revisions', 'bed_revisions', 'patient_revisions']) {
+    if (!db.getCollectionNames().includes(revisionCollection)) {
+        db.createCollection(revisionCollection)
+        db[revisionCollection].createIndex({ "id": 1 }, { unique: true })
+        db[revisionCollection].createIndex({ "entity_id": 1, "id": 1 })
+        db[revisionCollection].createIndex({ "entity_id": 1, "valid_from": 1 })
+        db[revisionCollection].createIndex({ "hospital_id": 1 })
+    }
+}
+
 // exit with success
 print("Database initialization completed successfully")
 process.exit(0);
\ No newline at end of file
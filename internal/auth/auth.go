@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+)
+
+// context keys of the authenticated request
+const (
+	SubjectKey = "auth_subject"
+	ClaimsKey  = "auth_claims"
+)
+
+// codes of the problems the requests are refused with
+const (
+	codeTokenMissing = "TOKEN_MISSING"
+	codeTokenExpired = "TOKEN_EXPIRED"
+	codeTokenInvalid = "TOKEN_INVALID"
+)
+
+var (
+	errMissingToken   = errors.New("missing bearer token")
+	errMissingSubject = errors.New("token has no subject")
+	errUnknownKey     = errors.New("token is signed with an unknown key")
+)
+
+type Config struct {
+	// Secret verifying tokens signed with HS256, the algorithm is refused without it
+	Secret []byte
+	// JWKSFile is the path of a JSON Web Key Set with the RSA keys verifying tokens signed
+	// with RS256, the algorithm is refused without it
+	JWKSFile string
+	// Issuer and Audience the tokens have to be issued by and for, not checked if empty
+	Issuer   string
+	Audience string
+	// Leeway tolerated in the expiry and not before times of the tokens
+	Leeway time.Duration
+	// ExemptPaths are served without a token
+	ExemptPaths []string
+}
+
+// authenticator verifies the bearer tokens of the requests
+type authenticator struct {
+	parser *jwt.Parser
+	secret []byte
+	keys   map[string]interface{}
+	exempt map[string]bool
+}
+
+// NewMiddleware creates a gin middleware rejecting requests without a valid bearer token with
+// 401 Unauthorized. The subject and the claims of the token are set in the gin context under
+// SubjectKey and ClaimsKey. Empty fields of the config fall back to the environment.
+func NewMiddleware(config Config) (gin.HandlerFunc, error) {
+	enviro := func(name string, defaultValue string) string {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return defaultValue
+	}
+
+	if len(config.Secret) == 0 {
+		config.Secret = []byte(enviro("AMBULANCE_API_AUTH_HS256_SECRET", ""))
+	}
+	if config.JWKSFile == "" {
+		config.JWKSFile = enviro("AMBULANCE_API_AUTH_JWKS_FILE", "")
+	}
+	if config.Issuer == "" {
+		config.Issuer = enviro("AMBULANCE_API_AUTH_ISSUER", "")
+	}
+	if config.Audience == "" {
+		config.Audience = enviro("AMBULANCE_API_AUTH_AUDIENCE", "")
+	}
+	if config.Leeway == 0 {
+		seconds := enviro("AMBULANCE_API_AUTH_LEEWAY_SECONDS", "30")
+		if seconds, err := strconv.Atoi(seconds); err == nil {
+			config.Leeway = time.Duration(seconds) * time.Second
+		} else {
+			log.Printf("Invalid leeway value: %v", seconds)
+			config.Leeway = 30 * time.Second
+		}
+	}
+
+	a := &authenticator{
+		secret: config.Secret,
+		keys:   map[string]interface{}{},
+		exempt: map[string]bool{},
+	}
+	methods := []string{}
+	if len(a.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.JWKSFile != "" {
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	for _, path := range config.ExemptPaths {
+		a.exempt[path] = true
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	a.parser = jwt.NewParser(options...)
+	return a.authenticate, nil
+}
+
+func (a *authenticator) authenticate(c *gin.Context) {
+	if a.exempt[c.Request.URL.Path] {
+		c.Next()
+		return
+	}
+
+	token, ok := bearerToken(c.GetHeader("Authorization"))
+	if !ok {
+		reject(c, errMissingToken)
+		return
+	}
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.key); err != nil {
+		reject(c, err)
+		return
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		reject(c, errMissingSubject)
+		return
+	}
+
+	c.Set(SubjectKey, subject)
+	c.Set(ClaimsKey, claims)
+	c.Next()
+}
+
+// key returns the key verifying the signature of the token, the parser has already checked
+// the algorithm is one of the configured ones
+func (a *authenticator) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return a.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := a.keys[kid]; ok {
+			return key, nil
+		}
+		// a token without a key id can be verified by the only key of the set
+		if kid == "" && len(a.keys) == 1 {
+			for _, key := range a.keys {
+				return key, nil
+			}
+		}
+		return nil, errUnknownKey
+	default:
+		return nil, jwt.ErrTokenUnverifiable
+	}
+}
+
+// bearerToken returns the token of the `Authorization: Bearer <token>` header
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func reject(c *gin.Context, err error) {
+	if err == errMissingToken {
+		c.Header("WWW-Authenticate", `Bearer realm="hospital-mgmt"`)
+	} else {
+		c.Header("WWW-Authenticate", `Bearer realm="hospital-mgmt", error="invalid_token"`)
+	}
+	problem.Respond(c, rejection(err))
+}
+
+// rejection tells the client whether to obtain a token, refresh it or get a valid one, the
+// reason the token is invalid is not disclosed
+func rejection(err error) *problem.Problem {
+	switch {
+	case err == errMissingToken:
+		return problem.New(http.StatusUnauthorized, codeTokenMissing, "A bearer token is required")
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return problem.New(http.StatusUnauthorized, codeTokenExpired, "The bearer token has expired")
+	default:
+		return problem.New(http.StatusUnauthorized, codeTokenInvalid, "The bearer token is not valid")
+	}
+}
+
+// Subject returns the subject of the token the request is authenticated with
+func Subject(c *gin.Context) (string, bool) {
+	subject, ok := c.Get(SubjectKey)
+	if !ok {
+		return "", false
+	}
+	value, ok := subject.(string)
+	return value, ok
+}
+
+// Claims returns the claims of the token the request is authenticated with, nil if it is not
+func Claims(c *gin.Context) jwt.MapClaims {
+	value, _ := c.Get(ClaimsKey)
+	claims, _ := value.(jwt.MapClaims)
+	return claims
+}
+
+// Roles returns the roles of the user in the `roles` claim of the token, a list of names or
+// a space separated string
+func Roles(c *gin.Context) []string {
+	switch roles := Claims(c)["roles"].(type) {
+	case string:
+		return strings.Fields(roles)
+	case []interface{}:
+		names := make([]string, 0, len(roles))
+		for _, role := range roles {
+			if name, ok := role.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	default:
+		return nil
+	}
+}
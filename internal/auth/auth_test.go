@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("test-secret-of-at-least-32-bytes!")
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testServer answers the protected /api/patients and the exempt /openapi with the subject
+// of the request
+func testServer(t *testing.T, config Config) *gin.Engine {
+	t.Helper()
+	config.ExemptPaths = append(config.ExemptPaths, "/openapi")
+	middleware, err := NewMiddleware(config)
+	require.NoError(t, err)
+
+	engine := gin.New()
+	engine.Use(middleware)
+	handler := func(c *gin.Context) {
+		subject, _ := Subject(c)
+		c.JSON(http.StatusOK, gin.H{"subject": subject, "claims": Claims(c), "roles": Roles(c)})
+	}
+	engine.GET("/api/patients", handler)
+	engine.GET("/openapi", handler)
+	return engine
+}
+
+func request(engine *gin.Engine, path string, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func validClaims(subject string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": subject,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func signHS256(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	require.NoError(t, err)
+	return token
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+// writeJWKS writes the public keys as a key set file, keyed by their key ids
+func writeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+	set := jsonWebKeySet{}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func requireSubject(t *testing.T, response *httptest.ResponseRecorder, subject string) map[string]interface{} {
+	t.Helper()
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	var body struct {
+		Subject string                 `json:"subject"`
+		Claims  map[string]interface{} `json:"claims"`
+	}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, subject, body.Subject)
+	return body.Claims
+}
+
+func requireUnauthorized(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+	require.Equal(t, http.StatusUnauthorized, response.Code, response.Body.String())
+	assert.Contains(t, response.Header().Get("WWW-Authenticate"), "Bearer")
+	assert.Equal(t, problem.ContentType, response.Header().Get("Content-Type"))
+
+	var body problem.Problem
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, http.StatusUnauthorized, body.Status)
+	assert.Equal(t, problem.TypeOf(body.Code), body.Type)
+	return body.Code
+}
+
+func TestHS256(t *testing.T) {
+	engine := testServer(t, Config{Secret: testSecret})
+
+	claims := validClaims("nurse-1")
+	claims["roles"] = []string{"nurse"}
+	received := requireSubject(t, request(engine, "/api/patients", "Bearer "+signHS256(t, testSecret, claims)), "nurse-1")
+	assert.Equal(t, []interface{}{"nurse"}, received["roles"])
+	requireSubject(t, request(engine, "/api/patients", "bearer "+signHS256(t, testSecret, validClaims("nurse-1"))), "nurse-1")
+
+	assert.Equal(t, codeTokenMissing, requireUnauthorized(t, request(engine, "/api/patients", "")))
+	assert.Equal(t, codeTokenMissing, requireUnauthorized(t, request(engine, "/api/patients", "Bearer")))
+	assert.Equal(t, codeTokenMissing, requireUnauthorized(t, request(engine, "/api/patients", "Basic bnVyc2U6c2VjcmV0")))
+	assert.Equal(t, codeTokenInvalid, requireUnauthorized(t, request(engine, "/api/patients", "Bearer not-a-token")))
+	assert.Equal(t, codeTokenInvalid, requireUnauthorized(t, request(engine, "/api/patients",
+		"Bearer "+signHS256(t, []byte("another-secret-of-at-least-32-bytes"), validClaims("nurse-1")))))
+}
+
+func TestRoles(t *testing.T) {
+	engine := testServer(t, Config{Secret: testSecret})
+	roles := func(value interface{}) []string {
+		claims := validClaims("nurse-1")
+		if value != nil {
+			claims["roles"] = value
+		}
+		response := request(engine, "/api/patients", "Bearer "+signHS256(t, testSecret, claims))
+		require.Equal(t, http.StatusOK, response.Code)
+		var body struct {
+			Roles []string `json:"roles"`
+		}
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+		return body.Roles
+	}
+
+	assert.Equal(t, []string{"nurse", "clerk"}, roles([]interface{}{"nurse", "clerk"}))
+	assert.Equal(t, []string{"nurse", "clerk"}, roles("nurse clerk"))
+	assert.Equal(t, []string{"nurse"}, roles([]interface{}{"nurse", 42}))
+	assert.Empty(t, roles(nil))
+	assert.Empty(t, roles(map[string]interface{}{"role": "admin"}))
+}
+
+func TestRejectedClaims(t *testing.T) {
+	engine := testServer(t, Config{Secret: testSecret, Issuer: "hospital-idp", Audience: "hospital-mgmt"})
+	claims := func(change func(claims jwt.MapClaims)) string {
+		claims := validClaims("nurse-1")
+		claims["iss"] = "hospital-idp"
+		claims["aud"] = "hospital-mgmt"
+		change(claims)
+		return "Bearer " + signHS256(t, testSecret, claims)
+	}
+
+	requireSubject(t, request(engine, "/api/patients", claims(func(jwt.MapClaims) {})), "nurse-1")
+	for name, change := range map[string]func(claims jwt.MapClaims){
+		"expired":       func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":     func(claims jwt.MapClaims) { delete(claims, "exp") },
+		"not yet valid": func(claims jwt.MapClaims) { claims["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"no subject":    func(claims jwt.MapClaims) { delete(claims, "sub") },
+		"empty subject": func(claims jwt.MapClaims) { claims["sub"] = "" },
+		"issuer":        func(claims jwt.MapClaims) { claims["iss"] = "another-idp" },
+		"audience":      func(claims jwt.MapClaims) { claims["aud"] = "another-api" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			code := requireUnauthorized(t, request(engine, "/api/patients", claims(change)))
+			if name == "expired" {
+				assert.Equal(t, codeTokenExpired, code)
+			} else {
+				assert.Equal(t, codeTokenInvalid, code)
+			}
+		})
+	}
+
+	// expiry within the leeway is tolerated
+	requireSubject(t, request(engine, "/api/patients", claims(func(claims jwt.MapClaims) {
+		claims["exp"] = time.Now().Add(-5 * time.Second).Unix()
+	})), "nurse-1")
+}
+
+func TestRS256(t *testing.T) {
+	key1, key2, unknown := rsaKey(t), rsaKey(t), rsaKey(t)
+	engine := testServer(t, Config{JWKSFile: writeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key1, "key-2": key2})})
+
+	requireSubject(t, request(engine, "/api/patients", "Bearer "+signRS256(t, key1, "key-1", validClaims("doctor-1"))), "doctor-1")
+	requireSubject(t, request(engine, "/api/patients", "Bearer "+signRS256(t, key2, "key-2", validClaims("doctor-2"))), "doctor-2")
+
+	requireUnauthorized(t, request(engine, "/api/patients", "Bearer "+signRS256(t, key1, "key-2", validClaims("doctor-1"))))
+	requireUnauthorized(t, request(engine, "/api/patients", "Bearer "+signRS256(t, unknown, "key-3", validClaims("doctor-1"))))
+	// the key id is required with more keys in the set
+	requireUnauthorized(t, request(engine, "/api/patients", "Bearer "+signRS256(t, key1, "", validClaims("doctor-1"))))
+	// HS256 is not accepted without a secret
+	requireUnauthorized(t, request(engine, "/api/patients", "Bearer "+signHS256(t, testSecret, validClaims("doctor-1"))))
+}
+
+func TestRS256SingleKey(t *testing.T) {
+	key := rsaKey(t)
+	engine := testServer(t, Config{Secret: testSecret, JWKSFile: writeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key})})
+
+	requireSubject(t, request(engine, "/api/patients", "Bearer "+signRS256(t, key, "", validClaims("doctor-1"))), "doctor-1")
+	requireSubject(t, request(engine, "/api/patients", "Bearer "+signHS256(t, testSecret, validClaims("clerk-1"))), "clerk-1")
+}
+
+// a token signed with HS256 by the public key of the set is not verified by the key
+func TestAlgorithmConfusion(t *testing.T) {
+	key := rsaKey(t)
+	engine := testServer(t, Config{JWKSFile: writeJWKS(t, map[string]*rsa.PrivateKey{"key-1": key})})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims("doctor-1"))
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(key.PublicKey.N.Bytes())
+	require.NoError(t, err)
+	requireUnauthorized(t, request(engine, "/api/patients", "Bearer "+signed))
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims("doctor-1")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	requireUnauthorized(t, request(engine, "/api/patients", "Bearer "+unsigned))
+}
+
+func TestExemptPaths(t *testing.T) {
+	engine := testServer(t, Config{Secret: testSecret})
+
+	requireSubject(t, request(engine, "/openapi", ""), "")
+	requireSubject(t, request(engine, "/openapi", "Bearer "+signHS256(t, testSecret, validClaims("nurse-1"))), "")
+	requireUnauthorized(t, request(engine, "/openapi/../api/patients", ""))
+}
+
+func TestNoKeys(t *testing.T) {
+	engine := testServer(t, Config{})
+	requireUnauthorized(t, request(engine, "/api/patients", "Bearer "+signHS256(t, testSecret, validClaims("nurse-1"))))
+	requireSubject(t, request(engine, "/openapi", ""), "")
+}
+
+func TestInvalidJWKS(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	for name, path := range map[string]string{
+		"missing file": filepath.Join(dir, "missing.json"),
+		"not json":     write("invalid.json", "keys"),
+		"no rsa key":   write("ec.json", `{"keys":[{"kty":"EC","kid":"key-1","crv":"P-256"}]}`),
+		"encryption":   write("enc.json", `{"keys":[{"kty":"RSA","kid":"key-1","use":"enc","n":"AQAB","e":"AQAB"}]}`),
+		"bad modulus":  write("modulus.json", `{"keys":[{"kty":"RSA","kid":"key-1","n":"!!","e":"AQAB"}]}`),
+		"duplicate kid": write("duplicate.json",
+			`{"keys":[{"kty":"RSA","kid":"key-1","n":"AQAB","e":"AQAB"},{"kty":"RSA","kid":"key-1","n":"AQAB","e":"AQAB"}]}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewMiddleware(Config{JWKSFile: path})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package db_service
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type CacheConfig struct {
+	// Collection labels the cache metrics
+	Collection string
+	// How long a read result is reused, zero disables caching
+	TTL time.Duration
+	// Maximal number of cached read results, 1000 by default
+	MaxEntries int
+	// Meter of the hit and miss counters, the global meter provider by default
+	Meter metric.Meter
+}
+
+// cachedSvc is a read-through cache in front of another DbService. Reads outside of
+// transactions are served from the cache until their TTL expires, every write of the
+// collection drops all cached results. Only writes made through this process are seen,
+// writes of other processes become visible when the TTL expires.
+type cachedSvc[DocType interface{}] struct {
+	CacheConfig
+	db DbService[DocType]
+
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+	// generation is increased by every invalidation, a read started before it is not cached
+	generation uint64
+
+	hits   metric.Int64Counter
+	misses metric.Int64Counter
+	// now is replaced by tests
+	now func() time.Time
+}
+
+// cacheEntry holds the persisted form of the result, callers get their own decoded copy
+type cacheEntry struct {
+	documents  []bson.Raw
+	totalCount int64
+	expires    time.Time
+}
+
+// cacheTransaction collects the caches written in a transaction, they are invalidated
+// once more when the transaction ends so reads made meanwhile are not kept
+type cacheTransaction struct {
+	lock        sync.Mutex
+	invalidates []func()
+}
+
+type cacheTransactionKey struct{}
+
+// NewCachedService wraps the db service with a read-through cache. Every service taking part
+// in transactions has to be wrapped, even with caching disabled, so the cache knows which
+// reads and writes belong to a transaction.
+func NewCachedService[DocType interface{}](db DbService[DocType], config CacheConfig) DbService[DocType] {
+	svc := &cachedSvc[DocType]{
+		CacheConfig: config,
+		db:          db,
+		entries:     map[string]cacheEntry{},
+		now:         time.Now,
+	}
+	if svc.MaxEntries <= 0 {
+		svc.MaxEntries = 1000
+	}
+	if svc.Meter == nil {
+		svc.Meter = otel.Meter("CachedService")
+	}
+
+	var err error
+	svc.hits, err = svc.Meter.Int64Counter("db_service.cache.hits",
+		metric.WithDescription("Reads served from the cache"))
+	if err != nil {
+		otel.Handle(err)
+	}
+	svc.misses, err = svc.Meter.Int64Counter("db_service.cache.misses",
+		metric.WithDescription("Reads passed to the database"))
+	if err != nil {
+		otel.Handle(err)
+	}
+	return svc
+}
+
+// inTransaction reports whether the context carries a transaction started through a cached service
+func inTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(cacheTransactionKey{}).(*cacheTransaction)
+	return ok
+}
+
+// read returns the cached result of the key or loads and caches it. Reads in a transaction
+// and reads with caching disabled always go to the database.
+func (m *cachedSvc[DocType]) read(
+	ctx context.Context,
+	operation string,
+	key string,
+	load func() ([]*DocType, int64, error),
+) ([]*DocType, int64, error) {
+	if m.TTL <= 0 || inTransaction(ctx) {
+		return load()
+	}
+
+	attributes := metric.WithAttributes(
+		attribute.String("collection", m.Collection),
+		attribute.String("operation", operation),
+	)
+
+	m.lock.Lock()
+	entry, ok := m.entries[key]
+	generation := m.generation
+	m.lock.Unlock()
+
+	if ok && m.now().Before(entry.expires) {
+		documents, err := decodeCached[DocType](entry.documents)
+		if err == nil {
+			m.record(ctx, m.hits, attributes)
+			return documents, entry.totalCount, nil
+		}
+	}
+	m.record(ctx, m.misses, attributes)
+
+	documents, totalCount, err := load()
+	if err != nil {
+		return nil, 0, err
+	}
+	raws := make([]bson.Raw, 0, len(documents))
+	for _, document := range documents {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			// not cacheable, the result is still valid
+			return documents, totalCount, nil
+		}
+		raws = append(raws, raw)
+	}
+	m.store(key, generation, cacheEntry{documents: raws, totalCount: totalCount, expires: m.now().Add(m.TTL)})
+	return documents, totalCount, nil
+}
+
+func (m *cachedSvc[DocType]) record(ctx context.Context, counter metric.Int64Counter, attributes metric.AddOption) {
+	if counter != nil {
+		counter.Add(ctx, 1, attributes)
+	}
+}
+
+// store caches the entry unless the collection was written since the read started
+func (m *cachedSvc[DocType]) store(key string, generation uint64, entry cacheEntry) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if generation != m.generation {
+		return
+	}
+	if len(m.entries) >= m.MaxEntries {
+		now := m.now()
+		for key, cached := range m.entries {
+			if !now.Before(cached.expires) {
+				delete(m.entries, key)
+			}
+		}
+		if len(m.entries) >= m.MaxEntries {
+			m.entries = map[string]cacheEntry{}
+		}
+	}
+	m.entries[key] = entry
+}
+
+func (m *cachedSvc[DocType]) invalidate() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.generation++
+	m.entries = map[string]cacheEntry{}
+}
+
+// write runs the write and drops the cached results, also after the transaction of the context ends
+func (m *cachedSvc[DocType]) write(ctx context.Context, fn func() error) error {
+	if transaction, ok := ctx.Value(cacheTransactionKey{}).(*cacheTransaction); ok {
+		transaction.lock.Lock()
+		transaction.invalidates = append(transaction.invalidates, m.invalidate)
+		transaction.lock.Unlock()
+	}
+	defer m.invalidate()
+	return fn()
+}
+
+func decodeCached[DocType interface{}](raws []bson.Raw) ([]*DocType, error) {
+	documents := make([]*DocType, 0, len(raws))
+	for _, raw := range raws {
+		document, err := decodeDocument[DocType](raw)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// queryKey identifies a query by its JSON form, which orders map keys, queries which cannot be
+// encoded are not cached
+func queryKey(operation string, query interface{}) (string, bool) {
+	encoded, err := json.Marshal(query)
+	if err != nil {
+		return "", false
+	}
+	return operation + ":" + string(encoded), true
+}
+
+func (m *cachedSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	return m.write(ctx, func() error { return m.db.CreateDocument(ctx, id, document) })
+}
+
+func (m *cachedSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	documents, _, err := m.read(ctx, "find", "find:"+id, func() ([]*DocType, int64, error) {
+		document, err := m.db.FindDocument(ctx, id)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []*DocType{document}, 1, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return documents[0], nil
+}
+
+func (m *cachedSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	return m.write(ctx, func() error { return m.db.UpdateDocument(ctx, id, document) })
+}
+
+func (m *cachedSvc[DocType]) UpdateDocumentFields(
+	ctx context.Context,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.write(ctx, func() error { return m.db.UpdateDocumentFields(ctx, id, set, unset) })
+}
+
+func (m *cachedSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return m.write(ctx, func() error { return m.db.DeleteDocument(ctx, id) })
+}
+
+func (m *cachedSvc[DocType]) UpdateDocumentVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	document *DocType,
+) error {
+	return m.write(ctx, func() error { return m.db.UpdateDocumentVersioned(ctx, id, expectedVersion, document) })
+}
+
+func (m *cachedSvc[DocType]) UpdateDocumentFieldsVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.write(ctx, func() error {
+		return m.db.UpdateDocumentFieldsVersioned(ctx, id, expectedVersion, set, unset)
+	})
+}
+
+func (m *cachedSvc[DocType]) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	return m.write(ctx, func() error { return m.db.DeleteDocumentVersioned(ctx, id, expectedVersion) })
+}
+
+func (m *cachedSvc[DocType]) FindAllDocuments(ctx context.Context) ([]*DocType, error) {
+	documents, _, err := m.read(ctx, "find_all", "find_all", func() ([]*DocType, int64, error) {
+		documents, err := m.db.FindAllDocuments(ctx)
+		return documents, int64(len(documents)), err
+	})
+	return documents, err
+}
+
+func (m *cachedSvc[DocType]) FindDocumentsByFilter(ctx context.Context, filter interface{}) ([]*DocType, error) {
+	load := func() ([]*DocType, int64, error) {
+		documents, err := m.db.FindDocumentsByFilter(ctx, filter)
+		return documents, int64(len(documents)), err
+	}
+	key, ok := queryKey("filter", filter)
+	if !ok {
+		documents, _, err := load()
+		return documents, err
+	}
+	documents, _, err := m.read(ctx, "filter", key, load)
+	return documents, err
+}
+
+func (m *cachedSvc[DocType]) FindDocumentsPage(ctx context.Context, request PageRequest) (*Page[DocType], error) {
+	load := func() ([]*DocType, int64, error) {
+		page, err := m.db.FindDocumentsPage(ctx, request)
+		if err != nil {
+			return nil, 0, err
+		}
+		return page.Documents, page.TotalCount, nil
+	}
+	var documents []*DocType
+	var totalCount int64
+	var err error
+	if key, ok := queryKey("page", request); ok {
+		documents, totalCount, err = m.read(ctx, "page", key, load)
+	} else {
+		documents, totalCount, err = load()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Page[DocType]{Documents: documents, TotalCount: totalCount}, nil
+}
+
+// WithTransaction runs the transaction of the wrapped service, reads in it bypass the cache
+// and the caches written in it are invalidated again when it ends
+func (m *cachedSvc[DocType]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inTransaction(ctx) {
+		return m.db.WithTransaction(ctx, fn)
+	}
+
+	transaction := &cacheTransaction{}
+	defer func() {
+		transaction.lock.Lock()
+		defer transaction.lock.Unlock()
+		for _, invalidate := range transaction.invalidates {
+			invalidate()
+		}
+	}()
+	return m.db.WithTransaction(context.WithValue(ctx, cacheTransactionKey{}, transaction), fn)
+}
+
+func (m *cachedSvc[DocType]) Disconnect(ctx context.Context) error {
+	m.invalidate()
+	return m.db.Disconnect(ctx)
+}
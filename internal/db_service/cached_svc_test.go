@@ -0,0 +1,289 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestCachedService(t *testing.T) {
+	run := time.Now().UnixNano()
+	runDbServiceSuite(t, func(t *testing.T, collection string) DbService[suiteDocument] {
+		dbName := fmt.Sprintf("%s-%d", t.Name(), run)
+		db := NewMemoryService[suiteDocument](MemoryServiceConfig{DbName: dbName, Collection: collection})
+		return NewCachedService(db, CacheConfig{Collection: collection, TTL: time.Minute})
+	})
+}
+
+// newTestCache returns the cache over a memory service, writes made directly to the returned
+// memory service are not seen by the cache
+func newTestCache(t *testing.T, config CacheConfig) (*cachedSvc[suiteDocument], DbService[suiteDocument]) {
+	db := NewMemoryService[suiteDocument](MemoryServiceConfig{
+		DbName:     fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano()),
+		Collection: "documents",
+	})
+	if config.Collection == "" {
+		config.Collection = "documents"
+	}
+	return NewCachedService(db, config).(*cachedSvc[suiteDocument]), db
+}
+
+func TestCachedServiceServesReadsFromCache(t *testing.T) {
+	ctx := context.Background()
+	cache, db := newTestCache(t, CacheConfig{TTL: time.Minute})
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Alpha", Version: 1}))
+
+	found, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha", found.Name)
+	all, err := cache.FindAllDocuments(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	filtered, err := cache.FindDocumentsByFilter(ctx, map[string]interface{}{"name": "Alpha"})
+	require.NoError(t, err)
+	require.Len(t, filtered, 1)
+	page, err := cache.FindDocumentsPage(ctx, PageRequest{Limit: 10})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, page.TotalCount)
+
+	// a change bypassing the cache is not seen until the cached results expire
+	require.NoError(t, db.UpdateDocumentFields(ctx, "a", map[string]interface{}{"name": "Changed"}, nil))
+	found, err = cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha", found.Name)
+	all, err = cache.FindAllDocuments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha", all[0].Name)
+	filtered, err = cache.FindDocumentsByFilter(ctx, map[string]interface{}{"name": "Alpha"})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 1)
+	page, err = cache.FindDocumentsPage(ctx, PageRequest{Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha", page.Documents[0].Name)
+
+	// callers get their own copies
+	found.Name = "Modified by caller"
+	found, err = cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha", found.Name)
+}
+
+func TestCachedServiceExpires(t *testing.T) {
+	ctx := context.Background()
+	cache, db := newTestCache(t, CacheConfig{TTL: time.Minute})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Alpha", Version: 1}))
+	_, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateDocumentFields(ctx, "a", map[string]interface{}{"name": "Changed"}, nil))
+
+	now = now.Add(59 * time.Second)
+	found, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha", found.Name)
+
+	now = now.Add(time.Second)
+	found, err = cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Changed", found.Name)
+}
+
+func TestCachedServiceWritesInvalidate(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newTestCache(t, CacheConfig{TTL: time.Minute})
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Alpha", Version: 1}))
+
+	writes := []struct {
+		name  string
+		write func() error
+	}{
+		{"create", func() error {
+			return cache.CreateDocument(ctx, "b", &suiteDocument{Id: "b", Name: "Beta", Version: 1})
+		}},
+		{"update", func() error {
+			return cache.UpdateDocument(ctx, "b", &suiteDocument{Id: "b", Name: "Beta 2", Version: 2})
+		}},
+		{"update fields", func() error {
+			return cache.UpdateDocumentFields(ctx, "b", map[string]interface{}{"name": "Beta 3"}, nil)
+		}},
+		{"update versioned", func() error {
+			return cache.UpdateDocumentVersioned(ctx, "b", 2, &suiteDocument{Id: "b", Name: "Beta 4", Version: 3})
+		}},
+		{"update fields versioned", func() error {
+			return cache.UpdateDocumentFieldsVersioned(ctx, "b", 3, map[string]interface{}{"name": "Beta 5", "version": 4}, nil)
+		}},
+		{"delete versioned", func() error {
+			return cache.DeleteDocumentVersioned(ctx, "b", 4)
+		}},
+		{"delete", func() error {
+			return cache.DeleteDocument(ctx, "a")
+		}},
+	}
+	for _, write := range writes {
+		_, err := cache.FindAllDocuments(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, cache.entries, write.name)
+
+		// a failed write invalidates as well, it might have been applied partially
+		_ = write.write()
+		assert.Empty(t, cache.entries, write.name)
+	}
+}
+
+func TestCachedServiceOrderOfWrites(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newTestCache(t, CacheConfig{TTL: time.Minute})
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Alpha", Version: 1}))
+	_, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+
+	require.NoError(t, cache.UpdateDocumentFields(ctx, "a", map[string]interface{}{"name": "Changed"}, nil))
+	found, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Changed", found.Name)
+
+	require.NoError(t, cache.DeleteDocument(ctx, "a"))
+	_, err = cache.FindDocument(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	// errors are not cached
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Again", Version: 1}))
+	found, err = cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Again", found.Name)
+}
+
+func TestCachedServiceTransaction(t *testing.T) {
+	ctx := context.Background()
+	cache, db := newTestCache(t, CacheConfig{TTL: time.Minute})
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Alpha", Version: 1}))
+	_, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateDocumentFields(ctx, "a", map[string]interface{}{"name": "Changed"}, nil))
+
+	err = cache.WithTransaction(ctx, func(ctx context.Context) error {
+		// reads in a transaction see the database, not the cache
+		found, err := cache.FindDocument(ctx, "a")
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, "Changed", found.Name)
+		assert.Len(t, cache.entries, 1)
+
+		if err := cache.UpdateDocumentFields(ctx, "a", map[string]interface{}{"name": "In transaction"}, nil); err != nil {
+			return err
+		}
+		// a result cached by a concurrent read outside of the transaction is not kept past its end
+		cache.store("find:a", cache.generation, cacheEntry{expires: time.Now().Add(time.Minute)})
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, cache.entries)
+
+	found, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "In transaction", found.Name)
+
+	// a rolled back transaction invalidates as well
+	_, err = cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	rollback := errors.New("rollback")
+	err = cache.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := cache.DeleteDocument(ctx, "a"); err != nil {
+			return err
+		}
+		return rollback
+	})
+	require.ErrorIs(t, err, rollback)
+	assert.Empty(t, cache.entries)
+}
+
+func TestCachedServiceDisabled(t *testing.T) {
+	ctx := context.Background()
+	cache, db := newTestCache(t, CacheConfig{})
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Alpha", Version: 1}))
+	_, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateDocumentFields(ctx, "a", map[string]interface{}{"name": "Changed"}, nil))
+
+	found, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Changed", found.Name)
+	assert.Empty(t, cache.entries)
+}
+
+func TestCachedServiceMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newTestCache(t, CacheConfig{TTL: time.Minute, MaxEntries: 2})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, cache.CreateDocument(ctx, id, &suiteDocument{Id: id, Version: 1}))
+	}
+
+	_, err := cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	now = now.Add(30 * time.Second)
+	_, err = cache.FindDocument(ctx, "b")
+	require.NoError(t, err)
+	require.Len(t, cache.entries, 2)
+
+	// expired results make room first
+	now = now.Add(30 * time.Second)
+	_, err = cache.FindDocument(ctx, "c")
+	require.NoError(t, err)
+	assert.Len(t, cache.entries, 2)
+	assert.Contains(t, cache.entries, "find:b")
+	assert.Contains(t, cache.entries, "find:c")
+
+	// the cache starts over if none expired
+	_, err = cache.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Len(t, cache.entries, 1)
+	assert.Contains(t, cache.entries, "find:a")
+}
+
+func TestCachedServiceMetrics(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(ctx)
+
+	cache, _ := newTestCache(t, CacheConfig{Collection: "beds", TTL: time.Minute, Meter: provider.Meter("test")})
+	require.NoError(t, cache.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Version: 1}))
+	for i := 0; i < 3; i++ {
+		_, err := cache.FindDocument(ctx, "a")
+		require.NoError(t, err)
+	}
+	_, err := cache.FindAllDocuments(ctx)
+	require.NoError(t, err)
+
+	var collected metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(ctx, &collected))
+	counts := map[string]int64{}
+	for _, scope := range collected.ScopeMetrics {
+		for _, collectedMetric := range scope.Metrics {
+			sum, ok := collectedMetric.Data.(metricdata.Sum[int64])
+			require.True(t, ok, collectedMetric.Name)
+			for _, point := range sum.DataPoints {
+				collection, _ := point.Attributes.Value(attribute.Key("collection"))
+				operation, _ := point.Attributes.Value(attribute.Key("operation"))
+				counts[fmt.Sprintf("%s %s %s", collectedMetric.Name, collection.AsString(), operation.AsString())] += point.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"db_service.cache.hits beds find":       2,
+		"db_service.cache.misses beds find":     1,
+		"db_service.cache.misses beds find_all": 1,
+	}, counts)
+}
@@ -0,0 +1,387 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// suiteDocument covers the kinds of fields the handlers persist
+type suiteDocument struct {
+	Id        string      `bson:"id"`
+	Name      string      `bson:"name"`
+	Count     int64       `bson:"count"`
+	Tags      []string    `bson:"tags,omitempty"`
+	Note      *string     `bson:"note,omitempty"`
+	Nested    suiteNested `bson:"nested"`
+	CreatedAt time.Time   `bson:"created_at"`
+	Version   int64       `bson:"version"`
+}
+
+type suiteNested struct {
+	Value string `bson:"value"`
+}
+
+// newSuiteService creates a service on an empty collection, services created by the same
+// factory within one test share the backend so they can join a transaction
+type newSuiteService func(t *testing.T, collection string) DbService[suiteDocument]
+
+// runDbServiceSuite checks the DbService contract the handlers rely on, every backend runs it
+func runDbServiceSuite(t *testing.T, newService newSuiteService) {
+	t.Run("CRUD", func(t *testing.T) { testCRUD(t, newService(t, "documents")) })
+	t.Run("Versioned", func(t *testing.T) { testVersioned(t, newService(t, "documents")) })
+	t.Run("UpdateFields", func(t *testing.T) { testUpdateFields(t, newService(t, "documents")) })
+	t.Run("Filter", func(t *testing.T) { testFilter(t, newService(t, "documents")) })
+	t.Run("Page", func(t *testing.T) { testPage(t, newService(t, "documents")) })
+	t.Run("ConcurrentCreate", func(t *testing.T) { testConcurrentCreate(t, newService(t, "documents")) })
+	t.Run("ConcurrentUpdate", func(t *testing.T) { testConcurrentUpdate(t, newService(t, "documents")) })
+	t.Run("ConcurrentDelete", func(t *testing.T) { testConcurrentDelete(t, newService(t, "documents")) })
+	t.Run("Transaction", func(t *testing.T) {
+		testTransaction(t, newService(t, "documents"), newService(t, "others"))
+	})
+}
+
+func suiteDate(day int) time.Time {
+	return time.Date(2024, time.January, day, 8, 30, 0, 0, time.UTC)
+}
+
+func testCRUD(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	document := &suiteDocument{Id: "a", Name: "Alpha", Count: 1, CreatedAt: suiteDate(1), Version: 1}
+
+	require.NoError(t, db.CreateDocument(ctx, "a", document))
+	assert.ErrorIs(t, db.CreateDocument(ctx, "a", document), ErrConflict)
+
+	found, err := db.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, document, found)
+
+	found.Name = "Alpha 2"
+	require.NoError(t, db.UpdateDocument(ctx, "a", found))
+	found, err = db.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Alpha 2", found.Name)
+
+	assert.ErrorIs(t, db.UpdateDocument(ctx, "missing", found), ErrNotFound)
+	_, err = db.FindDocument(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, db.DeleteDocument(ctx, "a"))
+	assert.ErrorIs(t, db.DeleteDocument(ctx, "a"), ErrNotFound)
+	_, err = db.FindDocument(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func testVersioned(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	require.NoError(t, db.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Version: 1}))
+
+	assert.ErrorIs(t, db.UpdateDocumentVersioned(ctx, "a", 2, &suiteDocument{Id: "a", Version: 3}), ErrVersionMismatch)
+	assert.ErrorIs(t, db.UpdateDocumentVersioned(ctx, "missing", 1, &suiteDocument{Id: "missing"}), ErrNotFound)
+	require.NoError(t, db.UpdateDocumentVersioned(ctx, "a", 1, &suiteDocument{Id: "a", Version: 2}))
+
+	assert.ErrorIs(t, db.UpdateDocumentFieldsVersioned(ctx, "a", 1, map[string]interface{}{"version": 3}, nil),
+		ErrVersionMismatch)
+	require.NoError(t, db.UpdateDocumentFieldsVersioned(ctx, "a", 2, map[string]interface{}{"version": int64(3)}, nil))
+
+	assert.ErrorIs(t, db.DeleteDocumentVersioned(ctx, "a", 2), ErrVersionMismatch)
+	assert.ErrorIs(t, db.DeleteDocumentVersioned(ctx, "missing", 3), ErrNotFound)
+	require.NoError(t, db.DeleteDocumentVersioned(ctx, "a", 3))
+
+	// documents stored before versioning match version 0
+	require.NoError(t, db.CreateDocument(ctx, "legacy", &suiteDocument{Id: "legacy"}))
+	require.NoError(t, db.UpdateDocumentFields(ctx, "legacy", nil, []string{"version"}))
+	require.NoError(t, db.UpdateDocumentVersioned(ctx, "legacy", 0, &suiteDocument{Id: "legacy", Version: 1}))
+}
+
+func testUpdateFields(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	note := "remove me"
+	require.NoError(t, db.CreateDocument(ctx, "a", &suiteDocument{
+		Id: "a", Name: "Alpha", Count: 1, Note: &note, Nested: suiteNested{Value: "x"}, CreatedAt: suiteDate(1),
+	}))
+
+	require.NoError(t, db.UpdateDocumentFields(ctx, "a",
+		map[string]interface{}{"name": "Beta", "nested.value": "y"},
+		[]string{"note"},
+	))
+	found, err := db.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, &suiteDocument{
+		Id: "a", Name: "Beta", Count: 1, Nested: suiteNested{Value: "y"}, CreatedAt: suiteDate(1),
+	}, found)
+
+	// values of DiffDocuments are written as they are
+	after := *found
+	after.Count = 5
+	set, unset, err := DiffDocuments(found, &after)
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateDocumentFields(ctx, "a", set, unset))
+	found, err = db.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), found.Count)
+
+	assert.ErrorIs(t, db.UpdateDocumentFields(ctx, "missing", map[string]interface{}{"name": "x"}, nil), ErrNotFound)
+}
+
+func ids(documents []*suiteDocument) []string {
+	result := make([]string, 0, len(documents))
+	for _, document := range documents {
+		result = append(result, document.Id)
+	}
+	return result
+}
+
+func testFilter(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	note := "noted"
+	for _, document := range []*suiteDocument{
+		{Id: "a", Name: "Alena", Count: 1, Tags: []string{"red", "blue"}, CreatedAt: suiteDate(1)},
+		{Id: "b", Name: "Boris", Count: 2, Tags: []string{"green"}, Note: &note, CreatedAt: suiteDate(2)},
+		{Id: "c", Name: "alojz", Count: 3, Nested: suiteNested{Value: "deep"}, CreatedAt: suiteDate(3)},
+	} {
+		require.NoError(t, db.CreateDocument(ctx, document.Id, document))
+	}
+
+	cases := []struct {
+		name     string
+		filter   interface{}
+		expected []string
+	}{
+		{"all", nil, []string{"a", "b", "c"}},
+		{"equal", bson.M{"name": "Boris"}, []string{"b"}},
+		{"dotted path", bson.M{"nested.value": "deep"}, []string{"c"}},
+		{"array element", bson.M{"tags": "blue"}, []string{"a"}},
+		{"nil matches missing", bson.M{"note": nil}, []string{"a", "c"}},
+		{"$ne", bson.M{"name": bson.M{"$ne": "Boris"}}, []string{"a", "c"}},
+		{"$in", bson.M{"tags": bson.M{"$in": bson.A{"green", "red"}}}, []string{"a", "b"}},
+		{"$nin", bson.M{"count": bson.M{"$nin": bson.A{1, 3}}}, []string{"b"}},
+		{"$gte $lt", bson.M{"count": bson.M{"$gte": 2, "$lt": 3}}, []string{"b"}},
+		{"date", bson.M{"created_at": bson.M{"$gt": suiteDate(1)}}, []string{"b", "c"}},
+		{"$exists", bson.M{"note": bson.M{"$exists": true}}, []string{"b"}},
+		{"$regex", bson.M{"name": bson.M{"$regex": "^al", "$options": "i"}}, []string{"a", "c"}},
+		{"$or", bson.M{"$or": bson.A{bson.M{"count": 1}, bson.M{"count": 3}}}, []string{"a", "c"}},
+		{"$nor", bson.M{"$nor": bson.A{bson.M{"count": 1}}}, []string{"b", "c"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			documents, err := db.FindDocumentsByFilter(ctx, tc.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ids(documents))
+		})
+	}
+
+	_, err := db.FindDocumentsByFilter(ctx, bson.M{"name": bson.M{"$where": "x"}})
+	assert.Error(t, err)
+}
+
+func testPage(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	for i, name := range []string{"d", "b", "e", "a", "c"} {
+		require.NoError(t, db.CreateDocument(ctx, name, &suiteDocument{Id: name, Name: name, Count: int64(i % 2)}))
+	}
+
+	page, err := db.FindDocumentsPage(ctx, PageRequest{
+		Sort:   []SortField{{Field: "name", Descending: true}},
+		Offset: 1,
+		Limit:  2,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), page.TotalCount)
+	assert.Equal(t, []string{"d", "c"}, ids(page.Documents))
+
+	// ties are ordered by id
+	page, err = db.FindDocumentsPage(ctx, PageRequest{
+		Filter: bson.M{"count": 0},
+		Sort:   []SortField{{Field: "count"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), page.TotalCount)
+	assert.Equal(t, []string{"c", "d", "e"}, ids(page.Documents))
+
+	page, err = db.FindDocumentsPage(ctx, PageRequest{Offset: 10, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), page.TotalCount)
+	assert.Empty(t, page.Documents)
+}
+
+func testTransaction(t *testing.T, db DbService[suiteDocument], others DbService[suiteDocument]) {
+	ctx := context.Background()
+	require.NoError(t, db.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "before"}))
+
+	failure := errors.New("abort")
+	err := db.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := db.UpdateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "after"}); err != nil {
+			return err
+		}
+		if err := others.CreateDocument(ctx, "b", &suiteDocument{Id: "b"}); err != nil {
+			return err
+		}
+		// a nested transaction joins the outer one
+		return others.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := others.DeleteDocument(ctx, "b"); err != nil {
+				return err
+			}
+			return failure
+		})
+	})
+	assert.ErrorIs(t, err, failure)
+
+	found, err := db.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "before", found.Name)
+	_, err = others.FindDocument(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, db.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := db.DeleteDocument(ctx, "a"); err != nil {
+			return err
+		}
+		return others.CreateDocument(ctx, "b", &suiteDocument{Id: "b"})
+	}))
+	_, err = db.FindDocument(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = others.FindDocument(ctx, "b")
+	assert.NoError(t, err)
+}
+
+// number of goroutines racing in the concurrency tests
+const racers = 16
+
+// race runs fn in parallel goroutines released at the same moment and returns their errors
+func race(fn func(i int) error) []error {
+	errs := make([]error, racers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// countErrors counts nil errors as winners, errors matching expected as losers,
+// any other error fails the test
+func countErrors(t *testing.T, errs []error, expected error) (winners int, losers int) {
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			winners++
+		case errors.Is(err, expected):
+			losers++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	return winners, losers
+}
+
+func testConcurrentCreate(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	errs := race(func(i int) error {
+		return db.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: fmt.Sprint(i), Version: 1})
+	})
+
+	winners, losers := countErrors(t, errs, ErrConflict)
+	assert.Equal(t, 1, winners)
+	assert.Equal(t, racers-1, losers)
+
+	// the stored document is the one of the winner
+	found, err := db.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	for i, err := range errs {
+		if err == nil {
+			assert.Equal(t, fmt.Sprint(i), found.Name)
+		}
+	}
+	documents, err := db.FindAllDocuments(ctx)
+	require.NoError(t, err)
+	assert.Len(t, documents, 1)
+}
+
+func testConcurrentUpdate(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	require.NoError(t, db.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Version: 1}))
+
+	// writers of the same version, only one of them may succeed
+	errs := race(func(i int) error {
+		return db.UpdateDocumentVersioned(ctx, "a", 1, &suiteDocument{Id: "a", Count: int64(i), Version: 2})
+	})
+	winners, losers := countErrors(t, errs, ErrVersionMismatch)
+	assert.Equal(t, 1, winners)
+	assert.Equal(t, racers-1, losers)
+
+	// read-modify-write increments retried on conflicts, none of them may be lost
+	require.NoError(t, db.UpdateDocument(ctx, "a", &suiteDocument{Id: "a", Version: 1}))
+	errs = race(func(i int) error {
+		for {
+			document, err := db.FindDocument(ctx, "a")
+			if err != nil {
+				return err
+			}
+			expected := document.Version
+			document.Count++
+			document.Version++
+			if i%2 == 0 {
+				err = db.UpdateDocumentVersioned(ctx, "a", expected, document)
+			} else {
+				err = db.UpdateDocumentFieldsVersioned(ctx, "a", expected,
+					map[string]interface{}{"count": document.Count, "version": document.Version}, nil)
+			}
+			if !errors.Is(err, ErrVersionMismatch) {
+				return err
+			}
+		}
+	})
+	winners, _ = countErrors(t, errs, ErrVersionMismatch)
+	assert.Equal(t, racers, winners)
+
+	found, err := db.FindDocument(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(racers), found.Count)
+	assert.Equal(t, int64(racers+1), found.Version)
+}
+
+func testConcurrentDelete(t *testing.T, db DbService[suiteDocument]) {
+	ctx := context.Background()
+	require.NoError(t, db.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Version: 1}))
+	errs := race(func(i int) error {
+		return db.DeleteDocument(ctx, "a")
+	})
+	winners, losers := countErrors(t, errs, ErrNotFound)
+	assert.Equal(t, 1, winners)
+	assert.Equal(t, racers-1, losers)
+
+	require.NoError(t, db.CreateDocument(ctx, "b", &suiteDocument{Id: "b", Version: 1}))
+	errs = race(func(i int) error {
+		if i%2 == 0 {
+			return db.DeleteDocumentVersioned(ctx, "b", 1)
+		}
+		return db.UpdateDocumentVersioned(ctx, "b", 1, &suiteDocument{Id: "b", Version: 2})
+	})
+	// exactly one write of version 1 wins, the others find the document modified or deleted
+	winners = 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			winners++
+		case errors.Is(err, ErrVersionMismatch), errors.Is(err, ErrNotFound):
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 1, winners)
+}
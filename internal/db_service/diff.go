@@ -0,0 +1,46 @@
+package db_service
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DiffDocuments compares the persisted form of two documents and returns the top-level
+// fields to set and to remove for turning before into after with UpdateDocumentFields
+func DiffDocuments[DocType interface{}](before *DocType, after *DocType) (map[string]interface{}, []string, error) {
+	beforeRaw, err := bson.Marshal(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterRaw, err := bson.Marshal(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	afterElements, err := bson.Raw(afterRaw).Elements()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set := map[string]interface{}{}
+	for _, element := range afterElements {
+		value := element.Value()
+		previous, err := bson.Raw(beforeRaw).LookupErr(element.Key())
+		if err == nil && previous.Equal(value) {
+			continue
+		}
+		set[element.Key()] = value
+	}
+
+	beforeElements, err := bson.Raw(beforeRaw).Elements()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unset := []string{}
+	for _, element := range beforeElements {
+		if _, err := bson.Raw(afterRaw).LookupErr(element.Key()); err != nil {
+			unset = append(unset, element.Key())
+		}
+	}
+	return set, unset, nil
+}
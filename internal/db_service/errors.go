@@ -0,0 +1,45 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	boltErrors "go.etcd.io/bbolt/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// IsUnavailable reports whether the database could not be reached at all, the request
+// did not get to the database and can be retried once it is back
+func IsUnavailable(err error) bool {
+	if errors.As(err, &topology.ServerSelectionError{}) ||
+		errors.Is(err, mongo.ErrClientDisconnected) ||
+		mongo.IsNetworkError(err) {
+		return true
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	// the file is locked by another process
+	if errors.Is(err, boltErrors.ErrTimeout) || errors.Is(err, boltErrors.ErrDatabaseNotOpen) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
+
+// IsTimeout reports whether the database did not answer in time, the operation
+// may or may not have been applied
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) || pgconn.Timeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,41 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	boltErrors "go.etcd.io/bbolt/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		timeout     bool
+		unavailable bool
+	}{
+		{name: "not found", err: ErrNotFound},
+		{name: "version mismatch", err: ErrVersionMismatch},
+		{name: "deadline", err: context.DeadlineExceeded, timeout: true},
+		{name: "wrapped deadline", err: fmt.Errorf("find: %w", context.DeadlineExceeded), timeout: true},
+		{name: "network timeout", err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, timeout: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, unavailable: true},
+		{name: "no mongo server", err: topology.ServerSelectionError{Wrapped: topology.ErrServerSelectionTimeout}, timeout: true, unavailable: true},
+		{name: "mongo client disconnected", err: mongo.ErrClientDisconnected, unavailable: true},
+		{name: "file locked", err: boltErrors.ErrTimeout, unavailable: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.timeout, IsTimeout(test.err), "timeout")
+			assert.Equal(t, test.unavailable, IsUnavailable(test.err), "unavailable")
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package db_service
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type FileServiceConfig struct {
+	// Path of the database file, it is created if it does not exist
+	Path       string
+	Collection string
+	// Timeout of waiting for the file lock held by another process
+	Timeout time.Duration
+}
+
+// fileSvc stores the documents in a single bbolt file, every collection is a bucket of
+// bson documents keyed by the document id. It needs no database server.
+type fileSvc[DocType interface{}] struct {
+	FileServiceConfig
+	db     *bolt.DB
+	dbLock sync.Mutex
+	bucket []byte
+}
+
+// a file can be opened only once per process, services of the same file share the database
+// so a transaction can span several collections
+type sharedFile struct {
+	db   *bolt.DB
+	refs int
+}
+
+var (
+	sharedFiles     = map[string]*sharedFile{}
+	sharedFilesLock sync.Mutex
+)
+
+func acquireFile(path string, timeout time.Duration) (*bolt.DB, error) {
+	sharedFilesLock.Lock()
+	defer sharedFilesLock.Unlock()
+
+	if shared, ok := sharedFiles[path]; ok {
+		shared.refs++
+		return shared.db, nil
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	sharedFiles[path] = &sharedFile{db: db, refs: 1}
+	return db, nil
+}
+
+func releaseFile(path string) error {
+	sharedFilesLock.Lock()
+	defer sharedFilesLock.Unlock()
+
+	shared, ok := sharedFiles[path]
+	if !ok {
+		return nil
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		return nil
+	}
+	delete(sharedFiles, path)
+	return shared.db.Close()
+}
+
+// NewFileService creates a DbService keeping the documents in a local file, meant for
+// deployments without a database server. The data survives restarts of the process.
+func NewFileService[DocType interface{}](config FileServiceConfig) DbService[DocType] {
+	enviro := func(name string, defaultValue string) string {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return defaultValue
+	}
+
+	svc := &fileSvc[DocType]{}
+	svc.FileServiceConfig = config
+
+	if svc.Path == "" {
+		svc.Path = enviro("AMBULANCE_API_FILE_PATH", "ambulance.db")
+	}
+	if path, err := filepath.Abs(svc.Path); err == nil {
+		svc.Path = path
+	}
+
+	if svc.Collection == "" {
+		svc.Collection = enviro("AMBULANCE_API_FILE_COLLECTION", "ambulance")
+	}
+	svc.bucket = []byte(svc.Collection)
+
+	if svc.Timeout == 0 {
+		seconds := enviro("AMBULANCE_API_FILE_TIMEOUT_SECONDS", "10")
+		if seconds, err := strconv.Atoi(seconds); err == nil {
+			svc.Timeout = time.Duration(seconds) * time.Second
+		} else {
+			log.Printf("Invalid timeout value: %v", seconds)
+			svc.Timeout = 10 * time.Second
+		}
+	}
+
+	log.Printf("File storage config: %v/%v", svc.Path, svc.Collection)
+	return svc
+}
+
+func (m *fileSvc[DocType]) connect() (*bolt.DB, error) {
+	m.dbLock.Lock()
+	defer m.dbLock.Unlock()
+	if m.db != nil {
+		return m.db, nil
+	}
+
+	db, err := acquireFile(m.Path, m.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	m.db = db
+	return db, nil
+}
+
+func (m *fileSvc[DocType]) Disconnect(ctx context.Context) error {
+	m.dbLock.Lock()
+	defer m.dbLock.Unlock()
+
+	if m.db == nil {
+		return nil
+	}
+	m.db = nil
+	return releaseFile(m.Path)
+}
+
+type fileTransaction struct {
+	db *bolt.DB
+	tx *bolt.Tx
+}
+
+type fileTransactionKey struct{}
+
+// view runs fn in the transaction carried by the context if it belongs to the file of this
+// service, in a read-only transaction otherwise
+func (m *fileSvc[DocType]) view(ctx context.Context, fn func(bucket *bolt.Bucket) error) error {
+	return m.run(ctx, false, fn)
+}
+
+// update runs fn in the transaction carried by the context if it belongs to the file of this
+// service, in its own read-write transaction otherwise. Writes of the file are serialized,
+// services must be called with the context passed to the transaction function, otherwise
+// they wait for the transaction to end.
+func (m *fileSvc[DocType]) update(ctx context.Context, fn func(bucket *bolt.Bucket) error) error {
+	return m.run(ctx, true, fn)
+}
+
+func (m *fileSvc[DocType]) run(ctx context.Context, write bool, fn func(bucket *bolt.Bucket) error) error {
+	db, err := m.connect()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// the bucket of a collection is created by its first write, so it can be created inside
+	// a transaction already holding the write lock of the file, a missing bucket reads as empty
+	inBucket := func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(m.bucket)
+		if bucket == nil {
+			if !tx.Writable() {
+				return nil
+			}
+			created, err := tx.CreateBucket(m.bucket)
+			if err != nil {
+				return err
+			}
+			bucket = created
+		}
+		return fn(bucket)
+	}
+	if transaction, ok := ctx.Value(fileTransactionKey{}).(*fileTransaction); ok && transaction.db == db {
+		return inBucket(transaction.tx)
+	}
+	if write {
+		return db.Update(inBucket)
+	}
+	return db.View(inBucket)
+}
+
+// fileVersion returns ErrNotFound for a missing document and ErrVersionMismatch if the
+// stored version is not the expected one, expectedVersion nil skips the version check
+func fileVersion(stored []byte, expectedVersion *int64) error {
+	if stored == nil {
+		return ErrNotFound
+	}
+	if expectedVersion != nil && storedVersion(stored) != *expectedVersion {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+func (m *fileSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return m.update(ctx, func(bucket *bolt.Bucket) error {
+		if bucket.Get([]byte(id)) != nil {
+			return ErrConflict
+		}
+		return bucket.Put([]byte(id), raw)
+	})
+}
+
+func (m *fileSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	var stored bson.Raw
+	err := m.view(ctx, func(bucket *bolt.Bucket) error {
+		stored = copyRaw(bucket.Get([]byte(id)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrNotFound
+	}
+	return decodeDocument[DocType](stored)
+}
+
+func (m *fileSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	return m.replace(ctx, id, nil, document)
+}
+
+func (m *fileSvc[DocType]) UpdateDocumentVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	document *DocType,
+) error {
+	return m.replace(ctx, id, &expectedVersion, document)
+}
+
+func (m *fileSvc[DocType]) replace(ctx context.Context, id string, expectedVersion *int64, document *DocType) error {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return m.update(ctx, func(bucket *bolt.Bucket) error {
+		if err := fileVersion(bucket.Get([]byte(id)), expectedVersion); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), raw)
+	})
+}
+
+func (m *fileSvc[DocType]) UpdateDocumentFields(
+	ctx context.Context,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.updateFields(ctx, id, nil, set, unset)
+}
+
+func (m *fileSvc[DocType]) UpdateDocumentFieldsVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.updateFields(ctx, id, &expectedVersion, set, unset)
+}
+
+func (m *fileSvc[DocType]) updateFields(
+	ctx context.Context,
+	id string,
+	expectedVersion *int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.update(ctx, func(bucket *bolt.Bucket) error {
+		stored := bucket.Get([]byte(id))
+		if err := fileVersion(stored, expectedVersion); err != nil {
+			return err
+		}
+
+		fields := bson.M{}
+		if err := bson.Unmarshal(stored, &fields); err != nil {
+			return err
+		}
+		for path, value := range set {
+			setPath(fields, strings.Split(path, "."), value)
+		}
+		for _, path := range unset {
+			unsetPath(fields, strings.Split(path, "."))
+		}
+		raw, err := bson.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), raw)
+	})
+}
+
+func (m *fileSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return m.delete(ctx, id, nil)
+}
+
+func (m *fileSvc[DocType]) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	return m.delete(ctx, id, &expectedVersion)
+}
+
+func (m *fileSvc[DocType]) delete(ctx context.Context, id string, expectedVersion *int64) error {
+	return m.update(ctx, func(bucket *bolt.Bucket) error {
+		if err := fileVersion(bucket.Get([]byte(id)), expectedVersion); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func (m *fileSvc[DocType]) FindAllDocuments(ctx context.Context) ([]*DocType, error) {
+	return m.FindDocumentsByFilter(ctx, nil)
+}
+
+func (m *fileSvc[DocType]) FindDocumentsByFilter(ctx context.Context, filter interface{}) ([]*DocType, error) {
+	matches, err := m.match(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMatches[DocType](matches)
+}
+
+// match returns the documents matching the filter ordered by id, the natural order of the file
+func (m *fileSvc[DocType]) match(ctx context.Context, filter interface{}) ([]matchedDocument, error) {
+	normalized, err := normalizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []matchedDocument
+	err = m.view(ctx, func(bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(_ []byte, stored []byte) error {
+			match, matched, err := matchDocument(copyRaw(stored), normalized)
+			if err != nil {
+				return err
+			}
+			if matched {
+				matches = append(matches, match)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// copyRaw copies the stored document, bytes read from the file are valid only during the transaction
+func copyRaw(stored []byte) bson.Raw {
+	if stored == nil {
+		return nil
+	}
+	return append(bson.Raw{}, stored...)
+}
+
+func (m *fileSvc[DocType]) FindDocumentsPage(ctx context.Context, request PageRequest) (*Page[DocType], error) {
+	matches, err := m.match(ctx, request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return pageOfMatches[DocType](matches, request)
+}
+
+// WithTransaction runs fn in a read-write transaction of the file, transactions are serialized.
+// Changes are rolled back if fn returns an error or panics.
+func (m *fileSvc[DocType]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	db, err := m.connect()
+	if err != nil {
+		return err
+	}
+	if transaction, ok := ctx.Value(fileTransactionKey{}).(*fileTransaction); ok && transaction.db == db {
+		// join the transaction of the caller
+		return fn(ctx)
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return fn(context.WithValue(ctx, fileTransactionKey{}, &fileTransaction{db: db, tx: tx}))
+	})
+}
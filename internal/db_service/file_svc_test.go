@@ -0,0 +1,82 @@
+package db_service
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileService(t *testing.T) {
+	// services created by one test share its file
+	var files sync.Map
+	runDbServiceSuite(t, func(t *testing.T, collection string) DbService[suiteDocument] {
+		path, _ := files.LoadOrStore(t.Name(), filepath.Join(t.TempDir(), "ambulance.db"))
+		db := NewFileService[suiteDocument](FileServiceConfig{Path: path.(string), Collection: collection})
+		t.Cleanup(func() {
+			require.NoError(t, db.Disconnect(context.Background()))
+		})
+		return db
+	})
+}
+
+func TestFileServiceSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data", "ambulance.db")
+
+	db := NewFileService[suiteDocument](FileServiceConfig{Path: path, Collection: "documents"})
+	others := NewFileService[suiteDocument](FileServiceConfig{Path: path, Collection: "others"})
+	require.NoError(t, db.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Name: "Alpha", Tags: []string{"x"}, Version: 1}))
+	require.NoError(t, db.CreateDocument(ctx, "b", &suiteDocument{Id: "b", Name: "Beta", Version: 1}))
+	require.NoError(t, db.DeleteDocument(ctx, "b"))
+	require.NoError(t, others.CreateDocument(ctx, "c", &suiteDocument{Id: "c", Name: "Gamma", Version: 1}))
+	// the file stays open until the last service of it disconnects
+	require.NoError(t, db.Disconnect(ctx))
+	_, err := others.FindDocument(ctx, "c")
+	require.NoError(t, err)
+	require.NoError(t, others.Disconnect(ctx))
+
+	reopened := NewFileService[suiteDocument](FileServiceConfig{Path: path, Collection: "documents"})
+	defer reopened.Disconnect(ctx)
+	documents, err := reopened.FindAllDocuments(ctx)
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	assert.Equal(t, "Alpha", documents[0].Name)
+	assert.Equal(t, []string{"x"}, documents[0].Tags)
+
+	// a collection without writes reads as empty
+	empty := NewFileService[suiteDocument](FileServiceConfig{Path: path, Collection: "empty"})
+	defer empty.Disconnect(ctx)
+	documents, err = empty.FindAllDocuments(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, documents)
+	_, err = empty.FindDocument(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// the first write of a collection inside a transaction creates its bucket in that transaction
+func TestFileServiceCollectionCreatedInTransaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ambulance.db")
+	db := NewFileService[suiteDocument](FileServiceConfig{Path: path, Collection: "documents"})
+	defer db.Disconnect(ctx)
+	others := NewFileService[suiteDocument](FileServiceConfig{Path: path, Collection: "others"})
+	defer others.Disconnect(ctx)
+
+	err := db.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := others.FindDocument(ctx, "a"); err != ErrNotFound {
+			return err
+		}
+		if err := db.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Version: 1}); err != nil {
+			return err
+		}
+		return others.CreateDocument(ctx, "a", &suiteDocument{Id: "a", Version: 1})
+	})
+	require.NoError(t, err)
+
+	_, err = others.FindDocument(ctx, "a")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,440 @@
+package db_service
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Backends without a native MongoDB query engine evaluate filters with matchesFilter.
+// It supports the subset of MongoDB query semantics used by the handlers:
+//   - field equality, dotted paths into nested documents, array fields match any element
+//   - nil matches a null or missing field
+//   - $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $regex with $options
+//   - $and, $or and $nor of nested filters
+
+// normalizeFilter converts the filter to the same representation as decoded documents,
+// so values are compared regardless of the go types used to build the filter
+func normalizeFilter(filter interface{}) (bson.M, error) {
+	if filter == nil {
+		return bson.M{}, nil
+	}
+	raw, err := bson.Marshal(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	normalized := bson.M{}
+	if err := bson.Unmarshal(raw, &normalized); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return normalized, nil
+}
+
+// matchesFilter reports whether the decoded document matches the normalized filter
+func matchesFilter(document bson.M, filter bson.M) (bool, error) {
+	for key, condition := range filter {
+		var matched bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			matched, err = matchLogical(document, key, condition)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported filter operator %s", key)
+			}
+			matched, err = matchCondition(lookupPath(document, strings.Split(key, ".")), condition)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(document bson.M, operator string, operand interface{}) (bool, error) {
+	filters, ok := operand.(bson.A)
+	if !ok || len(filters) == 0 {
+		return false, fmt.Errorf("%s requires a non-empty array of filters", operator)
+	}
+	matchedAny := false
+	for _, item := range filters {
+		filter, ok := item.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("%s requires an array of filters", operator)
+		}
+		matched, err := matchesFilter(document, filter)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case operator == "$and" && !matched:
+			return false, nil
+		case operator == "$or" && matched:
+			return true, nil
+		}
+		matchedAny = matchedAny || matched
+	}
+	switch operator {
+	case "$and":
+		return true, nil
+	case "$or":
+		return false, nil
+	default:
+		return !matchedAny, nil
+	}
+}
+
+// lookupPath returns all values at the dotted path, arrays on the path are searched element-wise,
+// a missing field yields no values
+func lookupPath(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+	switch nested := value.(type) {
+	case bson.M:
+		child, ok := nested[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookupPath(child, path[1:])
+	case bson.A:
+		if index, err := strconv.Atoi(path[0]); err == nil {
+			if index < 0 || index >= len(nested) {
+				return nil
+			}
+			return lookupPath(nested[index], path[1:])
+		}
+		var values []interface{}
+		for _, item := range nested {
+			values = append(values, lookupPath(item, path)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// isOperatorDocument reports whether the condition is a document of query operators
+// like {"$gte": 1} rather than a document to compare with
+func isOperatorDocument(condition interface{}) (bson.M, bool) {
+	operators, ok := condition.(bson.M)
+	if !ok || len(operators) == 0 {
+		return nil, false
+	}
+	for key := range operators {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+	return operators, true
+}
+
+func matchCondition(values []interface{}, condition interface{}) (bool, error) {
+	operators, ok := isOperatorDocument(condition)
+	if !ok {
+		return matchEqual(values, condition), nil
+	}
+
+	for operator, operand := range operators {
+		var matched bool
+		switch operator {
+		case "$eq":
+			matched = matchEqual(values, operand)
+		case "$ne":
+			matched = !matchEqual(values, operand)
+		case "$in", "$nin":
+			candidates, ok := operand.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("%s requires an array", operator)
+			}
+			for _, candidate := range candidates {
+				if matchEqual(values, candidate) {
+					matched = true
+					break
+				}
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			matched = matchComparison(values, operator, operand)
+		case "$exists":
+			exists, ok := operand.(bool)
+			if !ok {
+				return false, fmt.Errorf("$exists requires a boolean")
+			}
+			matched = (len(values) > 0) == exists
+		case "$regex":
+			pattern, err := compileRegex(operand, operators["$options"])
+			if err != nil {
+				return false, err
+			}
+			matched = matchRegex(values, pattern)
+		case "$options":
+			continue // applied together with $regex
+		default:
+			return false, fmt.Errorf("unsupported filter operator %s", operator)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// flatten expands array values into their elements, the array itself is kept as well
+func flatten(values []interface{}) []interface{} {
+	flat := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		flat = append(flat, value)
+		if array, ok := value.(bson.A); ok {
+			flat = append(flat, array...)
+		}
+	}
+	return flat
+}
+
+func matchEqual(values []interface{}, expected interface{}) bool {
+	if expected == nil && len(values) == 0 {
+		return true
+	}
+	for _, value := range flatten(values) {
+		if valuesEqual(value, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchComparison(values []interface{}, operator string, operand interface{}) bool {
+	for _, value := range flatten(values) {
+		order, comparable := compareValues(value, operand)
+		if !comparable {
+			continue
+		}
+		switch {
+		case operator == "$gt" && order > 0,
+			operator == "$gte" && order >= 0,
+			operator == "$lt" && order < 0,
+			operator == "$lte" && order <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func compileRegex(operand interface{}, options interface{}) (*regexp.Regexp, error) {
+	var pattern, flags string
+	switch regex := operand.(type) {
+	case string:
+		pattern = regex
+	case primitive.Regex:
+		pattern, flags = regex.Pattern, regex.Options
+	default:
+		return nil, fmt.Errorf("$regex requires a string pattern")
+	}
+	if value, ok := options.(string); ok {
+		flags += value
+	}
+	prefix := ""
+	for _, flag := range flags {
+		switch flag {
+		case 'i', 'm', 's':
+			prefix += string(flag)
+		}
+	}
+	if prefix != "" {
+		pattern = "(?" + prefix + ")" + pattern
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid $regex: %w", err)
+	}
+	return compiled, nil
+}
+
+func matchRegex(values []interface{}, pattern *regexp.Regexp) bool {
+	for _, value := range flatten(values) {
+		if text, ok := value.(string); ok && pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int32:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
+
+func valuesEqual(a interface{}, b interface{}) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders values of the same kind, values of different kinds are not comparable
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case primitive.DateTime:
+		y, ok := b.(primitive.DateTime)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(boolRank(x), boolRank(y)), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T int | int64 | float64 | primitive.DateTime](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolRank(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// typeRank orders values of different kinds in sort, following MongoDB
+// (missing and null first, then numbers, strings, documents, arrays, booleans and dates)
+func typeRank(value interface{}) int {
+	if _, ok := toNumber(value); ok {
+		return 1
+	}
+	switch value.(type) {
+	case nil:
+		return 0
+	case string:
+		return 2
+	case bson.M:
+		return 3
+	case bson.A:
+		return 4
+	case bool:
+		return 5
+	case primitive.DateTime:
+		return 6
+	}
+	return 7
+}
+
+// compareForSort orders two documents by the field at the dotted path
+func compareForSort(a bson.M, b bson.M, field string) int {
+	path := strings.Split(field, ".")
+	x, y := sortValue(lookupPath(a, path)), sortValue(lookupPath(b, path))
+	if rankX, rankY := typeRank(x), typeRank(y); rankX != rankY {
+		return compareOrdered(rankX, rankY)
+	}
+	order, _ := compareValues(x, y)
+	return order
+}
+
+func sortValue(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// matchedDocument is a stored document matching a filter together with its decoded fields
+type matchedDocument struct {
+	raw    bson.Raw
+	fields bson.M
+}
+
+// matchDocument decodes the stored document and reports whether it matches the normalized filter
+func matchDocument(raw bson.Raw, filter bson.M) (matchedDocument, bool, error) {
+	fields := bson.M{}
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return matchedDocument{}, false, err
+	}
+	matched, err := matchesFilter(fields, filter)
+	if err != nil {
+		return matchedDocument{}, false, err
+	}
+	return matchedDocument{raw: raw, fields: fields}, matched, nil
+}
+
+func decodeMatches[DocType interface{}](matches []matchedDocument) ([]*DocType, error) {
+	documents := make([]*DocType, 0, len(matches))
+	for _, match := range matches {
+		document, err := decodeDocument[DocType](match.raw)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// pageOfMatches sorts the matching documents and cuts out the requested page
+func pageOfMatches[DocType interface{}](matches []matchedDocument, request PageRequest) (*Page[DocType], error) {
+	// id as the last sort key keeps the order stable between pages
+	sortFields := append(append([]SortField{}, request.Sort...), SortField{Field: "id"})
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, field := range sortFields {
+			order := compareForSort(matches[i].fields, matches[j].fields, field.Field)
+			if field.Descending {
+				order = -order
+			}
+			if order != 0 {
+				return order < 0
+			}
+		}
+		return false
+	})
+
+	total := int64(len(matches))
+	start := min(max(request.Offset, 0), total)
+	end := total
+	if request.Limit > 0 {
+		end = min(start+request.Limit, total)
+	}
+
+	documents, err := decodeMatches[DocType](matches[start:end])
+	if err != nil {
+		return nil, err
+	}
+	return &Page[DocType]{Documents: documents, TotalCount: total}, nil
+}
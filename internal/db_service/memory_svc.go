@@ -0,0 +1,402 @@
+package db_service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type MemoryServiceConfig struct {
+	DbName     string
+	Collection string
+}
+
+// memoryDatabase holds the collections of one database, all services created with the same
+// DbName share it so a transaction can span several collections
+type memoryDatabase struct {
+	lock        sync.RWMutex
+	collections map[string]*memoryCollection
+}
+
+type memoryCollection struct {
+	documents map[string]memoryDocument
+	sequence  int64
+}
+
+// memoryDocument is the persisted form of a document, documents are never shared with callers
+type memoryDocument struct {
+	raw bson.Raw
+	// insertion order of the document, the natural order of the collection
+	sequence int64
+}
+
+var (
+	memoryDatabases     = map[string]*memoryDatabase{}
+	memoryDatabasesLock sync.Mutex
+)
+
+// memoryTransaction holds the exclusive lock of the database until it ends,
+// undo restores the changes made by the transaction if it fails
+type memoryTransaction struct {
+	database *memoryDatabase
+	undo     []func()
+}
+
+type memoryTransactionKey struct{}
+
+func (t *memoryTransaction) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+}
+
+type memorySvc[DocType interface{}] struct {
+	MemoryServiceConfig
+	database *memoryDatabase
+}
+
+// NewMemoryService creates a DbService keeping the documents in process memory, it is meant
+// for tests and local development. The data is lost when the process exits.
+func NewMemoryService[DocType interface{}](config MemoryServiceConfig) DbService[DocType] {
+	svc := &memorySvc[DocType]{MemoryServiceConfig: config}
+	if svc.DbName == "" {
+		svc.DbName = "memory"
+	}
+	if svc.Collection == "" {
+		svc.Collection = "ambulance"
+	}
+
+	memoryDatabasesLock.Lock()
+	defer memoryDatabasesLock.Unlock()
+	database, ok := memoryDatabases[svc.DbName]
+	if !ok {
+		database = &memoryDatabase{collections: map[string]*memoryCollection{}}
+		memoryDatabases[svc.DbName] = database
+	}
+	svc.database = database
+
+	database.lock.Lock()
+	defer database.lock.Unlock()
+	if _, ok := database.collections[svc.Collection]; !ok {
+		database.collections[svc.Collection] = &memoryCollection{documents: map[string]memoryDocument{}}
+	}
+	return svc
+}
+
+// lock takes the database lock unless the context carries a transaction of this database,
+// which holds the exclusive lock already. Services must be called with the context passed
+// to the transaction function, otherwise they wait for the transaction to end.
+func (m *memorySvc[DocType]) lock(ctx context.Context, write bool) (*memoryTransaction, func()) {
+	if transaction, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction); ok &&
+		transaction.database == m.database {
+		return transaction, func() {}
+	}
+	if write {
+		m.database.lock.Lock()
+		return nil, m.database.lock.Unlock
+	}
+	m.database.lock.RLock()
+	return nil, m.database.lock.RUnlock
+}
+
+func (m *memorySvc[DocType]) collection() *memoryCollection {
+	return m.database.collections[m.Collection]
+}
+
+// put stores the document, the previous state is restored if the transaction fails
+func (c *memoryCollection) put(transaction *memoryTransaction, id string, document memoryDocument) {
+	previous, existed := c.documents[id]
+	c.documents[id] = document
+	if transaction != nil {
+		transaction.undo = append(transaction.undo, func() {
+			if existed {
+				c.documents[id] = previous
+			} else {
+				delete(c.documents, id)
+			}
+		})
+	}
+}
+
+// remove deletes the document, it is restored if the transaction fails
+func (c *memoryCollection) remove(transaction *memoryTransaction, id string) {
+	previous := c.documents[id]
+	delete(c.documents, id)
+	if transaction != nil {
+		transaction.undo = append(transaction.undo, func() {
+			c.documents[id] = previous
+		})
+	}
+}
+
+// sorted returns the documents in their natural order
+func (c *memoryCollection) sorted() []memoryDocument {
+	documents := make([]memoryDocument, 0, len(c.documents))
+	for _, document := range c.documents {
+		documents = append(documents, document)
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].sequence < documents[j].sequence
+	})
+	return documents
+}
+
+func decodeDocument[DocType interface{}](raw bson.Raw) (*DocType, error) {
+	document := new(DocType)
+	if err := bson.Unmarshal(raw, document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// storedVersion returns the version of the persisted document, 0 if it has none
+func storedVersion(raw bson.Raw) int64 {
+	value, err := raw.LookupErr("version")
+	if err != nil {
+		return 0
+	}
+	if version, ok := value.AsInt64OK(); ok {
+		return version
+	}
+	return 0
+}
+
+func (m *memorySvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	transaction, unlock := m.lock(ctx, true)
+	defer unlock()
+	collection := m.collection()
+	if _, exists := collection.documents[id]; exists {
+		return ErrConflict
+	}
+	collection.sequence++
+	collection.put(transaction, id, memoryDocument{raw: raw, sequence: collection.sequence})
+	return nil
+}
+
+func (m *memorySvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	_, unlock := m.lock(ctx, false)
+	defer unlock()
+	stored, ok := m.collection().documents[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return decodeDocument[DocType](stored.raw)
+}
+
+func (m *memorySvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	return m.replace(ctx, id, nil, document)
+}
+
+func (m *memorySvc[DocType]) UpdateDocumentVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	document *DocType,
+) error {
+	return m.replace(ctx, id, &expectedVersion, document)
+}
+
+func (m *memorySvc[DocType]) replace(ctx context.Context, id string, expectedVersion *int64, document *DocType) error {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	transaction, unlock := m.lock(ctx, true)
+	defer unlock()
+	collection := m.collection()
+	stored, ok := collection.documents[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if expectedVersion != nil && storedVersion(stored.raw) != *expectedVersion {
+		return ErrVersionMismatch
+	}
+	collection.put(transaction, id, memoryDocument{raw: raw, sequence: stored.sequence})
+	return nil
+}
+
+func (m *memorySvc[DocType]) UpdateDocumentFields(
+	ctx context.Context,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.updateFields(ctx, id, nil, set, unset)
+}
+
+func (m *memorySvc[DocType]) UpdateDocumentFieldsVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.updateFields(ctx, id, &expectedVersion, set, unset)
+}
+
+func (m *memorySvc[DocType]) updateFields(
+	ctx context.Context,
+	id string,
+	expectedVersion *int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	transaction, unlock := m.lock(ctx, true)
+	defer unlock()
+	collection := m.collection()
+	stored, ok := collection.documents[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if expectedVersion != nil && storedVersion(stored.raw) != *expectedVersion {
+		return ErrVersionMismatch
+	}
+
+	fields := bson.M{}
+	if err := bson.Unmarshal(stored.raw, &fields); err != nil {
+		return err
+	}
+	for path, value := range set {
+		setPath(fields, strings.Split(path, "."), value)
+	}
+	for _, path := range unset {
+		unsetPath(fields, strings.Split(path, "."))
+	}
+	raw, err := bson.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	collection.put(transaction, id, memoryDocument{raw: raw, sequence: stored.sequence})
+	return nil
+}
+
+// setPath sets the value at the dotted path, missing nested documents are created
+func setPath(document bson.M, path []string, value interface{}) {
+	if len(path) == 1 {
+		document[path[0]] = value
+		return
+	}
+	nested, ok := document[path[0]].(bson.M)
+	if !ok {
+		nested = bson.M{}
+		document[path[0]] = nested
+	}
+	setPath(nested, path[1:], value)
+}
+
+func unsetPath(document bson.M, path []string) {
+	if len(path) == 1 {
+		delete(document, path[0])
+		return
+	}
+	if nested, ok := document[path[0]].(bson.M); ok {
+		unsetPath(nested, path[1:])
+	}
+}
+
+func (m *memorySvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return m.delete(ctx, id, nil)
+}
+
+func (m *memorySvc[DocType]) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	return m.delete(ctx, id, &expectedVersion)
+}
+
+func (m *memorySvc[DocType]) delete(ctx context.Context, id string, expectedVersion *int64) error {
+	transaction, unlock := m.lock(ctx, true)
+	defer unlock()
+	collection := m.collection()
+	stored, ok := collection.documents[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if expectedVersion != nil && storedVersion(stored.raw) != *expectedVersion {
+		return ErrVersionMismatch
+	}
+	collection.remove(transaction, id)
+	return nil
+}
+
+func (m *memorySvc[DocType]) FindAllDocuments(ctx context.Context) ([]*DocType, error) {
+	return m.FindDocumentsByFilter(ctx, nil)
+}
+
+func (m *memorySvc[DocType]) FindDocumentsByFilter(ctx context.Context, filter interface{}) ([]*DocType, error) {
+	matches, err := m.match(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMatches[DocType](matches)
+}
+
+// match returns the documents matching the filter in their natural order
+func (m *memorySvc[DocType]) match(ctx context.Context, filter interface{}) ([]matchedDocument, error) {
+	normalized, err := normalizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	_, unlock := m.lock(ctx, false)
+	defer unlock()
+	var matches []matchedDocument
+	for _, stored := range m.collection().sorted() {
+		match, matched, err := matchDocument(stored.raw, normalized)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
+
+func (m *memorySvc[DocType]) FindDocumentsPage(ctx context.Context, request PageRequest) (*Page[DocType], error) {
+	matches, err := m.match(ctx, request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return pageOfMatches[DocType](matches, request)
+}
+
+// WithTransaction runs fn holding the exclusive lock of the database, transactions are
+// serialized. Changes are undone if fn returns an error or panics.
+func (m *memorySvc[DocType]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if transaction, ok := ctx.Value(memoryTransactionKey{}).(*memoryTransaction); ok &&
+		transaction.database == m.database {
+		// join the transaction of the caller
+		return fn(ctx)
+	}
+
+	m.database.lock.Lock()
+	defer m.database.lock.Unlock()
+
+	transaction := &memoryTransaction{database: m.database}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			transaction.rollback()
+			panic(recovered)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, memoryTransactionKey{}, transaction)); err != nil {
+		transaction.rollback()
+		return err
+	}
+	return nil
+}
+
+func (m *memorySvc[DocType]) Disconnect(ctx context.Context) error {
+	// documents stay available to other services of the database until the process exits
+	return nil
+}
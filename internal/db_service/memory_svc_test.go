@@ -0,0 +1,17 @@
+package db_service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryService(t *testing.T) {
+	// databases live until the process exits, every run of the test uses new ones
+	run := time.Now().UnixNano()
+	runDbServiceSuite(t, func(t *testing.T, collection string) DbService[suiteDocument] {
+		// services created by one test share its database
+		dbName := fmt.Sprintf("%s-%d", t.Name(), run)
+		return NewMemoryService[suiteDocument](MemoryServiceConfig{DbName: dbName, Collection: collection})
+	})
+}
@@ -25,14 +25,31 @@ type DbService[DocType interface{}] interface {
 	CreateDocument(ctx context.Context, id string, document *DocType) error
 	FindDocument(ctx context.Context, id string) (*DocType, error)
 	UpdateDocument(ctx context.Context, id string, document *DocType) error
+	// UpdateDocumentFields sets and removes individual persisted fields of the document,
+	// fields not mentioned are left untouched
+	UpdateDocumentFields(ctx context.Context, id string, set map[string]interface{}, unset []string) error
 	DeleteDocument(ctx context.Context, id string) error
+	// UpdateDocumentVersioned, UpdateDocumentFieldsVersioned and DeleteDocumentVersioned write
+	// the document only if its persisted `version` still equals expectedVersion, otherwise
+	// they return ErrVersionMismatch. The check and the write are a single atomic operation.
+	UpdateDocumentVersioned(ctx context.Context, id string, expectedVersion int64, document *DocType) error
+	UpdateDocumentFieldsVersioned(
+		ctx context.Context, id string, expectedVersion int64, set map[string]interface{}, unset []string) error
+	DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error
 	FindAllDocuments(ctx context.Context) ([]*DocType, error)
 	FindDocumentsByFilter(ctx context.Context, filter interface{}) ([]*DocType, error)
+	// FindDocumentsPage returns one page of documents matching the request filter
+	// together with the total count of matching documents
+	FindDocumentsPage(ctx context.Context, request PageRequest) (*Page[DocType], error)
+	// WithTransaction runs fn in a single transaction. All DbService calls made with the
+	// context passed to fn - on any service of the same backend - commit or abort together.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 	Disconnect(ctx context.Context) error
 }
 
 var ErrNotFound = fmt.Errorf("document not found")
 var ErrConflict = fmt.Errorf("conflict: document already exists")
+var ErrVersionMismatch = fmt.Errorf("conflict: document was modified concurrently")
 
 type MongoServiceConfig struct {
 	ServerHost string
@@ -48,9 +65,55 @@ type mongoSvc[DocType interface{}] struct {
 	MongoServiceConfig
 	client     atomic.Pointer[mongo.Client]
 	clientLock sync.Mutex
+	clientUri  string
 	tracer     trace.Tracer
 }
 
+// mongo clients are shared by all services connecting to the same server,
+// a session (and so a transaction) can then span several collections
+type sharedClient struct {
+	client *mongo.Client
+	refs   int
+}
+
+var (
+	sharedClients     = map[string]*sharedClient{}
+	sharedClientsLock sync.Mutex
+)
+
+func acquireClient(ctx context.Context, uri string, opts *options.ClientOptions) (*mongo.Client, error) {
+	sharedClientsLock.Lock()
+	defer sharedClientsLock.Unlock()
+
+	if shared, ok := sharedClients[uri]; ok {
+		shared.refs++
+		return shared.client, nil
+	}
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	sharedClients[uri] = &sharedClient{client: client, refs: 1}
+	return client, nil
+}
+
+func releaseClient(ctx context.Context, uri string) error {
+	sharedClientsLock.Lock()
+	defer sharedClientsLock.Unlock()
+
+	shared, ok := sharedClients[uri]
+	if !ok {
+		return nil
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		return nil
+	}
+	delete(sharedClients, uri)
+	return shared.client.Disconnect(ctx)
+}
+
 func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[DocType] {
 	enviro := func(name string, defaultValue string) string {
 		if value, ok := os.LookupEnv(name); ok {
@@ -144,16 +207,38 @@ func (m *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, error)
 	opts := options.Client()
 	opts.Monitor = otelmongo.NewMonitor()
 	opts.ApplyURI(uri).SetConnectTimeout(10 * time.Second)
-	if client, err := mongo.Connect(ctx, opts); err != nil {
+	if client, err := acquireClient(ctx, uri, opts); err != nil {
     	span.SetStatus(codes.Error, "MongoDB connection error")
 		return nil, err
+	} else if err := m.ensureIndexes(client); err != nil {
+		span.SetStatus(codes.Error, "MongoDB index creation error")
+		releaseClient(ctx, uri)
+		return nil, err
 	} else {
+		m.clientUri = uri
 		m.client.Store(client)
 		span.SetStatus(codes.Ok, "MongoDB connection established")
 		return client, nil
 	}
 }
 
+// ensureIndexes creates the unique index on `id`, writes rely on it to refuse duplicates
+// atomically. It runs outside of any transaction of the caller, index builds cannot be part of one.
+func (m *mongoSvc[DocType]) ensureIndexes(client *mongo.Client) error {
+	ctx, contextCancel := context.WithTimeout(context.Background(), m.Timeout)
+	defer contextCancel()
+
+	collection := client.Database(m.DbName).Collection(m.Collection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create unique index on %v.id, remove duplicate ids first: %w", m.Collection, err)
+	}
+	return nil
+}
+
 func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
 	client := m.client.Load()
 
@@ -164,7 +249,7 @@ func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
 		client = m.client.Load()
 		defer m.client.Store(nil)
 		if client != nil {
-			if err := client.Disconnect(ctx); err != nil {
+			if err := releaseClient(ctx, m.clientUri); err != nil {
 				return err
 			}
 		}
@@ -191,26 +276,26 @@ func (m *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, docum
 	}
 	db := client.Database(m.DbName)
 	collection := db.Collection(m.Collection)
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
-	switch result.Err() {
-	case nil: // no error means there is conflicting document
-		return ErrConflict
-	case mongo.ErrNoDocuments:
-		// do nothing, this is expected
-	default: // other errors - return them
-		span.SetStatus(codes.Error, result.Err().Error())
-		return result.Err()
-	}
 
+	// the unique index on id refuses a conflicting document
 	_, err = collection.InsertOne(ctx, document)
-	span.SetStatus(codes.Ok, "Document inserted")
-	return err
+	switch {
+	case err == nil:
+		span.SetStatus(codes.Ok, "Document inserted")
+		return nil
+	case mongo.IsDuplicateKeyError(err):
+		span.SetStatus(codes.Error, "Document already exists")
+		return ErrConflict
+	default:
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
 }
 
 func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
 	ctx, span := m.tracer.Start(
 		ctx,
-		"CreateDocument",
+		"FindDocument",
 		trace.WithAttributes(
 		  attribute.String("mongodb.collection", m.Collection),
 		  attribute.String("entry.id", id),
@@ -246,7 +331,7 @@ func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocTy
 func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
 	ctx, span := m.tracer.Start(
 		ctx,
-		"CreateDocument",
+		"UpdateDocument",
 		trace.WithAttributes(
 		  attribute.String("mongodb.collection", m.Collection),
 		  attribute.String("entry.id", id),
@@ -261,21 +346,29 @@ func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, docum
 	}
 	db := client.Database(m.DbName)
 	collection := db.Collection(m.Collection)
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
-	switch result.Err() {
-	case nil:
-	case mongo.ErrNoDocuments:
+	result, err := collection.ReplaceOne(ctx, bson.D{{Key: "id", Value: id}}, document)
+	switch {
+	case err != nil:
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	case result.MatchedCount == 0:
 		span.SetStatus(codes.Error, "Document not found")
 		return ErrNotFound
-	default: // other errors - return them
-		span.SetStatus(codes.Error, result.Err().Error())
-		return result.Err()
 	}
-	_, err = collection.ReplaceOne(ctx, bson.D{{Key: "id", Value: id}}, document)
-	return err
+	span.SetStatus(codes.Ok, "Document replaced")
+	return nil
 }
 
 func (m *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	ctx, span := m.tracer.Start(
+		ctx,
+		"DeleteDocument",
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.Collection),
+			attribute.String("entry.id", id),
+		),
+	)
+	defer span.End()
 	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
 	defer contextCancel()
 	client, err := m.connect(ctx)
@@ -284,16 +377,17 @@ func (m *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error
 	}
 	db := client.Database(m.DbName)
 	collection := db.Collection(m.Collection)
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
-	switch result.Err() {
-	case nil:
-	case mongo.ErrNoDocuments:
+	result, err := collection.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
+	switch {
+	case err != nil:
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	case result.DeletedCount == 0:
+		span.SetStatus(codes.Error, "Document not found")
 		return ErrNotFound
-	default: // other errors - return them
-		return result.Err()
 	}
-	_, err = collection.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
-	return err
+	span.SetStatus(codes.Ok, "Document deleted")
+	return nil
 }
 
 func (m *mongoSvc[DocType]) FindAllDocuments(ctx context.Context) ([]*DocType, error) {
@@ -389,3 +483,301 @@ func (m *mongoSvc[DocType]) FindDocumentsByFilter(ctx context.Context, filter in
 	span.SetStatus(codes.Ok, fmt.Sprintf("Found %d documents with filter", len(documents)))
 	return documents, nil
 }
+
+func (m *mongoSvc[DocType]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// nested call - join the already running transaction
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	ctx, span := m.tracer.Start(
+		ctx,
+		"WithTransaction",
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.Collection),
+		),
+	)
+	defer span.End()
+
+	client, err := m.connect(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Transaction committed")
+	return nil
+}
+
+func (m *mongoSvc[DocType]) FindDocumentsPage(ctx context.Context, request PageRequest) (*Page[DocType], error) {
+	ctx, span := m.tracer.Start(
+		ctx,
+		"FindDocumentsPage",
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.Collection),
+			attribute.Int64("page.offset", request.Offset),
+			attribute.Int64("page.limit", request.Limit),
+		),
+	)
+	defer span.End()
+
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	filter := request.Filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// id as the last sort key keeps the order stable between pages
+	sort := bson.D{}
+	for _, field := range request.Sort {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: field.Field, Value: direction})
+	}
+	sort = append(sort, bson.E{Key: "id", Value: 1})
+
+	opts := options.Find().SetSort(sort).SetSkip(request.Offset)
+	if request.Limit > 0 {
+		opts.SetLimit(request.Limit)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	documents := []*DocType{}
+	for cursor.Next(ctx) {
+		var document *DocType
+		if err := cursor.Decode(&document); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	if err := cursor.Err(); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, fmt.Sprintf("Found %d of %d documents", len(documents), total))
+	return &Page[DocType]{Documents: documents, TotalCount: total}, nil
+}
+
+func (m *mongoSvc[DocType]) UpdateDocumentFields(
+	ctx context.Context,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.updateFields(ctx, "UpdateDocumentFields", bson.D{{Key: "id", Value: id}}, id, set, unset)
+}
+
+func (m *mongoSvc[DocType]) UpdateDocumentFieldsVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.updateFields(ctx, "UpdateDocumentFieldsVersioned", versionFilter(id, expectedVersion), id, set, unset)
+}
+
+func (m *mongoSvc[DocType]) updateFields(
+	ctx context.Context,
+	operation string,
+	filter bson.D,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	ctx, span := m.tracer.Start(
+		ctx,
+		operation,
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.Collection),
+			attribute.String("entry.id", id),
+			attribute.Int("fields.set", len(set)),
+			attribute.Int("fields.unset", len(unset)),
+		),
+	)
+	defer span.End()
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		fields := bson.D{}
+		for _, field := range unset {
+			fields = append(fields, bson.E{Key: field, Value: ""})
+		}
+		update = append(update, bson.E{Key: "$unset", Value: fields})
+	}
+
+	var matched int64
+	if len(update) == 0 {
+		matched, err = collection.CountDocuments(ctx, filter)
+	} else {
+		var result *mongo.UpdateResult
+		result, err = collection.UpdateOne(ctx, filter, update)
+		if result != nil {
+			matched = result.MatchedCount
+		}
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if matched == 0 {
+		err = missingOrModified(ctx, collection, id)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Document fields updated")
+	return nil
+}
+
+func (m *mongoSvc[DocType]) UpdateDocumentVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	document *DocType,
+) error {
+	ctx, span := m.tracer.Start(
+		ctx,
+		"UpdateDocumentVersioned",
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.Collection),
+			attribute.String("entry.id", id),
+			attribute.Int64("entry.version", expectedVersion),
+		),
+	)
+	defer span.End()
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	result, err := collection.ReplaceOne(ctx, versionFilter(id, expectedVersion), document)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		err = missingOrModified(ctx, collection, id)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Document replaced")
+	return nil
+}
+
+func (m *mongoSvc[DocType]) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	ctx, span := m.tracer.Start(
+		ctx,
+		"DeleteDocumentVersioned",
+		trace.WithAttributes(
+			attribute.String("mongodb.collection", m.Collection),
+			attribute.String("entry.id", id),
+			attribute.Int64("entry.version", expectedVersion),
+		),
+	)
+	defer span.End()
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	result, err := collection.DeleteOne(ctx, versionFilter(id, expectedVersion))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if result.DeletedCount == 0 {
+		err = missingOrModified(ctx, collection, id)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetStatus(codes.Ok, "Document deleted")
+	return nil
+}
+
+// versionFilter matches the document with the expected version,
+// documents stored before versioning have no version and match version 0
+func versionFilter(id string, version int64) bson.D {
+	if version == 0 {
+		return bson.D{
+			{Key: "id", Value: id},
+			{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}},
+		}
+	}
+	return bson.D{{Key: "id", Value: id}, {Key: "version", Value: version}}
+}
+
+// missingOrModified tells why a write filtered by id did not match any document
+func missingOrModified(ctx context.Context, collection *mongo.Collection, id string) error {
+	count, err := collection.CountDocuments(ctx, bson.D{{Key: "id", Value: id}})
+	switch {
+	case err != nil:
+		return err
+	case count == 0:
+		return ErrNotFound
+	default:
+		return ErrVersionMismatch
+	}
+}
@@ -0,0 +1,40 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMongoService runs against the server of AMBULANCE_API_MONGODB_HOST and the other
+// AMBULANCE_API_MONGODB_* variables, it is skipped when the host is not set. Transactions
+// require the server to run as a replica set, e.g. the one of deployments/docker-compose.
+// Every test creates its own collections and drops them when it ends.
+func TestMongoService(t *testing.T) {
+	if os.Getenv("AMBULANCE_API_MONGODB_HOST") == "" {
+		t.Skip("AMBULANCE_API_MONGODB_HOST is not set")
+	}
+
+	dbName := fmt.Sprintf("test_%x", time.Now().Unix())
+	collections := 0
+	runDbServiceSuite(t, func(t *testing.T, collection string) DbService[suiteDocument] {
+		collections++
+		db := NewMongoService[suiteDocument](MongoServiceConfig{
+			DbName:     dbName,
+			Collection: fmt.Sprintf("%d_%s", collections, collection),
+		})
+		t.Cleanup(func() {
+			ctx := context.Background()
+			svc := db.(*mongoSvc[suiteDocument])
+			client, err := svc.connect(ctx)
+			require.NoError(t, err)
+			require.NoError(t, client.Database(svc.DbName).Collection(svc.Collection).Drop(ctx))
+			require.NoError(t, db.Disconnect(ctx))
+		})
+		return db
+	})
+}
@@ -0,0 +1,26 @@
+package db_service
+
+// SortField orders documents by a persisted field, nested fields use dot notation
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// PageRequest selects a page of documents
+type PageRequest struct {
+	// Filter of the documents, nil matches all documents
+	Filter interface{}
+	// Sort order of the documents, ties are ordered by document id
+	Sort []SortField
+	// Number of matching documents to skip
+	Offset int64
+	// Maximum number of documents in the page, zero means no limit
+	Limit int64
+}
+
+// Page is a slice of documents matching a PageRequest
+type Page[DocType interface{}] struct {
+	Documents []*DocType
+	// Number of all documents matching the filter
+	TotalCount int64
+}
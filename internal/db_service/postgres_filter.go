@@ -0,0 +1,307 @@
+package db_service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresFilter translates filters into SQL conditions on the jsonb `document` column.
+// It supports the same subset of MongoDB query semantics as matchesFilter, scalar conditions
+// are evaluated by SQL/JSON path expressions which search arrays element-wise like MongoDB.
+// Documents are stored as relaxed extended JSON, dates are compared as timestamps.
+type postgresFilter struct {
+	args []interface{}
+}
+
+// translateFilter returns the SQL condition of the filter, placeholders are numbered
+// starting after the argsOffset arguments already used by the statement
+func translateFilter(filter interface{}, argsOffset int) (string, []interface{}, error) {
+	normalized, err := normalizeFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	translator := &postgresFilter{args: make([]interface{}, 0)}
+	condition, err := translator.filter(normalized, argsOffset)
+	if err != nil {
+		return "", nil, err
+	}
+	return condition, translator.args, nil
+}
+
+func (f *postgresFilter) placeholder(value interface{}, argsOffset int) string {
+	f.args = append(f.args, value)
+	return "$" + strconv.Itoa(argsOffset+len(f.args))
+}
+
+func (f *postgresFilter) filter(filter bson.M, argsOffset int) (string, error) {
+	if len(filter) == 0 {
+		return "TRUE", nil
+	}
+
+	// keys are sorted so the same filter always yields the same statement
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conditions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var condition string
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			condition, err = f.logical(key, filter[key], argsOffset)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return "", fmt.Errorf("unsupported filter operator %s", key)
+			}
+			condition, err = f.field(strings.Split(key, "."), filter[key], argsOffset)
+		}
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	return joinConditions(conditions, "AND"), nil
+}
+
+func joinConditions(conditions []string, operator string) string {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return "(" + strings.Join(conditions, " "+operator+" ") + ")"
+}
+
+func (f *postgresFilter) logical(operator string, operand interface{}, argsOffset int) (string, error) {
+	filters, ok := operand.(bson.A)
+	if !ok || len(filters) == 0 {
+		return "", fmt.Errorf("%s requires a non-empty array of filters", operator)
+	}
+	conditions := make([]string, 0, len(filters))
+	for _, item := range filters {
+		filter, ok := item.(bson.M)
+		if !ok {
+			return "", fmt.Errorf("%s requires an array of filters", operator)
+		}
+		condition, err := f.filter(filter, argsOffset)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	switch operator {
+	case "$and":
+		return joinConditions(conditions, "AND"), nil
+	case "$or":
+		return joinConditions(conditions, "OR"), nil
+	default:
+		return "NOT " + joinConditions(conditions, "OR"), nil
+	}
+}
+
+func (f *postgresFilter) field(path []string, condition interface{}, argsOffset int) (string, error) {
+	operators, ok := isOperatorDocument(condition)
+	if !ok {
+		return f.equal(path, condition, argsOffset)
+	}
+
+	keys := make([]string, 0, len(operators))
+	for key := range operators {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conditions := make([]string, 0, len(keys))
+	for _, operator := range keys {
+		operand := operators[operator]
+		var condition string
+		var err error
+		switch operator {
+		case "$eq":
+			condition, err = f.equal(path, operand, argsOffset)
+		case "$ne":
+			condition, err = f.equal(path, operand, argsOffset)
+			condition = "NOT " + condition
+		case "$in", "$nin":
+			condition, err = f.in(path, operand, argsOffset)
+			if operator == "$nin" {
+				condition = "NOT " + condition
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			condition, err = f.compare(path, comparisonOperators[operator], operand, argsOffset)
+		case "$exists":
+			exists, ok := operand.(bool)
+			if !ok {
+				return "", fmt.Errorf("$exists requires a boolean")
+			}
+			condition = f.pathExists(jsonPath(path), argsOffset)
+			if !exists {
+				condition = "NOT " + condition
+			}
+		case "$regex":
+			condition, err = f.regex(path, operand, operators["$options"], argsOffset)
+		case "$options":
+			continue // applied together with $regex
+		default:
+			return "", fmt.Errorf("unsupported filter operator %s", operator)
+		}
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 0 {
+		return "TRUE", nil
+	}
+	return joinConditions(conditions, "AND"), nil
+}
+
+var comparisonOperators = map[string]string{
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+// jsonPath returns the SQL/JSON path of the field, in the lax mode arrays are unwrapped
+func jsonPath(path []string) string {
+	var builder strings.Builder
+	builder.WriteString("$")
+	for _, key := range path {
+		builder.WriteString(".")
+		builder.WriteString(jsonLiteral(key))
+	}
+	return builder.String()
+}
+
+// jsonLiteral quotes the value for a SQL/JSON path expression, the syntax of its
+// literals matches JSON
+func jsonLiteral(value interface{}) string {
+	literal, _ := json.Marshal(value)
+	return string(literal)
+}
+
+func (f *postgresFilter) pathExists(path string, argsOffset int) string {
+	return "jsonb_path_exists(document, " + f.placeholder(path, argsOffset) + "::jsonpath)"
+}
+
+// scalarLiteral converts the normalized filter value to a SQL/JSON path literal
+func scalarLiteral(value interface{}) (string, error) {
+	switch scalar := value.(type) {
+	case nil:
+		return "null", nil
+	case string, bool, int32, int64, float64:
+		return jsonLiteral(scalar), nil
+	}
+	return "", fmt.Errorf("unsupported filter value of type %T", value)
+}
+
+// dateText returns the stored date of the field as timestamptz, documents store dates
+// before 1970 in another form, those never match
+func dateText(path []string) string {
+	datePath := append(append([]string{}, path...), "$date")
+	literal := "'{" + strings.Join(quotePathElements(datePath), ",") + "}'"
+	return "(CASE WHEN jsonb_typeof(document #> " + literal + ") = 'string' " +
+		"THEN (document #>> " + literal + ")::timestamptz END)"
+}
+
+func quotePathElements(path []string) []string {
+	quoted := make([]string, len(path))
+	for i, key := range path {
+		key = strings.ReplaceAll(key, `\`, `\\`)
+		key = strings.ReplaceAll(key, `"`, `\"`)
+		key = strings.ReplaceAll(key, `'`, `''`)
+		quoted[i] = `"` + key + `"`
+	}
+	return quoted
+}
+
+func (f *postgresFilter) equal(path []string, value interface{}, argsOffset int) (string, error) {
+	if date, ok := value.(primitive.DateTime); ok {
+		return dateText(path) + " = " + f.placeholder(date.Time(), argsOffset), nil
+	}
+	literal, err := scalarLiteral(value)
+	if err != nil {
+		return "", err
+	}
+	matches := f.pathExists(jsonPath(path)+" ? (@ == "+literal+")", argsOffset)
+	if value == nil {
+		// null matches a missing field as well
+		return "(" + matches + " OR NOT " + f.pathExists(jsonPath(path), argsOffset) + ")", nil
+	}
+	return matches, nil
+}
+
+func (f *postgresFilter) in(path []string, operand interface{}, argsOffset int) (string, error) {
+	candidates, ok := operand.(bson.A)
+	if !ok {
+		return "", fmt.Errorf("$in requires an array")
+	}
+	if len(candidates) == 0 {
+		return "FALSE", nil
+	}
+	conditions := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		condition, err := f.equal(path, candidate, argsOffset)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	return joinConditions(conditions, "OR"), nil
+}
+
+func (f *postgresFilter) compare(path []string, operator string, operand interface{}, argsOffset int) (string, error) {
+	if date, ok := operand.(primitive.DateTime); ok {
+		return dateText(path) + " " + operator + " " + f.placeholder(date.Time(), argsOffset), nil
+	}
+	literal, err := scalarLiteral(operand)
+	if err != nil || operand == nil {
+		return "", fmt.Errorf("unsupported comparison value of type %T", operand)
+	}
+	return f.pathExists(jsonPath(path)+" ? (@ "+operator+" "+literal+")", argsOffset), nil
+}
+
+func (f *postgresFilter) regex(path []string, operand interface{}, options interface{}, argsOffset int) (string, error) {
+	var pattern, flags string
+	switch regex := operand.(type) {
+	case string:
+		pattern = regex
+	case primitive.Regex:
+		pattern, flags = regex.Pattern, regex.Options
+	default:
+		return "", fmt.Errorf("$regex requires a string pattern")
+	}
+	if value, ok := options.(string); ok {
+		flags += value
+	}
+	supported := ""
+	for _, flag := range flags {
+		switch flag {
+		case 'i', 'm', 's':
+			supported += string(flag)
+		}
+	}
+	expression := jsonPath(path) + " ? (@ like_regex " + jsonLiteral(pattern)
+	if supported != "" {
+		expression += " flag " + jsonLiteral(supported)
+	}
+	return f.pathExists(expression+")", argsOffset), nil
+}
+
+// sortExpression returns the ORDER BY expression of the field,
+// missing fields sort first like in MongoDB
+func sortExpression(field SortField) string {
+	expression := "document #> '{" + strings.Join(quotePathElements(strings.Split(field.Field, ".")), ",") + "}'"
+	if field.Descending {
+		return expression + " DESC NULLS LAST"
+	}
+	return expression + " ASC NULLS FIRST"
+}
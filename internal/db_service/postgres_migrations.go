@@ -0,0 +1,66 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresMigrations are applied in order to the table of every collection,
+// the placeholder {{table}} is replaced by the quoted table name and {{name}} by the bare name.
+// Applied migrations are recorded in schema_migrations, never change a released migration,
+// append a new one instead.
+var postgresMigrations = []string{
+	// 1: documents stored as jsonb, seq keeps the insertion order
+	`CREATE TABLE IF NOT EXISTS {{table}} (
+		id text PRIMARY KEY,
+		seq bigserial NOT NULL,
+		document jsonb NOT NULL
+	)`,
+	// 2: supports containment and existence queries on documents
+	`CREATE INDEX IF NOT EXISTS "{{name}}_document_idx" ON {{table}} USING gin (document jsonb_path_ops)`,
+}
+
+// migrateCollection brings the table of the collection to the latest schema version,
+// concurrent instances are serialized by an advisory lock
+func migrateCollection(ctx context.Context, pool *pgxpool.Pool, collection string) error {
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			collection text NOT NULL,
+			version integer NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now(),
+			PRIMARY KEY (collection, version)
+		)`); err != nil {
+			return err
+		}
+
+		var current int
+		err := tx.QueryRow(ctx,
+			"SELECT COALESCE(max(version), 0) FROM schema_migrations WHERE collection = $1",
+			collection).Scan(&current)
+		if err != nil {
+			return err
+		}
+
+		replacer := strings.NewReplacer("{{table}}", pgx.Identifier{collection}.Sanitize(), "{{name}}", collection)
+		for version := current + 1; version <= len(postgresMigrations); version++ {
+			if _, err := tx.Exec(ctx, replacer.Replace(postgresMigrations[version-1])); err != nil {
+				return fmt.Errorf("migration %d of %s failed: %w", version, collection, err)
+			}
+			if _, err := tx.Exec(ctx,
+				"INSERT INTO schema_migrations (collection, version) VALUES ($1, $2)",
+				collection, version); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %d of %s", version, collection)
+		}
+		return nil
+	})
+}
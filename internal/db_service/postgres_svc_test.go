@@ -0,0 +1,73 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestPostgresService runs against the database of AMBULANCE_API_POSTGRES_URL, e.g. the one of
+// deployments/docker-compose, and is skipped when the variable is not set. Every test creates
+// its own tables and drops them when it ends.
+func TestPostgresService(t *testing.T) {
+	url := os.Getenv("AMBULANCE_API_POSTGRES_URL")
+	if url == "" {
+		t.Skip("AMBULANCE_API_POSTGRES_URL is not set")
+	}
+
+	prefix := fmt.Sprintf("test_%x", time.Now().Unix())
+	tables := 0
+	runDbServiceSuite(t, func(t *testing.T, collection string) DbService[suiteDocument] {
+		tables++
+		table := fmt.Sprintf("%s_%d_%s", prefix, tables, collection)
+		db := NewPostgresService[suiteDocument](PostgresServiceConfig{Url: url, Collection: table})
+		t.Cleanup(func() {
+			ctx := context.Background()
+			require.NoError(t, db.Disconnect(ctx))
+			dropCollection(t, url, table)
+		})
+		return db
+	})
+}
+
+func dropCollection(t *testing.T, url string, collection string) {
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{collection}.Sanitize())
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "DELETE FROM schema_migrations WHERE collection = $1", collection)
+	require.NoError(t, err)
+}
+
+func TestTranslateFilter(t *testing.T) {
+	condition, args, err := translateFilter(bson.M{
+		"count": bson.M{"$gte": 2},
+		"name":  "Alena",
+	}, 1)
+	require.NoError(t, err)
+	assert.Equal(t,
+		"(jsonb_path_exists(document, $2::jsonpath) AND jsonb_path_exists(document, $3::jsonpath))",
+		condition)
+	assert.Equal(t, []interface{}{`$."count" ? (@ >= 2)`, `$."name" ? (@ == "Alena")`}, args)
+
+	condition, args, err = translateFilter(nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "TRUE", condition)
+	assert.Empty(t, args)
+
+	_, _, err = translateFilter(bson.M{"$where": "true"}, 0)
+	assert.Error(t, err)
+	_, _, err = translateFilter(bson.M{"tags": bson.M{"$all": bson.A{"red"}}}, 0)
+	assert.Error(t, err)
+}
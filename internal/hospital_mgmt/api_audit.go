@@ -0,0 +1,21 @@
+/*
+ * Hospital Management Api
+ *
+ * Hospital Management system for Web-In-Cloud
+ *
+ * API version: 1.0.0
+ * Contact: xsabol@stuba.sk
+ */
+
+package hospital_mgmt
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AuditAPI interface {
+
+	// GetAuditEntries Get /api/audit
+	// Gets the recorded writes of departments, beds and patients
+	GetAuditEntries(c *gin.Context)
+}
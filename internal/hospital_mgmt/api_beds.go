@@ -35,7 +35,23 @@ type BedsAPI interface {
 	// Updates specific bed
 	UpdateBed(c *gin.Context)
 
+	// PatchBed Patch /api/beds/:bedId
+	// Partially updates specific bed
+	PatchBed(c *gin.Context)
+
 	// DeleteBed Delete /api/beds/:bedId
 	// Deletes specific bed
 	DeleteBed(c *gin.Context)
+
+	// RestoreBed Post /api/beds/:bedId/restore
+	// Restores specific deleted bed
+	RestoreBed(c *gin.Context)
+
+	// GetBedHistory Get /api/beds/:bedId/history
+	// Gets the revisions of a specific bed
+	GetBedHistory(c *gin.Context)
+
+	// RestoreBedRevision Post /api/beds/:bedId/history/:revisionId/restore
+	// Restores specific bed to a previous revision
+	RestoreBedRevision(c *gin.Context)
 } 
\ No newline at end of file
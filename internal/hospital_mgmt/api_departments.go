@@ -31,7 +31,27 @@ type DepartmentsAPI interface {
 	// Updates specific department
 	UpdateDepartment(c *gin.Context)
 
+	// PatchDepartment Patch /api/departments/:departmentId
+	// Partially updates specific department
+	PatchDepartment(c *gin.Context)
+
 	// DeleteDepartment Delete /api/departments/:departmentId
 	// Deletes specific department
 	DeleteDepartment(c *gin.Context)
+
+	// RestoreDepartment Post /api/departments/:departmentId/restore
+	// Restores specific deleted department
+	RestoreDepartment(c *gin.Context)
+
+	// GetDepartmentHistory Get /api/departments/:departmentId/history
+	// Gets the revisions of a specific department
+	GetDepartmentHistory(c *gin.Context)
+
+	// RestoreDepartmentRevision Post /api/departments/:departmentId/history/:revisionId/restore
+	// Restores specific department to a previous revision
+	RestoreDepartmentRevision(c *gin.Context)
+
+	// ReconcileDepartmentCapacity Post /api/departments/reconcile
+	// Reports and repairs departments whose bed counts disagree with the beds collection
+	ReconcileDepartmentCapacity(c *gin.Context)
 } 
\ No newline at end of file
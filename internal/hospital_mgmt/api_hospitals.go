@@ -0,0 +1,37 @@
+/*
+ * Hospital Management Api
+ *
+ * Hospital Management system for Web-In-Cloud
+ *
+ * API version: 1.0.0
+ * Contact: xsabol@stuba.sk
+ */
+
+package hospital_mgmt
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type HospitalsAPI interface {
+
+	// CreateHospital Post /api/hospitals
+	// Creates a new hospital
+	CreateHospital(c *gin.Context)
+
+	// GetHospital Get /api/hospitals/:hospitalId
+	// Gets details about a specific hospital
+	GetHospital(c *gin.Context)
+
+	// GetHospitals Get /api/hospitals
+	// Gets list of all hospitals
+	GetHospitals(c *gin.Context)
+
+	// UpdateHospital Put /api/hospitals/:hospitalId
+	// Updates specific hospital
+	UpdateHospital(c *gin.Context)
+
+	// DeleteHospital Delete /api/hospitals/:hospitalId
+	// Deletes specific hospital without any data
+	DeleteHospital(c *gin.Context)
+}
@@ -27,14 +27,34 @@ type PatientsAPI interface {
 	// Gets list of all patients
 	GetPatients(c *gin.Context)
 
+	// SearchPatients Get /api/patients/search
+	// Searches patients by name, birth date, phone and email
+	SearchPatients(c *gin.Context)
+
 	// UpdatePatient Put /api/patients/:patientId
 	// Updates specific patient
 	UpdatePatient(c *gin.Context)
 
+	// PatchPatient Patch /api/patients/:patientId
+	// Partially updates specific patient
+	PatchPatient(c *gin.Context)
+
 	// DeletePatient Delete /api/patients/:patientId
 	// Deletes specific patient
 	DeletePatient(c *gin.Context)
 
+	// RestorePatient Post /api/patients/:patientId/restore
+	// Restores specific deleted patient
+	RestorePatient(c *gin.Context)
+
+	// GetPatientHistory Get /api/patients/:patientId/history
+	// Gets the revisions of a specific patient
+	GetPatientHistory(c *gin.Context)
+
+	// RestorePatientRevision Post /api/patients/:patientId/history/:revisionId/restore
+	// Restores specific patient to a previous revision
+	RestorePatientRevision(c *gin.Context)
+
 	// AddHospitalizationRecord Post /api/patients/:patientId/hospitalizations
 	// Adds a new hospitalization record to a patient
 	AddHospitalizationRecord(c *gin.Context)
@@ -46,4 +66,20 @@ type PatientsAPI interface {
 	// DeleteHospitalizationRecord Delete /api/patients/:patientId/hospitalizations/:recordId
 	// Deletes a specific hospitalization record
 	DeleteHospitalizationRecord(c *gin.Context)
+
+	// AdmitPatient Post /api/patients/:patientId/admissions
+	// Admits a patient to a free bed
+	AdmitPatient(c *gin.Context)
+
+	// DischargePatient Post /api/patients/:patientId/discharge
+	// Discharges a currently admitted patient
+	DischargePatient(c *gin.Context)
+
+	// TransferPatient Post /api/patients/:patientId/transfers
+	// Moves an admitted patient to another bed
+	TransferPatient(c *gin.Context)
+
+	// GetPatientTransfers Get /api/patients/:patientId/transfers
+	// Gets all bed transfers of a patient
+	GetPatientTransfers(c *gin.Context)
 } 
\ No newline at end of file
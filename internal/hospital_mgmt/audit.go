@@ -0,0 +1,216 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/psabol571/sarsabsim-webapi/internal/auth"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+// kinds of documents recorded in the audit log
+const (
+	AuditEntityDepartment = "department"
+	AuditEntityBed        = "bed"
+	AuditEntityPatient    = "patient"
+)
+
+var auditEntities = []string{AuditEntityDepartment, AuditEntityBed, AuditEntityPatient}
+
+const (
+	auditActionCreate = "create"
+	auditActionUpdate = "update"
+	auditActionDelete = "delete"
+	// the deleted document was removed for good, the values of the entity are redacted from its entries
+	auditActionPurge = "purge"
+)
+
+// auditValueFields hold the values of the document in an entry, the only fields of the entry
+// which can be removed, by the purge of the document
+var auditValueFields = []string{"before", "after"}
+
+var errAuditAppendOnly = errors.New("audit entries cannot be changed or deleted")
+
+// auditLog records the writes of the documents of the entity
+type auditLog[DocType interface{}] struct {
+	audit  db_service.DbService[AuditEntry]
+	entity string
+}
+
+// NewAuditedService wraps the db service of the entity so its writes are recorded in the audit
+// log, in the same transaction as the write so a rolled back write leaves no entry. The audit
+// log has to be stored in the same database to share the transactions.
+func NewAuditedService[DocType interface{}](
+	db db_service.DbService[DocType],
+	audit db_service.DbService[AuditEntry],
+	entity string,
+) db_service.DbService[DocType] {
+	log := &auditLog[DocType]{audit: audit, entity: entity}
+	return &recordingSvc[DocType]{DbService: db, record: log.record}
+}
+
+func (m *auditLog[DocType]) record(ctx context.Context, id string, before *DocType, after *DocType) error {
+	action := auditActionUpdate
+	switch {
+	case before == nil:
+		action = auditActionCreate
+	case after == nil:
+		action = auditActionDelete
+	}
+	entry, err := newAuditEntry(ctx, m.entity, id, action)
+	if err != nil {
+		return err
+	}
+
+	entry.Before, entry.After, err = auditChanges(before, after)
+	if err != nil {
+		return err
+	}
+	return m.audit.CreateDocument(ctx, entry.Id, entry)
+}
+
+// newAuditEntry returns the entry of the action made by the request of the context
+func newAuditEntry(ctx context.Context, entity string, id string, action string) (*AuditEntry, error) {
+	entryId, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &AuditEntry{
+		Id:        entryId.String(),
+		Entity:    entity,
+		EntityId:  id,
+		Action:    action,
+		Timestamp: time.Now().UTC(),
+	}
+	entry.Actor, entry.Route = auditRequest(ctx)
+	return entry, nil
+}
+
+// auditRequest returns the actor and the route of the request the context belongs to, the actor
+// is the subject of the token the request is authenticated with. Requests of an api running
+// without authentication are anonymous whatever they claim, writes made outside of a request are
+// made by the system.
+func auditRequest(ctx context.Context) (string, string) {
+	c, ok := ctx.Value(gin.ContextKey).(*gin.Context)
+	if !ok {
+		return "system", ""
+	}
+
+	actor, ok := auth.Subject(c)
+	if !ok || actor == "" {
+		actor = "anonymous"
+	}
+	return actor, c.Request.Method + " " + c.FullPath()
+}
+
+// appendOnlySvc inserts and reads the audit entries, the entries are never changed or deleted
+// except for the values redacted by the purge
+type appendOnlySvc struct {
+	db_service.DbService[AuditEntry]
+}
+
+// NewAppendOnlyService wraps the db service of the audit log so its entries can only be
+// inserted, the other writes fail with an error. Only the values of a purged document can be
+// removed from its entries.
+func NewAppendOnlyService(audit db_service.DbService[AuditEntry]) db_service.DbService[AuditEntry] {
+	return &appendOnlySvc{DbService: audit}
+}
+
+func (m *appendOnlySvc) UpdateDocument(ctx context.Context, id string, entry *AuditEntry) error {
+	return errAuditAppendOnly
+}
+
+func (m *appendOnlySvc) UpdateDocumentVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	entry *AuditEntry,
+) error {
+	return errAuditAppendOnly
+}
+
+func (m *appendOnlySvc) UpdateDocumentFields(
+	ctx context.Context,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	if len(set) > 0 || slices.ContainsFunc(unset, func(field string) bool {
+		return !slices.Contains(auditValueFields, field)
+	}) {
+		return errAuditAppendOnly
+	}
+	return m.DbService.UpdateDocumentFields(ctx, id, nil, unset)
+}
+
+func (m *appendOnlySvc) UpdateDocumentFieldsVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return errAuditAppendOnly
+}
+
+func (m *appendOnlySvc) DeleteDocument(ctx context.Context, id string) error {
+	return errAuditAppendOnly
+}
+
+func (m *appendOnlySvc) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	return errAuditAppendOnly
+}
+
+// auditChanges returns the previous and the new values of the fields that differ, as they are
+// seen in the api, a missing document has no fields
+func auditChanges[DocType interface{}](before *DocType, after *DocType) (map[string]interface{}, map[string]interface{}, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+	for field, value := range beforeFields {
+		if previous, ok := afterFields[field]; !ok || !reflect.DeepEqual(previous, value) {
+			changedBefore[field] = value
+		}
+	}
+	for field, value := range afterFields {
+		if previous, ok := beforeFields[field]; !ok || !reflect.DeepEqual(previous, value) {
+			changedAfter[field] = value
+		}
+	}
+
+	if len(changedBefore) == 0 {
+		changedBefore = nil
+	}
+	if len(changedAfter) == 0 {
+		changedAfter = nil
+	}
+	return changedBefore, changedAfter, nil
+}
+
+func auditFields[DocType interface{}](document *DocType) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if document == nil {
+		return fields, nil
+	}
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(encoded, &fields)
+	return fields, err
+}
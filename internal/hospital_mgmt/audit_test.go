@@ -0,0 +1,260 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (api *testApi) auditEntries(query string) []AuditEntry {
+	api.t.Helper()
+	return decode[[]AuditEntry](api.t, api.get("/api/audit?"+query), http.StatusOK)
+}
+
+func auditActions(entries []AuditEntry) []string {
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Entity+" "+entry.EntityId+" "+entry.Action)
+	}
+	return actions
+}
+
+func TestAuditRecordsWrites(t *testing.T) {
+	api := newTestApi(t)
+
+	requireStatus(t, api.do(testRequest{
+		method: http.MethodPost,
+		path:   "/api/patients",
+		body:   Patient{Id: "patient-1", FirstName: "Jana", LastName: "Novakova", Phone: "+421900111222", BirthDate: "1980-01-01", Gender: "F"},
+		actor:  "clerk-1",
+	}), http.StatusCreated)
+	requireStatus(t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/patients/patient-1",
+		body:        map[string]interface{}{"phone": "+421900333444", "email": nil},
+		contentType: mergePatchContentType,
+		actor:       "nurse-1",
+	}), http.StatusOK)
+	requireStatus(t, api.do(testRequest{
+		method: http.MethodPut,
+		path:   "/api/patients/patient-1",
+		body:   Patient{FirstName: "Jana", LastName: "Kralova", Phone: "+421900333444", BirthDate: "1980-01-01", Gender: "F"},
+		actor:  "clerk-1",
+	}), http.StatusOK)
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNoContent)
+
+	entries := api.auditEntries("entity=patient&id=patient-1")
+	require.Equal(t, []string{
+		"patient patient-1 create",
+		"patient patient-1 update",
+		"patient patient-1 update",
+		"patient patient-1 delete",
+	}, auditActions(entries))
+
+	created := entries[0]
+	assert.Equal(t, "clerk-1", created.Actor)
+	assert.Equal(t, "POST /api/patients", created.Route)
+	assert.False(t, created.Timestamp.IsZero())
+	assert.Nil(t, created.Before)
+	assert.Equal(t, "Jana", created.After["first_name"])
+	assert.Equal(t, "+421900111222", created.After["phone"])
+	assert.EqualValues(t, 1, created.After["version"])
+
+	patched := entries[1]
+	assert.Equal(t, "nurse-1", patched.Actor)
+	assert.Equal(t, "PATCH /api/patients/:patientId", patched.Route)
+	assert.Equal(t, "+421900111222", patched.Before["phone"])
+	assert.Equal(t, "+421900333444", patched.After["phone"])
+	assert.EqualValues(t, 1, patched.Before["version"])
+	assert.EqualValues(t, 2, patched.After["version"])
+	assert.NotContains(t, patched.Before, "first_name", "unchanged fields are not recorded")
+	assert.NotContains(t, patched.After, "first_name")
+
+	replaced := entries[2]
+	assert.Equal(t, "PUT /api/patients/:patientId", replaced.Route)
+	assert.Equal(t, "Novakova", replaced.Before["last_name"])
+	assert.Equal(t, "Kralova", replaced.After["last_name"])
+	assert.NotContains(t, replaced.After, "phone")
+
+	deleted := entries[3]
+	assert.Equal(t, testSubject, deleted.Actor)
+	assert.Equal(t, "DELETE /api/patients/:patientId", deleted.Route)
+	assert.Equal(t, "Kralova", deleted.Before["last_name"])
+	assert.Nil(t, deleted.After)
+
+	assert.Equal(t, []string{"patient patient-1 create", "patient patient-1 update"},
+		auditActions(api.auditEntries("actor=clerk-1")))
+	assert.Equal(t, []string{"patient patient-1 delete", "patient patient-1 update", "patient patient-1 update", "patient patient-1 create"},
+		auditActions(api.auditEntries("sort=-id")))
+}
+
+// the actor is the subject of the token, the X-Actor header cannot name another one
+func TestAuditActorIsAuthenticated(t *testing.T) {
+	api := newTestApi(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/patients",
+		strings.NewReader(`{"id":"patient-1","first_name":"Jana","last_name":"Novakova","birth_date":"1980-01-01","gender":"F"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testTokenWithClaims(t, "clerk-1", []string{RoleClerk},
+		jwt.MapClaims{hospitalClaim: testHospital}))
+	req.Header.Set("X-Actor", "doctor-1")
+	recorder := httptest.NewRecorder()
+	api.handler.ServeHTTP(recorder, req)
+	requireStatus(t, recorder, http.StatusCreated)
+
+	entries := api.auditEntries("id=patient-1")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "clerk-1", entries[0].Actor)
+
+	// requests without a token write nothing
+	requireStatus(t, api.do(testRequest{
+		method:    http.MethodPost,
+		path:      "/api/patients",
+		body:      Patient{Id: "patient-2", FirstName: "Eva", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F"},
+		anonymous: true,
+	}), http.StatusUnauthorized)
+	requireStatus(t, api.get("/api/patients/patient-2"), http.StatusNotFound)
+	assert.Empty(t, api.auditEntries("id=patient-2"))
+}
+
+// without authentication the requests are anonymous, the X-Actor header cannot name the actor
+func TestAuditActorWithoutAuthentication(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, engine := gin.CreateTestContext(recorder)
+	engine.POST("/api/patients", func(c *gin.Context) {
+		actor, route := auditRequest(c)
+		assert.Equal(t, "anonymous", actor)
+		assert.Equal(t, "POST /api/patients", route)
+	})
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/patients", nil)
+	c.Request.Header.Set("X-Actor", "doctor-1")
+	engine.HandleContext(c)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+// the entries of the audit log are never changed or deleted, the purge only removes their values
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Novakova")
+	entries := api.auditEntries("id=patient-1")
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	ctx := WithHospital(context.Background(), testHospital)
+
+	assert.ErrorIs(t, api.audit.UpdateDocument(ctx, entry.Id, &entry), errAuditAppendOnly)
+	assert.ErrorIs(t, api.audit.UpdateDocumentVersioned(ctx, entry.Id, 1, &entry), errAuditAppendOnly)
+	assert.ErrorIs(t, api.audit.UpdateDocumentFields(ctx, entry.Id,
+		map[string]interface{}{"actor": "doctor-1"}, nil), errAuditAppendOnly)
+	assert.ErrorIs(t, api.audit.UpdateDocumentFields(ctx, entry.Id, nil, []string{"actor"}), errAuditAppendOnly)
+	assert.ErrorIs(t, api.audit.UpdateDocumentFieldsVersioned(ctx, entry.Id, 1, nil, []string{"after"}), errAuditAppendOnly)
+	assert.ErrorIs(t, api.audit.DeleteDocument(ctx, entry.Id), errAuditAppendOnly)
+	assert.ErrorIs(t, api.audit.DeleteDocumentVersioned(ctx, entry.Id, 1), errAuditAppendOnly)
+
+	entries = api.auditEntries("id=patient-1")
+	require.Len(t, entries, 1)
+	assert.Equal(t, entry, entries[0])
+
+	require.NoError(t, api.audit.UpdateDocumentFields(ctx, entry.Id, nil, auditValueFields))
+	entries = api.auditEntries("id=patient-1")
+	require.Len(t, entries, 1)
+	assert.Nil(t, entries[0].After)
+	assert.Equal(t, entry.Actor, entries[0].Actor)
+}
+
+// writes of other collections made by a request are recorded with the route of the request
+func TestAuditRecordsCascadingWrites(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	record := api.admit("patient-1", "bed-1")
+
+	entries := api.auditEntries("")
+	require.Equal(t, []string{
+		"department surgery create",
+		"bed bed-1 create",
+		"department surgery update",
+		"patient patient-1 create",
+		"bed bed-1 update",
+		"department surgery update",
+		"patient patient-1 update",
+	}, auditActions(entries))
+
+	for _, entry := range entries[4:] {
+		assert.Equal(t, "POST /api/patients/:patientId/admissions", entry.Route)
+	}
+	bed := entries[4]
+	assert.Equal(t, map[string]interface{}{"patient_id": "patient-1", "description": "Occupied"}, bed.After["status"])
+	department := entries[5]
+	assert.EqualValues(t, 0, department.Before["capacity"].(map[string]interface{})["occupied_beds"])
+	assert.EqualValues(t, 1, department.After["capacity"].(map[string]interface{})["occupied_beds"])
+	patient := entries[6]
+	records, ok := patient.After["hospitalization_records"].([]interface{})
+	require.True(t, ok, "hospitalization records of %v", patient.After)
+	require.Len(t, records, 1)
+	assert.Equal(t, record.Id, records[0].(map[string]interface{})["id"])
+
+	assert.Len(t, api.auditEntries("entity=department"), 3)
+	assert.Len(t, api.auditEntries("entity=bed&id=bed-1"), 2)
+}
+
+func TestAuditSkipsFailedWrites(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	api.admit("patient-1", "bed-1")
+	recorded := len(api.auditEntries(""))
+
+	requireStatus(t, api.post("/api/departments", Department{Id: "surgery"}), http.StatusConflict)
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/patients/patient-2",
+		body:    Patient{FirstName: "Eva", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F"},
+		ifMatch: versionETag(7),
+	}), http.StatusPreconditionFailed)
+	// the admission fails after the patient has been read, nothing of it is recorded
+	requireStatus(t, api.post("/api/patients/patient-2/admissions", Admission{BedId: "bed-1"}), http.StatusConflict)
+
+	assert.Len(t, api.auditEntries(""), recorded)
+}
+
+// writes made outside of requests are recorded as made by the system
+func TestAuditRecordsWritesOutsideRequests(t *testing.T) {
+	api := newTestApi(t)
+	ctx := WithHospital(context.Background(), testHospital)
+	require.NoError(t, api.departments.CreateDocument(ctx, "surgery", &Department{Id: "surgery", Version: 1}))
+
+	entries := api.auditEntries("entity=department")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "system", entries[0].Actor)
+	assert.Empty(t, entries[0].Route)
+	assert.Equal(t, testHospital, entries[0].HospitalId)
+}
+
+func TestGetAuditEntries(t *testing.T) {
+	api := newTestApi(t)
+	for _, id := range []string{"patient-1", "patient-2", "patient-3"} {
+		api.createPatient(id, "Jana", "Novakova")
+	}
+
+	response := api.get("/api/audit?entity=patient&page_size=2&page=2")
+	entries := decode[[]AuditEntry](t, response, http.StatusOK)
+	assert.Equal(t, []string{"patient patient-3 create"}, auditActions(entries))
+	assert.Equal(t, "3", response.Header().Get("X-Total-Count"))
+
+	assert.Empty(t, api.auditEntries("entity=bed"))
+	assert.Empty(t, api.auditEntries("id=missing"))
+
+	requireStatus(t, api.get("/api/audit?entity=ward"), http.StatusBadRequest)
+	requireStatus(t, api.get("/api/audit?sort=before"), http.StatusBadRequest)
+	requireStatus(t, api.get("/api/audit?page=0"), http.StatusBadRequest)
+}
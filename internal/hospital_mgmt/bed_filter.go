@@ -0,0 +1,61 @@
+package hospital_mgmt
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseBedFilter builds the db filter of the bed list endpoints from the query parameters.
+// Every parameter is parsed into a typed value and placed under a fixed field, so query
+// values can never inject query operators.
+func parseBedFilter(c *gin.Context) (map[string]interface{}, error) {
+	filter := map[string]interface{}{}
+
+	if value := c.Query("bed_type"); value != "" {
+		filter["bed_type"] = value
+	}
+
+	if value := c.Query("department_id"); value != "" {
+		filter["department_id"] = value
+	}
+
+	quality := map[string]interface{}{}
+	for parameter, operator := range map[string]string{"min_quality": "$gte", "max_quality": "$lte"} {
+		value := c.Query(parameter)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+			return nil, fmt.Errorf("%s must be a number", parameter)
+		}
+		quality[operator] = parsed
+	}
+	if minimum, ok := quality["$gte"].(float64); ok {
+		if maximum, ok := quality["$lte"].(float64); ok && minimum > maximum {
+			return nil, fmt.Errorf("min_quality must not be greater than max_quality")
+		}
+	}
+	if len(quality) > 0 {
+		filter["bed_quality"] = quality
+	}
+
+	if value := c.Query("occupied"); value != "" {
+		occupied, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("occupied must be true or false")
+		}
+		// free beds store an empty patient id or none at all
+		free := []interface{}{"", nil}
+		if occupied {
+			filter["status.patient_id"] = map[string]interface{}{"$nin": free}
+		} else {
+			filter["status.patient_id"] = map[string]interface{}{"$in": free}
+		}
+	}
+
+	return filter, nil
+}
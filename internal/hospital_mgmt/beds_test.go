@@ -0,0 +1,243 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 1)
+
+	response := api.post("/api/beds", Bed{Id: "bed-1", DepartmentId: "surgery", BedType: "icu"})
+	bed := decode[Bed](t, response, http.StatusCreated)
+	assert.Equal(t, "icu", bed.BedType)
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+	api.requireCapacity("surgery", 1, 0)
+
+	requireStatus(t, api.post("/api/beds", Bed{Id: "bed-1", DepartmentId: "surgery"}), http.StatusConflict)
+	// the department is full
+	requireStatus(t, api.post("/api/beds", Bed{Id: "bed-2", DepartmentId: "surgery"}), http.StatusConflict)
+	requireStatus(t, api.post("/api/beds", "{"), http.StatusBadRequest)
+
+	body := requireProblem(t, api.post("/api/beds", Bed{Id: "bed-3", DepartmentId: "missing"}), http.StatusConflict, codeMissingReference)
+	assert.Equal(t, []Blocker{{Entity: "department", Id: "missing", Reason: "does not exist"}}, body.Blockers)
+	api.requireCapacity("surgery", 1, 0)
+}
+
+func TestCreateOccupiedBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createPatient("patient-1", "Jana", "Novakova")
+
+	// occupancy is set only by admissions
+	requireStatus(t, api.post("/api/beds", Bed{
+		Id:           "bed-1",
+		DepartmentId: "surgery",
+		Status:       BedStatus{PatientId: "patient-1"},
+	}), http.StatusConflict)
+	requireStatus(t, api.get("/api/beds/bed-1"), http.StatusNotFound)
+	api.requireCapacity("surgery", 0, 0)
+}
+
+func TestGetBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+
+	response := api.get("/api/beds/bed-1")
+	bed := decode[Bed](t, response, http.StatusOK)
+	assert.Equal(t, "surgery", bed.DepartmentId)
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+
+	requireStatus(t, api.get("/api/beds/missing"), http.StatusNotFound)
+}
+
+func TestGetBeds(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("pediatrics", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	api.createBed("bed-3", "pediatrics")
+	requireStatus(t, api.mergePatch("/api/beds/bed-3", map[string]interface{}{"bed_quality": 1}), http.StatusOK)
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.admit("patient-1", "bed-2")
+
+	for query, expected := range map[string][]string{
+		"":                          {"bed-1", "bed-2", "bed-3"},
+		"?department_id=surgery":    {"bed-1", "bed-2"},
+		"?occupied=true":            {"bed-2"},
+		"?occupied=false":           {"bed-1", "bed-3"},
+		"?min_quality=0.8":          {"bed-3"},
+		"?max_quality=0.8&sort=-id": {"bed-2", "bed-1"},
+		"?bed_type=icu":             {},
+		"?page=2&page_size=2":       {"bed-3"},
+		"?department_id=pediatrics": {"bed-3"},
+	} {
+		beds := decode[[]Bed](t, api.get("/api/beds"+query), http.StatusOK)
+		assert.Equal(t, expected, bedIds(beds), "query %q", query)
+	}
+
+	for _, query := range []string{"?occupied=maybe", "?min_quality=high", "?min_quality=0.8&max_quality=0.4", "?sort=status"} {
+		requireStatus(t, api.get("/api/beds"+query), http.StatusBadRequest)
+	}
+}
+
+func TestGetBedsByDepartment(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("pediatrics", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "pediatrics")
+	api.createBed("bed-3", "surgery")
+
+	response := api.get("/api/departments/surgery/beds")
+	beds := decode[[]Bed](t, response, http.StatusOK)
+	assert.Equal(t, []string{"bed-1", "bed-3"}, bedIds(beds))
+	assert.Equal(t, "2", response.Header().Get("X-Total-Count"))
+
+	// the path wins over the query parameter
+	beds = decode[[]Bed](t, api.get("/api/departments/surgery/beds?department_id=pediatrics&sort=-id"), http.StatusOK)
+	assert.Equal(t, []string{"bed-3", "bed-1"}, bedIds(beds))
+
+	beds = decode[[]Bed](t, api.get("/api/departments/missing/beds"), http.StatusOK)
+	assert.Empty(t, beds)
+}
+
+func TestUpdateBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("pediatrics", 10)
+	api.createBed("bed-1", "surgery")
+
+	response := api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/beds/bed-1",
+		body:    Bed{DepartmentId: "pediatrics", BedType: "icu", BedQuality: 0.8},
+		ifMatch: `"1"`,
+	})
+	bed := decode[Bed](t, response, http.StatusOK)
+	assert.Equal(t, "pediatrics", bed.DepartmentId)
+	assert.Equal(t, `"2"`, response.Header().Get("ETag"))
+	api.requireCapacity("surgery", 0, 0)
+	api.requireCapacity("pediatrics", 1, 0)
+
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/beds/bed-1",
+		body:    Bed{DepartmentId: "surgery"},
+		ifMatch: `"1"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.put("/api/beds/bed-1", Bed{DepartmentId: "missing"}), http.StatusConflict)
+	requireStatus(t, api.put("/api/beds/missing", Bed{DepartmentId: "surgery"}), http.StatusNotFound)
+	requireStatus(t, api.put("/api/beds/bed-1", "{"), http.StatusBadRequest)
+	api.requireCapacity("pediatrics", 1, 0)
+}
+
+func TestUpdateOccupiedBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("pediatrics", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	api.admit("patient-1", "bed-1")
+
+	for _, bed := range []Bed{
+		{DepartmentId: "surgery", Status: BedStatus{PatientId: "patient-2"}},
+		{DepartmentId: "surgery"},
+		{DepartmentId: "pediatrics", Status: BedStatus{PatientId: "patient-1"}},
+	} {
+		requireStatus(t, api.put("/api/beds/bed-1", bed), http.StatusConflict)
+	}
+	requireStatus(t, api.mergePatch("/api/beds/bed-1", map[string]interface{}{
+		"status": map[string]interface{}{"patient_id": nil},
+	}), http.StatusConflict)
+
+	// other fields stay editable
+	bed := decode[Bed](t, api.put("/api/beds/bed-1", Bed{
+		DepartmentId: "surgery",
+		BedType:      "icu",
+		Status:       BedStatus{PatientId: "patient-1", Description: "Occupied"},
+	}), http.StatusOK)
+	assert.Equal(t, "icu", bed.BedType)
+	api.requireCapacity("surgery", 1, 1)
+	api.requireCapacity("pediatrics", 0, 0)
+}
+
+func TestPatchBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("pediatrics", 10)
+	api.createBed("bed-1", "surgery")
+
+	bed := decode[Bed](t, api.mergePatch("/api/beds/bed-1", map[string]interface{}{
+		"department_id": "pediatrics",
+		"bed_quality":   0.9,
+	}), http.StatusOK)
+	assert.Equal(t, "pediatrics", bed.DepartmentId)
+	assert.Equal(t, 0.9, bed.BedQuality)
+	assert.Equal(t, "standard", bed.BedType)
+	api.requireCapacity("surgery", 0, 0)
+	api.requireCapacity("pediatrics", 1, 0)
+
+	bed = decode[Bed](t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/beds/bed-1",
+		body:        `[{"op": "replace", "path": "/bed_type", "value": "icu"}]`,
+		contentType: jsonPatchContentType,
+	}), http.StatusOK)
+	assert.Equal(t, "icu", bed.BedType)
+
+	requireStatus(t, api.mergePatch("/api/beds/bed-1", map[string]interface{}{"department_id": "missing"}), http.StatusConflict)
+	requireStatus(t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/beds/bed-1",
+		body:        map[string]interface{}{"bed_quality": 1},
+		contentType: mergePatchContentType,
+		ifMatch:     `"1"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.mergePatch("/api/beds/missing", map[string]interface{}{"bed_quality": 1}), http.StatusNotFound)
+	requireStatus(t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/beds/bed-1",
+		body:        `{"bed_quality": 1}`,
+		contentType: "text/plain",
+	}), http.StatusUnsupportedMediaType)
+}
+
+func TestDeleteBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.admit("patient-1", "bed-2")
+
+	body := requireProblem(t, api.delete("/api/beds/bed-2"), http.StatusConflict, codeBedOccupied)
+	assert.Equal(t, []Blocker{{Entity: "patient", Id: "patient-1", Reason: "patient occupies the bed"}}, body.Blockers)
+
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodDelete,
+		path:    "/api/beds/bed-1",
+		ifMatch: `"2"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodDelete,
+		path:    "/api/beds/bed-1",
+		ifMatch: `"1"`,
+	}), http.StatusNoContent)
+	requireStatus(t, api.delete("/api/beds/bed-1"), http.StatusNotFound)
+	api.requireCapacity("surgery", 1, 1)
+}
+
+func bedIds(beds []Bed) []string {
+	ids := []string{}
+	for _, bed := range beds {
+		ids = append(ids, bed.Id)
+	}
+	return ids
+}
@@ -0,0 +1,141 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+var (
+	errDepartmentFull     = errors.New("department has reached its maximum number of beds")
+	errMaximumBelowActual = errors.New("maximum_beds is lower than the number of existing beds")
+)
+
+func (b *Bed) isOccupied() bool {
+	return b.Status.PatientId != ""
+}
+
+// adjustDepartmentCapacity changes the maintained bed counters of the department,
+// adding beds beyond the department's maximum is refused with errDepartmentFull
+func adjustDepartmentCapacity(
+	ctx context.Context,
+	db db_service.DbService[Department],
+	departmentId string,
+	actualDelta int,
+	occupiedDelta int,
+) error {
+	if actualDelta == 0 && occupiedDelta == 0 {
+		return nil
+	}
+
+	department, err := findForUpdate(ctx, db, departmentId, errDepartmentNotFound)
+	if err != nil {
+		return err
+	}
+
+	capacity := &department.Capacity
+	if actualDelta > 0 && capacity.MaximumBeds > 0 && capacity.ActualBeds+actualDelta > capacity.MaximumBeds {
+		return errDepartmentFull
+	}
+	capacity.ActualBeds = max(capacity.ActualBeds+actualDelta, 0)
+	capacity.OccupiedBeds = max(capacity.OccupiedBeds+occupiedDelta, 0)
+	department.UpdatedAt = time.Now()
+	return saveDocument(ctx, db, department)
+}
+
+// adjustDepartmentOccupancy applies the occupied bed changes per department, departments are
+// updated in a stable order. A department which does not exist anymore is ignored when
+// its occupancy drops.
+func adjustDepartmentOccupancy(
+	ctx context.Context,
+	db db_service.DbService[Department],
+	occupiedDeltas map[string]int,
+) error {
+	departmentIds := make([]string, 0, len(occupiedDeltas))
+	for departmentId := range occupiedDeltas {
+		departmentIds = append(departmentIds, departmentId)
+	}
+	sort.Strings(departmentIds)
+
+	for _, departmentId := range departmentIds {
+		delta := occupiedDeltas[departmentId]
+		err := adjustDepartmentCapacity(ctx, db, departmentId, 0, delta)
+		if err != nil && !(err == errDepartmentNotFound && delta < 0) {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBedToCapacity counts the bed into the capacity of its department
+func addBedToCapacity(ctx context.Context, db db_service.DbService[Department], bed *Bed) error {
+	occupied := 0
+	if bed.isOccupied() {
+		occupied = 1
+	}
+	return adjustDepartmentCapacity(ctx, db, bed.DepartmentId, 1, occupied)
+}
+
+// removeBedFromCapacity removes the bed from the capacity of its department,
+// a department which does not exist anymore is ignored
+func removeBedFromCapacity(ctx context.Context, db db_service.DbService[Department], bed *Bed) error {
+	occupied := 0
+	if bed.isOccupied() {
+		occupied = -1
+	}
+	err := adjustDepartmentCapacity(ctx, db, bed.DepartmentId, -1, occupied)
+	if err == errDepartmentNotFound {
+		return nil
+	}
+	return err
+}
+
+// updateBedCapacity moves the bed between department capacities when its department
+// or occupancy changes
+func updateBedCapacity(ctx context.Context, db db_service.DbService[Department], before *Bed, after *Bed) error {
+	if before.DepartmentId != after.DepartmentId {
+		if err := removeBedFromCapacity(ctx, db, before); err != nil {
+			return err
+		}
+		return addBedToCapacity(ctx, db, after)
+	}
+
+	occupiedDelta := 0
+	switch {
+	case after.isOccupied() && !before.isOccupied():
+		occupiedDelta = 1
+	case !after.isOccupied() && before.isOccupied():
+		occupiedDelta = -1
+	}
+	err := adjustDepartmentCapacity(ctx, db, after.DepartmentId, 0, occupiedDelta)
+	if err == errDepartmentNotFound && occupiedDelta < 0 {
+		return nil
+	}
+	return err
+}
+
+// countDepartmentCapacity computes the actual and occupied beds of every department from the beds,
+// the maximum is taken over from the stored department capacity
+func countDepartmentCapacity(departments []*Department, beds []*Bed) map[string]DepartmentCapacity {
+	capacities := make(map[string]DepartmentCapacity, len(departments))
+	for _, department := range departments {
+		capacities[department.Id] = DepartmentCapacity{
+			MaximumBeds: department.Capacity.MaximumBeds,
+		}
+	}
+	for _, bed := range beds {
+		capacity, ok := capacities[bed.DepartmentId]
+		if !ok {
+			continue
+		}
+		capacity.ActualBeds++
+		if bed.isOccupied() {
+			capacity.OccupiedBeds++
+		}
+		capacities[bed.DepartmentId] = capacity
+	}
+	return capacities
+}
@@ -0,0 +1,114 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+var errPreconditionFailed = errors.New("If-Match does not match the current version of the document")
+
+// versionedDocument is a pointer to a document carrying its optimistic concurrency version
+type versionedDocument[DocType interface{}] interface {
+	*DocType
+	documentId() string
+	documentVersion() int64
+	setDocumentVersion(version int64)
+}
+
+func (d *Department) documentId() string               { return d.Id }
+func (d *Department) documentVersion() int64           { return d.Version }
+func (d *Department) setDocumentVersion(version int64) { d.Version = version }
+
+func (b *Bed) documentId() string               { return b.Id }
+func (b *Bed) documentVersion() int64           { return b.Version }
+func (b *Bed) setDocumentVersion(version int64) { b.Version = version }
+
+func (p *Patient) documentId() string               { return p.Id }
+func (p *Patient) documentVersion() int64           { return p.Version }
+func (p *Patient) setDocumentVersion(version int64) { p.Version = version }
+
+// saveDocument replaces the stored document with the next version, the write fails with
+// db_service.ErrVersionMismatch if the document was modified since it has been read
+func saveDocument[DocType interface{}, P versionedDocument[DocType]](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	document P,
+) error {
+	expected := document.documentVersion()
+	document.setDocumentVersion(expected + 1)
+	err := db.UpdateDocumentVersioned(ctx, document.documentId(), expected, (*DocType)(document))
+	if err != nil {
+		document.setDocumentVersion(expected)
+	}
+	return err
+}
+
+// deleteDocument deletes the document if it was not modified since it has been read
+func deleteDocument[DocType interface{}, P versionedDocument[DocType]](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	document P,
+) error {
+	return db.DeleteDocumentVersioned(ctx, document.documentId(), document.documentVersion())
+}
+
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setETag sets the ETag header to the version of the returned document
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", versionETag(version))
+}
+
+// ifMatch holds the entity tags of the If-Match request header, nil if the header is missing
+type ifMatch []string
+
+func parseIfMatch(c *gin.Context) ifMatch {
+	var tags ifMatch
+	for _, value := range c.Request.Header.Values("If-Match") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// check returns errPreconditionFailed unless the request has no If-Match header,
+// or one of its tags is `*` or the ETag of the version. Weak tags never match.
+func (m ifMatch) check(version int64) error {
+	if m == nil {
+		return nil
+	}
+	etag := versionETag(version)
+	for _, tag := range m {
+		if tag == "*" || tag == etag {
+			return nil
+		}
+	}
+	return errPreconditionFailed
+}
+
+// respondConcurrencyError writes 412 for a failed If-Match precondition and 409 for
+// a document modified concurrently, returns false for other errors
+func respondConcurrencyError(c *gin.Context, err error) bool {
+	switch err {
+	case errPreconditionFailed:
+		respondProblem(c, http.StatusPreconditionFailed, codePreconditionFailed,
+			"Document was modified, reload it and retry")
+	case db_service.ErrVersionMismatch:
+		respondProblem(c, http.StatusConflict, codeConcurrentModification,
+			"Document was modified by another request, reload it and retry")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDepartment(t *testing.T) {
+	api := newTestApi(t)
+
+	response := api.post("/api/departments", Department{
+		Id:       "surgery",
+		Name:     "Surgery",
+		Capacity: DepartmentCapacity{MaximumBeds: 10, ActualBeds: 7, OccupiedBeds: 3},
+	})
+	department := decode[Department](t, response, http.StatusCreated)
+	assert.Equal(t, "surgery", department.Id)
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+	// bed counts are derived from the beds, not taken from the request
+	assert.Equal(t, DepartmentCapacity{MaximumBeds: 10}, department.Capacity)
+
+	requireStatus(t, api.post("/api/departments", Department{Id: "surgery"}), http.StatusConflict)
+	requireStatus(t, api.post("/api/departments", "{"), http.StatusBadRequest)
+
+	generated := decode[Department](t, api.post("/api/departments", Department{Name: "Pediatrics"}), http.StatusCreated)
+	assert.NotEmpty(t, generated.Id)
+}
+
+func TestGetDepartment(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+
+	response := api.get("/api/departments/surgery")
+	department := decode[Department](t, response, http.StatusOK)
+	assert.Equal(t, "Department surgery", department.Name)
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+
+	requireStatus(t, api.get("/api/departments/missing"), http.StatusNotFound)
+}
+
+func TestGetDepartments(t *testing.T) {
+	api := newTestApi(t)
+	for _, id := range []string{"c", "a", "b"} {
+		api.createDepartment(id, 1)
+	}
+
+	response := api.get("/api/departments?sort=-id&page_size=2")
+	departments := decode[[]Department](t, response, http.StatusOK)
+	require.Len(t, departments, 2)
+	assert.Equal(t, "c", departments[0].Id)
+	assert.Equal(t, "b", departments[1].Id)
+	assert.Equal(t, "3", response.Header().Get("X-Total-Count"))
+	assert.Contains(t, response.Header().Get("Link"), `rel="next"`)
+
+	departments = decode[[]Department](t, api.get("/api/departments?sort=-id&page=2&page_size=2"), http.StatusOK)
+	require.Len(t, departments, 1)
+	assert.Equal(t, "a", departments[0].Id)
+
+	requireStatus(t, api.get("/api/departments?sort=password"), http.StatusBadRequest)
+	requireStatus(t, api.get("/api/departments?page=0"), http.StatusBadRequest)
+	requireStatus(t, api.get("/api/departments?page=9223372036854775807&page_size=500"), http.StatusBadRequest)
+}
+
+func TestUpdateDepartment(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+
+	response := api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/departments/surgery",
+		body:    Department{Name: "General Surgery", Capacity: DepartmentCapacity{MaximumBeds: 5, ActualBeds: 4}},
+		ifMatch: `"2"`,
+	})
+	department := decode[Department](t, response, http.StatusOK)
+	assert.Equal(t, "General Surgery", department.Name)
+	assert.Equal(t, DepartmentCapacity{MaximumBeds: 5, ActualBeds: 1}, department.Capacity)
+	assert.Equal(t, `"3"`, response.Header().Get("ETag"))
+
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/departments/surgery",
+		body:    Department{Name: "Stale"},
+		ifMatch: `"2"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.put("/api/departments/surgery", Department{Capacity: DepartmentCapacity{MaximumBeds: 0}}), http.StatusOK)
+	requireStatus(t, api.put("/api/departments/missing", Department{Name: "Missing"}), http.StatusNotFound)
+	requireStatus(t, api.put("/api/departments/surgery", "{"), http.StatusBadRequest)
+}
+
+func TestUpdateDepartmentBelowActualBeds(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+
+	requireStatus(t, api.put("/api/departments/surgery", Department{Capacity: DepartmentCapacity{MaximumBeds: 1}}), http.StatusConflict)
+	requireStatus(t, api.mergePatch("/api/departments/surgery", map[string]interface{}{
+		"capacity": map[string]interface{}{"maximum_beds": 1},
+	}), http.StatusConflict)
+}
+
+func TestPatchDepartment(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+
+	department := decode[Department](t, api.mergePatch("/api/departments/surgery", map[string]interface{}{
+		"name":     "General Surgery",
+		"capacity": map[string]interface{}{"actual_beds": 99},
+	}), http.StatusOK)
+	assert.Equal(t, "General Surgery", department.Name)
+	assert.Equal(t, 10, department.Capacity.MaximumBeds)
+	// bed counts are maintained by the beds endpoints
+	assert.Equal(t, 0, department.Capacity.ActualBeds)
+
+	department = decode[Department](t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/departments/surgery",
+		body:        `[{"op": "replace", "path": "/floor", "value": 4}]`,
+		contentType: jsonPatchContentType,
+	}), http.StatusOK)
+	assert.Equal(t, 4, department.Floor)
+
+	requireStatus(t, api.do(testRequest{
+		method: http.MethodPatch,
+		path:   "/api/departments/surgery",
+		body:   map[string]interface{}{"floor": 5},
+	}), http.StatusUnsupportedMediaType)
+	requireStatus(t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/departments/surgery",
+		body:        `[{"op": "test", "path": "/floor", "value": 1}]`,
+		contentType: jsonPatchContentType,
+	}), http.StatusConflict)
+	requireStatus(t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/departments/surgery",
+		body:        map[string]interface{}{"floor": 5},
+		contentType: mergePatchContentType,
+		ifMatch:     `"1"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.mergePatch("/api/departments/missing", map[string]interface{}{"floor": 5}), http.StatusNotFound)
+}
+
+func TestDeleteDepartment(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+
+	// restrict keeps the department while it has beds
+	response := api.delete("/api/departments/surgery")
+	body := requireProblem(t, response, http.StatusConflict, codeDepartmentHasBeds)
+	assert.Equal(t, []Blocker{{Entity: "bed", Id: "bed-1", Reason: "bed is located in the department"}}, body.Blockers)
+
+	requireStatus(t, api.delete("/api/beds/bed-1"), http.StatusNoContent)
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodDelete,
+		path:    "/api/departments/surgery",
+		ifMatch: `"1"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.delete("/api/departments/surgery"), http.StatusNoContent)
+	requireStatus(t, api.get("/api/departments/surgery"), http.StatusNotFound)
+	requireStatus(t, api.delete("/api/departments/surgery"), http.StatusNotFound)
+}
+
+func TestDeleteDepartmentCascade(t *testing.T) {
+	api := newTestApiWithPolicy(t, IntegrityPolicy{DepartmentBeds: DeleteCascade, PatientBeds: DeleteRestrict})
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.admit("patient-1", "bed-2")
+
+	// occupied beds block the delete even with cascade
+	body := requireProblem(t, api.delete("/api/departments/surgery"), http.StatusConflict, codeDepartmentHasBeds)
+	require.Len(t, body.Blockers, 1)
+	assert.Equal(t, "bed-2", body.Blockers[0].Id)
+
+	requireStatus(t, api.post("/api/patients/patient-1/discharge", Discharge{Reason: "recovered"}), http.StatusOK)
+	requireStatus(t, api.delete("/api/departments/surgery"), http.StatusNoContent)
+	requireStatus(t, api.get("/api/beds/bed-1"), http.StatusNotFound)
+	requireStatus(t, api.get("/api/beds/bed-2"), http.StatusNotFound)
+}
+
+func TestReconcileDepartmentCapacity(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.admit("patient-1", "bed-1")
+
+	// counts drifted by a write bypassing the api
+	ctx := WithHospital(context.Background(), testHospital)
+	department, err := api.departments.FindDocument(ctx, "surgery")
+	require.NoError(t, err)
+	department.Capacity.ActualBeds = 5
+	department.Capacity.OccupiedBeds = 0
+	require.NoError(t, api.departments.UpdateDocument(ctx, "surgery", department))
+
+	reconciliation := decode[CapacityReconciliation](t, api.post("/api/departments/reconcile?dry_run=true", nil), http.StatusOK)
+	assert.Equal(t, 1, reconciliation.Checked)
+	assert.False(t, reconciliation.Repaired)
+	require.Len(t, reconciliation.Discrepancies, 1)
+	assert.Equal(t, DepartmentCapacity{MaximumBeds: 10, ActualBeds: 1, OccupiedBeds: 1}, reconciliation.Discrepancies[0].Actual)
+	api.requireCapacity("surgery", 5, 0)
+
+	reconciliation = decode[CapacityReconciliation](t, api.post("/api/departments/reconcile", nil), http.StatusOK)
+	assert.True(t, reconciliation.Repaired)
+	require.Len(t, reconciliation.Discrepancies, 1)
+	api.requireCapacity("surgery", 1, 1)
+
+	reconciliation = decode[CapacityReconciliation](t, api.post("/api/departments/reconcile", nil), http.StatusOK)
+	assert.Empty(t, reconciliation.Discrepancies)
+
+	requireStatus(t, api.post("/api/departments/reconcile?dry_run=maybe", nil), http.StatusBadRequest)
+}
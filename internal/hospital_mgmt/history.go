@@ -0,0 +1,252 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+var (
+	errRevisionNotFound = errors.New("revision not found")
+	errRevisionDeleted  = errors.New("revision of a deleted document cannot be restored")
+)
+
+// historyDocument is a versioned document carrying the time of its last write
+type historyDocument[DocType interface{}] interface {
+	versionedDocument[DocType]
+	documentUpdatedAt() time.Time
+}
+
+func (d *Department) documentUpdatedAt() time.Time { return d.UpdatedAt }
+func (b *Bed) documentUpdatedAt() time.Time        { return b.UpdatedAt }
+func (p *Patient) documentUpdatedAt() time.Time    { return p.UpdatedAt }
+
+// history keeps a revision of the document after every write
+type history[DocType interface{}, P historyDocument[DocType]] struct {
+	revisions db_service.DbService[Revision[DocType]]
+}
+
+// NewHistoryService wraps the db service so every write keeps the new state of the document as
+// a revision, in the same transaction as the write. The revisions have to be stored in the same
+// database to share the transactions.
+func NewHistoryService[DocType interface{}, P historyDocument[DocType]](
+	db db_service.DbService[DocType],
+	revisions db_service.DbService[Revision[DocType]],
+) db_service.DbService[DocType] {
+	history := &history[DocType, P]{revisions: revisions}
+	return &recordingSvc[DocType]{DbService: db, record: history.record}
+}
+
+func (m *history[DocType, P]) record(ctx context.Context, id string, before *DocType, after *DocType) error {
+	if before != nil {
+		// documents written before the history was kept get their state before the write
+		// as the first revision
+		recorded, err := hasRevisions(ctx, m.revisions, id)
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			baseline := &Revision[DocType]{
+				EntityId:  id,
+				Version:   P(before).documentVersion(),
+				ValidFrom: P(before).documentUpdatedAt().UTC(),
+				Document:  before,
+			}
+			if err := m.store(ctx, baseline); err != nil {
+				return err
+			}
+		}
+	}
+
+	revision := &Revision[DocType]{
+		EntityId:  id,
+		ValidFrom: time.Now().UTC(),
+		Document:  after,
+	}
+	switch {
+	case after != nil:
+		revision.Version = P(after).documentVersion()
+	case before != nil:
+		revision.Version = P(before).documentVersion()
+		revision.Deleted = true
+	default:
+		revision.Deleted = true
+	}
+	return m.store(ctx, revision)
+}
+
+func (m *history[DocType, P]) store(ctx context.Context, revision *Revision[DocType]) error {
+	revisionId, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	revision.Id = revisionId.String()
+	return m.revisions.CreateDocument(ctx, revision.Id, revision)
+}
+
+func hasRevisions[DocType interface{}](
+	ctx context.Context,
+	revisions db_service.DbService[Revision[DocType]],
+	id string,
+) (bool, error) {
+	page, err := revisions.FindDocumentsPage(ctx, db_service.PageRequest{
+		Filter: map[string]interface{}{"entity_id": id},
+		Limit:  1,
+	})
+	if err != nil {
+		return false, err
+	}
+	return page.TotalCount > 0, nil
+}
+
+// parseAsOf reads the `asOf` query parameter, an RFC 3339 timestamp, ok is false if it is missing
+func parseAsOf(c *gin.Context) (time.Time, bool, error) {
+	value := c.Query("asOf")
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("asOf must be an RFC 3339 timestamp, e.g. 2024-05-01T12:00:00Z")
+	}
+	return asOf, true, nil
+}
+
+// findDocumentAsOf returns the state of the document at the time, db_service.ErrNotFound if it
+// did not exist then
+func findDocumentAsOf[DocType interface{}, P historyDocument[DocType]](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	revisions db_service.DbService[Revision[DocType]],
+	id string,
+	asOf time.Time,
+) (*DocType, error) {
+	page, err := revisions.FindDocumentsPage(ctx, db_service.PageRequest{
+		Filter: map[string]interface{}{
+			"entity_id":  id,
+			"valid_from": map[string]interface{}{"$lte": asOf},
+		},
+		// revision ids are ordered by the time of the write
+		Sort:  []db_service.SortField{{Field: "id", Descending: true}},
+		Limit: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Documents) > 0 {
+		revision := page.Documents[0]
+		if revision.Deleted || revision.Document == nil {
+			return nil, db_service.ErrNotFound
+		}
+		return revision.Document, nil
+	}
+
+	// a document without revisions has not been written since the history is kept,
+	// it is in its current state since its last write
+	current, err := db.FindDocument(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := hasRevisions(ctx, revisions, id)
+	if err != nil {
+		return nil, err
+	}
+	if recorded || P(current).documentUpdatedAt().After(asOf) {
+		return nil, db_service.ErrNotFound
+	}
+	return current, nil
+}
+
+// findRevision returns the document of the revision to restore
+func findRevision[DocType interface{}](
+	ctx context.Context,
+	revisions db_service.DbService[Revision[DocType]],
+	id string,
+	revisionId string,
+) (*DocType, error) {
+	revision, err := revisions.FindDocument(ctx, revisionId)
+	switch {
+	case err == db_service.ErrNotFound || (err == nil && revision.EntityId != id):
+		return nil, errRevisionNotFound
+	case err != nil:
+		return nil, err
+	case revision.Deleted || revision.Document == nil:
+		return nil, errRevisionDeleted
+	}
+	return revision.Document, nil
+}
+
+// respondRevisionError writes 404 for a missing revision and 409 for a revision which cannot
+// be restored, it returns false for other errors
+func respondRevisionError(c *gin.Context, err error) bool {
+	switch err {
+	case errRevisionNotFound:
+		respondProblem(c, http.StatusNotFound, codeRevisionNotFound, "Revision not found")
+	case errRevisionDeleted:
+		respondProblem(c, http.StatusConflict, codeRevisionNotRestorable,
+			"Cannot restore revision, the revision of a deleted document cannot be restored")
+	default:
+		return false
+	}
+	return true
+}
+
+// respondHistory writes the page of revisions of the document, oldest first. A document
+// which does not exist is found only if it has revisions. The documents of the revisions are
+// written as returned by readable, as they are if it is nil.
+func respondHistory[DocType interface{}](
+	c *gin.Context,
+	db db_service.DbService[DocType],
+	revisions db_service.DbService[Revision[DocType]],
+	id string,
+	readable func(c *gin.Context, document *DocType) *DocType,
+	notFoundCode string,
+	notFoundMessage string,
+) {
+	query, err := parsePageQuery(c, nil)
+	if err != nil {
+		respondPageQueryError(c, err)
+		return
+	}
+
+	request := query.request(map[string]interface{}{"entity_id": id})
+	request.Sort = []db_service.SortField{{Field: "id"}}
+	page, err := revisions.FindDocumentsPage(c, request)
+	if err == nil && page.TotalCount == 0 {
+		_, err = db.FindDocument(c, id)
+	}
+
+	switch err {
+	case nil:
+		history := page.Documents
+		if readable != nil {
+			history = make([]*Revision[DocType], len(page.Documents))
+			for i, revision := range page.Documents {
+				readableRevision := *revision
+				if revision.Document != nil {
+					readableRevision.Document = readable(c, revision.Document)
+				}
+				history[i] = &readableRevision
+			}
+		}
+		writePageHeaders(c, query, page.TotalCount)
+		c.JSON(
+			http.StatusOK,
+			history,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, notFoundCode, notFoundMessage)
+	default:
+		respondDatabaseError(c, err, "Failed to retrieve history from database")
+	}
+}
+
+func respondAsOfError(c *gin.Context, err error) {
+	respondProblem(c, http.StatusBadRequest, codeInvalidQuery, "Invalid asOf query parameter, "+err.Error())
+}
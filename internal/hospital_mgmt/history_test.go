@@ -0,0 +1,222 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func historyPath(path string) string {
+	return path + "/history"
+}
+
+func restorePath(path string, revisionId string) string {
+	return path + "/history/" + revisionId + "/restore"
+}
+
+func asOfQuery(asOf time.Time) string {
+	return "?asOf=" + asOf.UTC().Format(time.RFC3339Nano)
+}
+
+// nextMillisecond separates the revisions of the writes before and after it in time,
+// the storages keep timestamps with millisecond precision
+func nextMillisecond() {
+	time.Sleep(time.Millisecond)
+}
+
+func TestPatientHistory(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Novakova")
+	nextMillisecond()
+	requireStatus(t, api.put("/api/patients/patient-1", Patient{FirstName: "Jana", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F"}), http.StatusOK)
+	nextMillisecond()
+	requireStatus(t, api.mergePatch("/api/patients/patient-1", map[string]interface{}{"phone": "+421900111222"}), http.StatusOK)
+	nextMillisecond()
+
+	revisions := decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-1")), http.StatusOK)
+	require.Len(t, revisions, 3)
+	for i, revision := range revisions {
+		assert.Equal(t, "patient-1", revision.EntityId)
+		assert.EqualValues(t, i+1, revision.Version)
+		assert.False(t, revision.Deleted)
+		require.NotNil(t, revision.Document)
+		assert.EqualValues(t, i+1, revision.Document.Version)
+	}
+	assert.Equal(t, "Novakova", revisions[0].Document.LastName)
+	assert.Equal(t, "Kralova", revisions[1].Document.LastName)
+	assert.Equal(t, "+421900111222", revisions[2].Document.Phone)
+
+	response := api.get(historyPath("/api/patients/patient-1") + "?page_size=2&page=2")
+	page := decode[[]PatientRevision](t, response, http.StatusOK)
+	require.Len(t, page, 1)
+	assert.Equal(t, revisions[2].Id, page[0].Id)
+	assert.Equal(t, "3", response.Header().Get("X-Total-Count"))
+
+	response = api.get("/api/patients/patient-1" + asOfQuery(revisions[0].ValidFrom))
+	patient := decode[Patient](t, response, http.StatusOK)
+	assert.Equal(t, "Novakova", patient.LastName)
+	assert.Empty(t, response.Header().Get("ETag"), "past states cannot be written")
+	patient = decode[Patient](t, api.get("/api/patients/patient-1"+asOfQuery(revisions[1].ValidFrom)), http.StatusOK)
+	assert.Equal(t, "Kralova", patient.LastName)
+	assert.Empty(t, patient.Phone)
+	requireStatus(t, api.get("/api/patients/patient-1"+asOfQuery(revisions[0].ValidFrom.Add(-time.Nanosecond))), http.StatusNotFound)
+
+	// the history outlives the patient
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNoContent)
+	revisions = decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-1")), http.StatusOK)
+	require.Len(t, revisions, 4)
+	assert.True(t, revisions[3].Deleted)
+	assert.Nil(t, revisions[3].Document)
+	assert.EqualValues(t, 3, revisions[3].Version)
+	requireStatus(t, api.get("/api/patients/patient-1"+asOfQuery(time.Now())), http.StatusNotFound)
+	patient = decode[Patient](t, api.get("/api/patients/patient-1"+asOfQuery(revisions[2].ValidFrom)), http.StatusOK)
+	assert.Equal(t, "+421900111222", patient.Phone)
+
+	requireStatus(t, api.get(historyPath("/api/patients/missing")), http.StatusNotFound)
+	requireStatus(t, api.get("/api/patients/patient-1?asOf=yesterday"), http.StatusBadRequest)
+	requireStatus(t, api.get(historyPath("/api/patients/patient-1")+"?page=0"), http.StatusBadRequest)
+}
+
+func TestRestorePatientRevision(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	requireStatus(t, api.put("/api/patients/patient-1", Patient{FirstName: "Jana", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F"}), http.StatusOK)
+	api.admit("patient-1", "bed-1")
+
+	revisions := decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-1")), http.StatusOK)
+	require.Len(t, revisions, 3)
+
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodPost,
+		path:    restorePath("/api/patients/patient-1", revisions[0].Id),
+		ifMatch: versionETag(1),
+	}), http.StatusPreconditionFailed)
+
+	response := api.do(testRequest{
+		method:  http.MethodPost,
+		path:    restorePath("/api/patients/patient-1", revisions[0].Id),
+		ifMatch: versionETag(3),
+	})
+	patient := decode[Patient](t, response, http.StatusOK)
+	assert.Equal(t, "Novakova", patient.LastName)
+	assert.EqualValues(t, 4, patient.Version)
+	assert.Equal(t, versionETag(4), response.Header().Get("ETag"))
+	assert.Len(t, patient.HospitalizationRecords, 1, "records are kept by the restore")
+	assert.Equal(t, revisions[0].Document.CreatedAt.UTC(), patient.CreatedAt.UTC())
+
+	stored := decode[Patient](t, api.get("/api/patients/patient-1"), http.StatusOK)
+	assert.Equal(t, "Novakova", stored.LastName)
+	found := decode[[]Patient](t, api.get("/api/patients/search?q=novakova"), http.StatusOK)
+	assert.Equal(t, []string{"patient-1"}, patientIds(found), "search terms follow the restored name")
+
+	revisions = decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-1")), http.StatusOK)
+	require.Len(t, revisions, 4)
+	assert.Equal(t, "Novakova", revisions[3].Document.LastName)
+
+	patient2 := decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-2")), http.StatusOK)
+	requireStatus(t, api.post(restorePath("/api/patients/patient-1", patient2[0].Id), nil), http.StatusNotFound)
+	requireStatus(t, api.post(restorePath("/api/patients/patient-1", "missing"), nil), http.StatusNotFound)
+
+	// the revision of a deletion has no document to restore
+	requireStatus(t, api.delete("/api/patients/patient-2"), http.StatusNoContent)
+	patient2 = decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-2")), http.StatusOK)
+	require.Len(t, patient2, 2)
+	require.True(t, patient2[1].Deleted)
+	requireStatus(t, api.post(restorePath("/api/patients/patient-2", patient2[1].Id), nil), http.StatusConflict)
+}
+
+func TestRestoreBedRevision(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("icu", 10)
+	bed := api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	nextMillisecond()
+
+	bed.DepartmentId = "icu"
+	bed.BedType = "intensive"
+	requireStatus(t, api.put("/api/beds/bed-1", bed), http.StatusOK)
+	api.requireCapacity("icu", 1, 0)
+
+	revisions := decode[[]BedRevision](t, api.get(historyPath("/api/beds/bed-1")), http.StatusOK)
+	require.Len(t, revisions, 2)
+	past := decode[Bed](t, api.get("/api/beds/bed-1"+asOfQuery(revisions[0].ValidFrom)), http.StatusOK)
+	assert.Equal(t, "surgery", past.DepartmentId)
+
+	// capacities of the departments follow the restored bed
+	restored := decode[Bed](t, api.post(restorePath("/api/beds/bed-1", revisions[0].Id), nil), http.StatusOK)
+	assert.Equal(t, "surgery", restored.DepartmentId)
+	assert.Equal(t, "standard", restored.BedType)
+	assert.EqualValues(t, 3, restored.Version)
+	api.requireCapacity("surgery", 1, 0)
+	api.requireCapacity("icu", 0, 0)
+
+	// the occupant is kept, the occupied bed cannot move
+	api.admit("patient-1", "bed-1")
+	requireStatus(t, api.post(restorePath("/api/beds/bed-1", revisions[1].Id), nil), http.StatusConflict)
+	restored = decode[Bed](t, api.post(restorePath("/api/beds/bed-1", revisions[0].Id), nil), http.StatusOK)
+	assert.Equal(t, BedStatus{PatientId: "patient-1", Description: "Occupied"}, restored.Status)
+	api.requireCapacity("surgery", 1, 1)
+
+	requireStatus(t, api.get(historyPath("/api/beds/missing")), http.StatusNotFound)
+	requireStatus(t, api.post(restorePath("/api/beds/bed-1", "missing"), nil), http.StatusNotFound)
+	requireStatus(t, api.get("/api/beds/bed-1?asOf=2024-13-01"), http.StatusBadRequest)
+}
+
+func TestRestoreDepartmentRevision(t *testing.T) {
+	api := newTestApi(t)
+	department := api.createDepartment("surgery", 10)
+	nextMillisecond()
+	api.createBed("bed-1", "surgery")
+
+	department.Name = "Cardiac surgery"
+	department.Capacity.MaximumBeds = 20
+	requireStatus(t, api.put("/api/departments/surgery", department), http.StatusOK)
+
+	revisions := decode[[]DepartmentRevision](t, api.get(historyPath("/api/departments/surgery")), http.StatusOK)
+	require.Len(t, revisions, 3, "adding the bed updates the department")
+	assert.Equal(t, 0, revisions[0].Document.Capacity.ActualBeds)
+	assert.Equal(t, 1, revisions[1].Document.Capacity.ActualBeds)
+	past := decode[Department](t, api.get("/api/departments/surgery"+asOfQuery(revisions[0].ValidFrom)), http.StatusOK)
+	assert.Equal(t, "Department surgery", past.Name)
+
+	restored := decode[Department](t, api.post(restorePath("/api/departments/surgery", revisions[0].Id), nil), http.StatusOK)
+	assert.Equal(t, "Department surgery", restored.Name)
+	assert.Equal(t, 10, restored.Capacity.MaximumBeds)
+	assert.Equal(t, 1, restored.Capacity.ActualBeds, "bed counts are kept by the restore")
+	api.requireCapacity("surgery", 1, 0)
+
+	requireStatus(t, api.get(historyPath("/api/departments/missing")), http.StatusNotFound)
+	requireStatus(t, api.post(restorePath("/api/departments/missing", revisions[0].Id), nil), http.StatusNotFound)
+}
+
+// documents written before the history was kept have no revisions until their next write
+func TestHistoryOfDocumentsWithoutRevisions(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	created := decode[Department](t, api.get("/api/departments/surgery"), http.StatusOK)
+	revisions := decode[[]DepartmentRevision](t, api.get(historyPath("/api/departments/surgery")), http.StatusOK)
+	require.Len(t, revisions, 1)
+	require.NoError(t, api.departmentRevisions.DeleteDocument(WithHospital(t.Context(), testHospital), revisions[0].Id))
+
+	assert.Empty(t, decode[[]DepartmentRevision](t, api.get(historyPath("/api/departments/surgery")), http.StatusOK))
+	current := decode[Department](t, api.get("/api/departments/surgery"+asOfQuery(created.UpdatedAt)), http.StatusOK)
+	assert.Equal(t, "Department surgery", current.Name)
+	requireStatus(t, api.get("/api/departments/surgery"+asOfQuery(created.UpdatedAt.Add(-time.Nanosecond))), http.StatusNotFound)
+
+	created.Name = "Cardiac surgery"
+	requireStatus(t, api.put("/api/departments/surgery", created), http.StatusOK)
+	revisions = decode[[]DepartmentRevision](t, api.get(historyPath("/api/departments/surgery")), http.StatusOK)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "Department surgery", revisions[0].Document.Name, "the state before the write is kept")
+	assert.EqualValues(t, 1, revisions[0].Version)
+	assert.True(t, revisions[0].ValidFrom.Equal(created.UpdatedAt))
+	assert.Equal(t, "Cardiac surgery", revisions[1].Document.Name)
+	assert.EqualValues(t, 2, revisions[1].Version)
+}
@@ -0,0 +1,191 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmitPatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+
+	record := api.admit("patient-1", "bed-1")
+	assert.Equal(t, "bed-1", record.BedId)
+	assert.Equal(t, "surgery", record.DepartmentId)
+	assert.NotNil(t, record.AdmittedAt)
+	assert.Nil(t, record.DischargedAt)
+
+	bed := decode[Bed](t, api.get("/api/beds/bed-1"), http.StatusOK)
+	assert.Equal(t, "patient-1", bed.Status.PatientId)
+	api.requireCapacity("surgery", 2, 1)
+
+	for _, tc := range []struct {
+		name      string
+		patientId string
+		admission interface{}
+		status    int
+	}{
+		{"already admitted", "patient-1", Admission{BedId: "bed-2"}, http.StatusConflict},
+		{"bed occupied", "patient-2", Admission{BedId: "bed-1"}, http.StatusConflict},
+		{"missing bed", "patient-2", Admission{BedId: "missing"}, http.StatusNotFound},
+		{"missing patient", "missing", Admission{BedId: "bed-2"}, http.StatusNotFound},
+		{"no bed", "patient-2", Admission{}, http.StatusBadRequest},
+		{"invalid body", "patient-2", "{", http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requireStatus(t, api.post("/api/patients/"+tc.patientId+"/admissions", tc.admission), tc.status)
+		})
+	}
+	api.requireCapacity("surgery", 2, 1)
+}
+
+func TestDischargePatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	admitted := api.admit("patient-1", "bed-1")
+
+	record := decode[HospitalizationRecord](t, api.post("/api/patients/patient-1/discharge", Discharge{Reason: "recovered"}), http.StatusOK)
+	assert.Equal(t, admitted.Id, record.Id)
+	assert.Equal(t, "recovered", record.DischargeReason)
+	require.NotNil(t, record.DischargedAt)
+
+	bed := decode[Bed](t, api.get("/api/beds/bed-1"), http.StatusOK)
+	assert.Empty(t, bed.Status.PatientId)
+	api.requireCapacity("surgery", 1, 0)
+
+	requireStatus(t, api.post("/api/patients/patient-1/discharge", Discharge{}), http.StatusConflict)
+	requireStatus(t, api.post("/api/patients/missing/discharge", Discharge{}), http.StatusNotFound)
+	requireStatus(t, api.post("/api/patients/patient-1/discharge", "{"), http.StatusBadRequest)
+	api.requireCapacity("surgery", 1, 0)
+
+	// the bed can be taken again
+	api.admit("patient-1", "bed-1")
+	api.requireCapacity("surgery", 1, 1)
+}
+
+func TestDischargePatientFromDeletedBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.admit("patient-1", "bed-1")
+
+	// the bed is gone, the occupancy left with it
+	ctx := WithHospital(t.Context(), testHospital)
+	bed, err := api.beds.FindDocument(ctx, "bed-1")
+	require.NoError(t, err)
+	require.NoError(t, api.beds.DeleteDocument(ctx, bed.Id))
+	department, err := api.departments.FindDocument(ctx, "surgery")
+	require.NoError(t, err)
+	department.Capacity = DepartmentCapacity{MaximumBeds: 10}
+	require.NoError(t, api.departments.UpdateDocument(ctx, department.Id, department))
+
+	requireStatus(t, api.post("/api/patients/patient-1/discharge", Discharge{}), http.StatusOK)
+	api.requireCapacity("surgery", 0, 0)
+}
+
+func TestTransferPatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("pediatrics", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	api.createBed("bed-3", "pediatrics")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	admitted := api.admit("patient-1", "bed-1")
+	api.admit("patient-2", "bed-2")
+
+	transfer := decode[BedTransfer](t, api.post("/api/patients/patient-1/transfers", Transfer{
+		ToBedId: "bed-3",
+		Reason:  "pediatric care",
+	}), http.StatusCreated)
+	assert.Equal(t, admitted.Id, transfer.HospitalizationId)
+	assert.Equal(t, "bed-1", transfer.FromBedId)
+	assert.Equal(t, "surgery", transfer.FromDepartmentId)
+	assert.Equal(t, "bed-3", transfer.ToBedId)
+	assert.Equal(t, "pediatrics", transfer.ToDepartmentId)
+	api.requireCapacity("surgery", 2, 1)
+	api.requireCapacity("pediatrics", 1, 1)
+
+	// back within the same department keeps its occupancy
+	decode[BedTransfer](t, api.post("/api/patients/patient-1/transfers", Transfer{ToBedId: "bed-1"}), http.StatusCreated)
+	api.requireCapacity("surgery", 2, 2)
+	api.requireCapacity("pediatrics", 1, 0)
+
+	for _, tc := range []struct {
+		name      string
+		patientId string
+		transfer  interface{}
+		status    int
+	}{
+		{"same bed", "patient-1", Transfer{ToBedId: "bed-1"}, http.StatusConflict},
+		{"bed occupied", "patient-1", Transfer{ToBedId: "bed-2"}, http.StatusConflict},
+		{"missing bed", "patient-1", Transfer{ToBedId: "missing"}, http.StatusNotFound},
+		{"missing patient", "missing", Transfer{ToBedId: "bed-3"}, http.StatusNotFound},
+		{"no bed", "patient-1", Transfer{}, http.StatusBadRequest},
+		{"invalid body", "patient-1", "{", http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requireStatus(t, api.post("/api/patients/"+tc.patientId+"/transfers", tc.transfer), tc.status)
+		})
+	}
+
+	requireStatus(t, api.post("/api/patients/patient-2/discharge", Discharge{}), http.StatusOK)
+	requireStatus(t, api.post("/api/patients/patient-2/transfers", Transfer{ToBedId: "bed-3"}), http.StatusConflict)
+	api.requireCapacity("surgery", 2, 1)
+	api.requireCapacity("pediatrics", 1, 0)
+}
+
+func TestGetPatientTransfers(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+
+	transfers := decode[[]BedTransfer](t, api.get("/api/patients/patient-1/transfers"), http.StatusOK)
+	assert.Empty(t, transfers)
+
+	api.admit("patient-1", "bed-1")
+	requireStatus(t, api.post("/api/patients/patient-1/transfers", Transfer{ToBedId: "bed-2"}), http.StatusCreated)
+	requireStatus(t, api.post("/api/patients/patient-1/discharge", Discharge{}), http.StatusOK)
+	api.admit("patient-1", "bed-2")
+	requireStatus(t, api.post("/api/patients/patient-1/transfers", Transfer{ToBedId: "bed-1"}), http.StatusCreated)
+
+	transfers = decode[[]BedTransfer](t, api.get("/api/patients/patient-1/transfers"), http.StatusOK)
+	require.Len(t, transfers, 2)
+	assert.Equal(t, "bed-2", transfers[0].ToBedId)
+	assert.Equal(t, "bed-1", transfers[1].ToBedId)
+
+	requireStatus(t, api.get("/api/patients/missing/transfers"), http.StatusNotFound)
+}
+
+// the stored counts agree with the beds after every admission workflow
+func TestAdmissionWorkflowsKeepCapacity(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createDepartment("pediatrics", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "pediatrics")
+	api.createPatient("patient-1", "Jana", "Novakova")
+
+	api.admit("patient-1", "bed-1")
+	requireStatus(t, api.post("/api/patients/patient-1/transfers", Transfer{ToBedId: "bed-2"}), http.StatusCreated)
+	requireStatus(t, api.mergePatch("/api/beds/bed-1", map[string]interface{}{"department_id": "pediatrics"}), http.StatusOK)
+	requireStatus(t, api.post("/api/patients/patient-1/discharge", Discharge{}), http.StatusOK)
+
+	reconciliation := decode[CapacityReconciliation](t, api.post("/api/departments/reconcile?dry_run=true", nil), http.StatusOK)
+	assert.Empty(t, reconciliation.Discrepancies)
+	api.requireCapacity("surgery", 0, 0)
+	api.requireCapacity("pediatrics", 2, 0)
+}
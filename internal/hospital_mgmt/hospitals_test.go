@@ -0,0 +1,99 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (api *testApi) createHospital(id string) Hospital {
+	api.t.Helper()
+	return decode[Hospital](api.t, api.post("/api/hospitals", Hospital{
+		Id:   id,
+		Name: "Hospital " + id,
+	}), http.StatusCreated)
+}
+
+func TestHospitals(t *testing.T) {
+	api := newTestApi(t)
+
+	response := api.post("/api/hospitals", Hospital{Id: "hospital-2", Name: "Nemocnica Ružinov", Address: "Ružinovská 6"})
+	created := decode[Hospital](t, response, http.StatusCreated)
+	assert.Equal(t, "hospital-2", created.Id)
+	assert.EqualValues(t, 1, created.Version)
+	assert.False(t, created.CreatedAt.IsZero())
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+
+	generated := decode[Hospital](t, api.post("/api/hospitals", Hospital{Name: "Nemocnica Petržalka"}), http.StatusCreated)
+	assert.NotEmpty(t, generated.Id)
+
+	requireStatus(t, api.post("/api/hospitals", Hospital{Id: "hospital-2", Name: "Duplicate"}), http.StatusConflict)
+	requireStatus(t, api.post("/api/hospitals", Hospital{Id: "hospital-3"}), http.StatusBadRequest)
+	requireStatus(t, api.post("/api/hospitals", Hospital{Id: "a/b", Name: "Slash"}), http.StatusBadRequest)
+
+	found := decode[Hospital](t, api.get("/api/hospitals/hospital-2"), http.StatusOK)
+	assert.Equal(t, "Ružinovská 6", found.Address)
+	requireStatus(t, api.get("/api/hospitals/unknown"), http.StatusNotFound)
+
+	response = api.get("/api/hospitals?sort=name&page_size=2")
+	listed := decode[[]Hospital](t, response, http.StatusOK)
+	assert.Equal(t, "3", response.Header().Get("X-Total-Count"))
+	require.Len(t, listed, 2)
+	assert.Equal(t, []string{generated.Id, "hospital-2"}, []string{listed[0].Id, listed[1].Id})
+	requireStatus(t, api.get("/api/hospitals?sort=address"), http.StatusBadRequest)
+
+	updated := decode[Hospital](t, api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/hospitals/hospital-2",
+		body:    Hospital{Id: "ignored", Name: "Nemocnica Ružinov", Address: "Ružinovská 4810/6"},
+		ifMatch: `"1"`,
+	}), http.StatusOK)
+	assert.Equal(t, "hospital-2", updated.Id)
+	assert.EqualValues(t, 2, updated.Version)
+	assert.Equal(t, created.CreatedAt.Unix(), updated.CreatedAt.Unix())
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/hospitals/hospital-2",
+		body:    Hospital{Name: "Stale"},
+		ifMatch: `"1"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.put("/api/hospitals/hospital-2", Hospital{}), http.StatusBadRequest)
+	requireStatus(t, api.put("/api/hospitals/unknown", Hospital{Name: "Unknown"}), http.StatusNotFound)
+
+	requireStatus(t, api.delete("/api/hospitals/"+generated.Id), http.StatusNoContent)
+	requireStatus(t, api.get("/api/hospitals/"+generated.Id), http.StatusNotFound)
+	requireStatus(t, api.delete("/api/hospitals/"+generated.Id), http.StatusNotFound)
+}
+
+// hospitals with documents cannot be deleted, not even with documents marked as deleted
+func TestDeleteHospitalWithDocuments(t *testing.T) {
+	api := newTestApi(t)
+	api.createHospital("hospital-2")
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.do(testRequest{
+		method:   http.MethodPost,
+		path:     "/api/patients",
+		body:     Patient{Id: "patient-2", FirstName: "Eva", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F"},
+		hospital: "hospital-2",
+	})
+
+	body := requireProblem(t, api.delete("/api/hospitals/"+testHospital), http.StatusConflict, codeHospitalHasDocuments)
+	assert.Equal(t, []Blocker{
+		{Entity: "department", Id: "surgery", Reason: "belongs to the hospital with 1 department documents"},
+		{Entity: "bed", Id: "bed-1", Reason: "belongs to the hospital with 1 bed documents"},
+	}, body.Blockers)
+
+	requireStatus(t, api.do(testRequest{
+		method:   http.MethodDelete,
+		path:     "/api/patients/patient-2",
+		hospital: "hospital-2",
+	}), http.StatusNoContent)
+	body = requireProblem(t, api.delete("/api/hospitals/hospital-2"), http.StatusConflict, codeHospitalHasDocuments)
+	assert.Equal(t, []Blocker{
+		{Entity: "patient", Id: "patient-2", Reason: "belongs to the hospital with 1 patient documents"},
+	}, body.Blockers)
+	requireStatus(t, api.get("/api/hospitals/hospital-2"), http.StatusOK)
+}
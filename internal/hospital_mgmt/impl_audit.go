@@ -0,0 +1,77 @@
+package hospital_mgmt
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+var auditSortFields = []string{"id", "timestamp", "entity", "entity_id", "action", "actor"}
+
+type implAuditAPI struct {
+	audit db_service.DbService[AuditEntry]
+}
+
+// NewAuditAPI creates the audit api reading the audit log
+func NewAuditAPI(audit db_service.DbService[AuditEntry]) AuditAPI {
+	return &implAuditAPI{audit: audit}
+}
+
+// parseAuditFilter builds the db filter of the audit log from the `entity`, `id` and `actor`
+// query parameters, the entries are listed in the order of the writes unless sorted otherwise
+func parseAuditFilter(c *gin.Context) (map[string]interface{}, error) {
+	filter := map[string]interface{}{}
+
+	if value := c.Query("entity"); value != "" {
+		if !slices.Contains(auditEntities, value) {
+			return nil, fmt.Errorf("entity must be one of: %s", strings.Join(auditEntities, ", "))
+		}
+		filter["entity"] = value
+	}
+
+	if value := c.Query("id"); value != "" {
+		filter["entity_id"] = value
+	}
+
+	if value := c.Query("actor"); value != "" {
+		filter["actor"] = value
+	}
+
+	return filter, nil
+}
+
+func (o *implAuditAPI) GetAuditEntries(c *gin.Context) {
+	query, err := parsePageQuery(c, auditSortFields)
+	if err != nil {
+		respondPageQueryError(c, err)
+		return
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		respondPageQueryError(c, err)
+		return
+	}
+
+	request := query.request(filter)
+	if len(request.Sort) == 0 {
+		// entry ids are ordered by the time of the write
+		request.Sort = []db_service.SortField{{Field: "id"}}
+	}
+
+	page, err := o.audit.FindDocumentsPage(c, request)
+	switch err {
+	case nil:
+		writePageHeaders(c, query, page.TotalCount)
+		c.JSON(
+			http.StatusOK,
+			page.Documents,
+		)
+	default:
+		respondDatabaseError(c, err, "Failed to retrieve audit entries from database")
+	}
+}
@@ -1,6 +1,7 @@
 package hospital_mgmt
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -10,47 +11,33 @@ import (
 )
 
 type implBedsAPI struct {
+	beds        db_service.DbService[Bed]
+	departments db_service.DbService[Department]
+	patients    db_service.DbService[Patient]
+	revisions   db_service.DbService[BedRevision]
 }
 
-func NewBedsAPI() BedsAPI {
-	return &implBedsAPI{}
+// NewBedsAPI creates the beds api, the capacities of the departments and the references to the
+// patients are maintained together with the beds
+func NewBedsAPI(
+	beds db_service.DbService[Bed],
+	departments db_service.DbService[Department],
+	patients db_service.DbService[Patient],
+	revisions db_service.DbService[BedRevision],
+) BedsAPI {
+	return &implBedsAPI{
+		beds:        beds,
+		departments: departments,
+		patients:    patients,
+		revisions:   revisions,
+	}
 }
 
 func (o *implBedsAPI) CreateBed(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
-	}
-
-	db, ok := value.(db_service.DbService[Bed])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
-		return
-	}
-
 	bed := Bed{}
-	err := c.BindJSON(&bed)
+	err := c.ShouldBindJSON(&bed)
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		respondInvalidBody(c, err)
 		return
 	}
 
@@ -61,329 +48,395 @@ func (o *implBedsAPI) CreateBed(c *gin.Context) {
 	now := time.Now()
 	bed.CreatedAt = now
 	bed.UpdatedAt = now
+	bed.DeletedAt = nil
+	bed.HospitalId = c.GetString(hospitalIdKey)
+	bed.Version = 1
+
+	// the bed is counted into the capacity of its department
+	err = o.beds.WithTransaction(c, func(ctx context.Context) error {
+		if err := checkBedOccupancyUnchanged(nil, &bed); err != nil {
+			return err
+		}
+		if err := validateBedReferences(ctx, o.departments, o.patients, &bed); err != nil {
+			return err
+		}
+		if err := o.beds.CreateDocument(ctx, bed.Id, &bed); err != nil {
+			return err
+		}
+		return addBedToCapacity(ctx, o.departments, &bed)
+	})
 
-	err = db.CreateDocument(c, bed.Id, &bed)
-
+	if respondIntegrityError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
+		setETag(c, bed.Version)
 		c.JSON(
 			http.StatusCreated,
 			bed,
 		)
 	case db_service.ErrConflict:
-		c.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "Bed already exists",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusConflict, codeBedExists, "Bed already exists")
+	case errDepartmentFull:
+		respondProblem(c, http.StatusConflict, codeDepartmentFull,
+			"Cannot add bed to department, the department has reached its maximum number of beds")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create bed in database",
-				"error":   err.Error(),
-			},
-		)
+		respondDatabaseError(c, err, "Failed to create bed in database")
 	}
 }
 
 func (o *implBedsAPI) GetBed(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	asOf, historic, err := parseAsOf(c)
+	if err != nil {
+		respondAsOfError(c, err)
 		return
 	}
-
-	db, ok := value.(db_service.DbService[Bed])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	ctx, err := parseIncludeDeleted(c)
+	if err != nil {
+		respondIncludeDeletedError(c, err)
 		return
 	}
 
 	bedId := c.Param("bedId")
-	bed, err := db.FindDocument(c, bedId)
+	var bed *Bed
+	if historic {
+		bed, err = findDocumentAsOf(ctx, o.beds, o.revisions, bedId, asOf)
+	} else {
+		bed, err = o.beds.FindDocument(ctx, bedId)
+	}
 
 	switch err {
 	case nil:
+		// past states cannot be written, they carry no version to match
+		if !historic {
+			setETag(c, bed.Version)
+		}
 		c.JSON(
 			http.StatusOK,
 			bed,
 		)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Bed not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codeBedNotFound, "Bed not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to find bed in database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to find bed in database")
 	}
 }
 
 func (o *implBedsAPI) GetBeds(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	query, err := parsePageQuery(c, bedSortFields)
+	if err != nil {
+		respondPageQueryError(c, err)
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Bed])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	ctx, err := parseIncludeDeleted(c)
+	if err != nil {
+		respondIncludeDeletedError(c, err)
+		return
+	}
+
+	filter, err := parseBedFilter(c)
+	if err != nil {
+		respondPageQueryError(c, err)
 		return
 	}
 
-	beds, err := db.FindAllDocuments(c)
+	page, err := o.beds.FindDocumentsPage(ctx, query.request(filter))
 	switch err {
 	case nil:
+		writePageHeaders(c, query, page.TotalCount)
 		c.JSON(
 			http.StatusOK,
-			beds,
+			page.Documents,
 		)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to retrieve beds from database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to retrieve beds from database")
 	}
 }
 
 func (o *implBedsAPI) GetBedsByDepartment(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	query, err := parsePageQuery(c, bedSortFields)
+	if err != nil {
+		respondPageQueryError(c, err)
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Bed])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	ctx, err := parseIncludeDeleted(c)
+	if err != nil {
+		respondIncludeDeletedError(c, err)
 		return
 	}
 
-	departmentId := c.Param("departmentId")
-	filter := map[string]interface{}{
-		"departmentid": departmentId,
+	filter, err := parseBedFilter(c)
+	if err != nil {
+		respondPageQueryError(c, err)
+		return
 	}
 
-	beds, err := db.FindDocumentsByFilter(c, filter)
+	// department of the path takes precedence over the query
+	filter["department_id"] = c.Param("departmentId")
+
+	page, err := o.beds.FindDocumentsPage(ctx, query.request(filter))
 	switch err {
 	case nil:
-		if beds == nil {
-			beds = []*Bed{}
-		}
+		writePageHeaders(c, query, page.TotalCount)
 		c.JSON(
 			http.StatusOK,
-			beds,
+			page.Documents,
 		)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to retrieve beds by department from database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to retrieve beds by department from database")
 	}
 }
 
 func (o *implBedsAPI) UpdateBed(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	updatedBed := Bed{}
+	err := c.ShouldBindJSON(&updatedBed)
+	if err != nil {
+		respondInvalidBody(c, err)
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Bed])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	o.replaceBed(c, c.Param("bedId"), func(existingBed *Bed) Bed {
+		return updatedBed
+	})
+}
+
+// replaceBed replaces the bed with the one built from the existing bed keeping the fields
+// maintained by the service, and writes the response
+func (o *implBedsAPI) replaceBed(c *gin.Context, bedId string, replacement func(existingBed *Bed) Bed) {
+	precondition := parseIfMatch(c)
+	var updatedBed Bed
+
+	// bed and capacities of the affected departments are updated together
+	err := o.beds.WithTransaction(c, func(ctx context.Context) error {
+		existingBed, err := o.beds.FindDocument(ctx, bedId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(existingBed.Version); err != nil {
+			return err
+		}
+
+		// Preserve certain fields
+		updatedBed = replacement(existingBed)
+		updatedBed.Id = bedId
+		updatedBed.Version = existingBed.Version
+		updatedBed.CreatedAt = existingBed.CreatedAt
+		updatedBed.UpdatedAt = time.Now()
+		updatedBed.DeletedAt = existingBed.DeletedAt
+		updatedBed.HospitalId = existingBed.HospitalId
+
+		if err := checkBedOccupancyUnchanged(existingBed, &updatedBed); err != nil {
+			return err
+		}
+		if err := validateBedReferences(ctx, o.departments, o.patients, &updatedBed); err != nil {
+			return err
+		}
+		if err := updateBedCapacity(ctx, o.departments, existingBed, &updatedBed); err != nil {
+			return err
+		}
+		return saveDocument(ctx, o.beds, &updatedBed)
+	})
+
+	if respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
 		return
 	}
+	switch err {
+	case nil:
+		setETag(c, updatedBed.Version)
+		c.JSON(
+			http.StatusOK,
+			updatedBed,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeBedNotFound, "Bed not found")
+	case errDepartmentFull:
+		respondProblem(c, http.StatusConflict, codeDepartmentFull,
+			"Cannot move bed to department, the department has reached its maximum number of beds")
+	default:
+		respondDatabaseError(c, err, "Failed to update bed in database")
+	}
+}
 
+func (o *implBedsAPI) RestoreBed(c *gin.Context) {
 	bedId := c.Param("bedId")
+	precondition := parseIfMatch(c)
+
+	var bed *Bed
+	err := o.beds.WithTransaction(c, func(ctx context.Context) error {
+		var err error
+		bed, err = findDeletedDocument(ctx, o.beds, bedId, precondition)
+		if err != nil {
+			return err
+		}
 
-	// First check if bed exists
-	existingBed, err := db.FindDocument(c, bedId)
-	if err != nil {
-		switch err {
-		case db_service.ErrNotFound:
-			c.JSON(
-				http.StatusNotFound,
-				gin.H{
-					"status":  "Not Found",
-					"message": "Bed not found",
-					"error":   err.Error(),
-				},
-			)
-		default:
-			c.JSON(
-				http.StatusBadGateway,
-				gin.H{
-					"status":  "Bad Gateway",
-					"message": "Failed to find bed in database",
-					"error":   err.Error(),
-				})
+		// the bed returns to its department, which has to exist and have room for it
+		if err := validateBedReferences(ctx, o.departments, o.patients, bed); err != nil {
+			return err
+		}
+		if err := addBedToCapacity(ctx, o.departments, bed); err != nil {
+			return err
 		}
+
+		bed.DeletedAt = nil
+		bed.UpdatedAt = time.Now()
+		return saveRestoredDocument(ctx, o.beds, bed)
+	})
+
+	if respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
 		return
 	}
+	switch err {
+	case nil:
+		setETag(c, bed.Version)
+		c.JSON(
+			http.StatusOK,
+			bed,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeBedNotFound, "Bed not found")
+	case errNotDeleted:
+		respondProblem(c, http.StatusConflict, codeNotDeleted, "Bed is not deleted")
+	case errDepartmentFull:
+		respondProblem(c, http.StatusConflict, codeDepartmentFull,
+			"Cannot return bed to department, the department has reached its maximum number of beds")
+	default:
+		respondDatabaseError(c, err, "Failed to restore bed in database")
+	}
+}
 
-	updatedBed := Bed{}
-	err = c.BindJSON(&updatedBed)
+func (o *implBedsAPI) GetBedHistory(c *gin.Context) {
+	respondHistory(c, o.beds, o.revisions, c.Param("bedId"), nil, codeBedNotFound, "Bed not found")
+}
+
+func (o *implBedsAPI) RestoreBedRevision(c *gin.Context) {
+	bedId := c.Param("bedId")
+	restoredBed, err := findRevision(c, o.revisions, bedId, c.Param("revisionId"))
+	if respondRevisionError(c, err) {
+		return
+	}
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to find revision in database")
+		return
+	}
+
+	o.replaceBed(c, bedId, func(existingBed *Bed) Bed {
+		// occupancy is managed by the admission endpoints, the bed keeps its current occupant
+		restored := *restoredBed
+		restored.Status = existingBed.Status
+		return restored
+	})
+}
+
+func (o *implBedsAPI) PatchBed(c *gin.Context) {
+	patch, err := readDocumentPatch(c)
+	if respondPatchError(c, err) {
 		return
 	}
 
-	// Preserve certain fields
-	updatedBed.Id = bedId
-	updatedBed.CreatedAt = existingBed.CreatedAt
-	updatedBed.UpdatedAt = time.Now()
+	bedId := c.Param("bedId")
+	precondition := parseIfMatch(c)
+	statusOnly := !hasPermission(c, permissionBedsWrite)
+
+	// same rules as for the full update, the bed moves between department capacities
+	var patchedBed *Bed
+	err = o.beds.WithTransaction(c, func(ctx context.Context) error {
+		existingBed, err := o.beds.FindDocument(ctx, bedId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(existingBed.Version); err != nil {
+			return err
+		}
+
+		patchedBed, err = applyDocumentPatch(patch, existingBed)
+		if err != nil {
+			return err
+		}
 
-	err = db.UpdateDocument(c, bedId, &updatedBed)
+		// Preserve certain fields
+		patchedBed.Id = bedId
+		patchedBed.CreatedAt = existingBed.CreatedAt
+		patchedBed.UpdatedAt = time.Now()
+		patchedBed.DeletedAt = existingBed.DeletedAt
+		patchedBed.HospitalId = existingBed.HospitalId
+
+		if statusOnly {
+			if err := checkOnlyBedStatusChanged(existingBed, patchedBed); err != nil {
+				return err
+			}
+		}
+		if err := checkBedOccupancyUnchanged(existingBed, patchedBed); err != nil {
+			return err
+		}
+		if err := validateBedReferences(ctx, o.departments, o.patients, patchedBed); err != nil {
+			return err
+		}
+		if err := updateBedCapacity(ctx, o.departments, existingBed, patchedBed); err != nil {
+			return err
+		}
+		return saveChangedFields(ctx, o.beds, existingBed, patchedBed)
+	})
 
+	if respondPatchError(c, err) || respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
+		setETag(c, patchedBed.Version)
 		c.JSON(
 			http.StatusOK,
-			updatedBed,
+			patchedBed,
 		)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Bed not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codeBedNotFound, "Bed not found")
+	case errDepartmentFull:
+		respondProblem(c, http.StatusConflict, codeDepartmentFull,
+			"Cannot move bed to department, the department has reached its maximum number of beds")
+	case errBedStatusOnly:
+		respondProblem(c, http.StatusForbidden, codeBedStatusOnly,
+			"Only the status of the bed can be changed without the \"beds:write\" permission")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update bed in database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to patch bed in database")
 	}
 }
 
 func (o *implBedsAPI) DeleteBed(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
-	}
+	bedId := c.Param("bedId")
+	precondition := parseIfMatch(c)
+	err := o.beds.WithTransaction(c, func(ctx context.Context) error {
+		bed, err := o.beds.FindDocument(ctx, bedId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(bed.Version); err != nil {
+			return err
+		}
+		// the patient has to be discharged or transferred first
+		if bed.isOccupied() {
+			return &integrityError{
+				code:     codeBedOccupied,
+				message:  "bed is occupied",
+				blockers: []Blocker{{Entity: "patient", Id: bed.Status.PatientId, Reason: "patient occupies the bed"}},
+			}
+		}
+		if err := deleteDocument(ctx, o.beds, bed); err != nil {
+			return err
+		}
+		return removeBedFromCapacity(ctx, o.departments, bed)
+	})
 
-	db, ok := value.(db_service.DbService[Bed])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	if respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
 		return
 	}
-
-	bedId := c.Param("bedId")
-	err := db.DeleteDocument(c, bedId)
-
 	switch err {
 	case nil:
 		c.AbortWithStatus(http.StatusNoContent)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Bed not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codeBedNotFound, "Bed not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete bed from database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to delete bed from database")
 	}
 } 
\ No newline at end of file
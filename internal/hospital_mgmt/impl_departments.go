@@ -1,7 +1,9 @@
 package hospital_mgmt
 
 import (
+	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,47 +12,33 @@ import (
 )
 
 type implDepartmentsAPI struct {
+	departments db_service.DbService[Department]
+	beds        db_service.DbService[Bed]
+	revisions   db_service.DbService[DepartmentRevision]
+	policy      IntegrityPolicy
 }
 
-func NewDepartmentsAPI() DepartmentsAPI {
-	return &implDepartmentsAPI{}
+// NewDepartmentsAPI creates the departments api, the beds of a deleted department are handled
+// by the policy
+func NewDepartmentsAPI(
+	departments db_service.DbService[Department],
+	beds db_service.DbService[Bed],
+	revisions db_service.DbService[DepartmentRevision],
+	policy IntegrityPolicy,
+) DepartmentsAPI {
+	return &implDepartmentsAPI{
+		departments: departments,
+		beds:        beds,
+		revisions:   revisions,
+		policy:      policy,
+	}
 }
 
 func (o *implDepartmentsAPI) CreateDepartment(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
-	}
-
-	db, ok := value.(db_service.DbService[Department])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
-		return
-	}
-
 	department := Department{}
-	err := c.BindJSON(&department)
+	err := c.ShouldBindJSON(&department)
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		respondInvalidBody(c, err)
 		return
 	}
 
@@ -61,278 +49,369 @@ func (o *implDepartmentsAPI) CreateDepartment(c *gin.Context) {
 	now := time.Now()
 	department.CreatedAt = now
 	department.UpdatedAt = now
+	department.DeletedAt = nil
+	department.HospitalId = c.GetString(hospitalIdKey)
+
+	// bed counts are derived from the beds collection, a new department has none
+	department.Capacity.ActualBeds = 0
+	department.Capacity.OccupiedBeds = 0
+	department.Version = 1
 
-	err = db.CreateDocument(c, department.Id, &department)
+	err = o.departments.CreateDocument(c, department.Id, &department)
 
 	switch err {
 	case nil:
+		setETag(c, department.Version)
 		c.JSON(
 			http.StatusCreated,
 			department,
 		)
 	case db_service.ErrConflict:
-		c.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "Department already exists",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusConflict, codeDepartmentExists, "Department already exists")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create department in database",
-				"error":   err.Error(),
-			},
-		)
+		respondDatabaseError(c, err, "Failed to create department in database")
 	}
 }
 
 func (o *implDepartmentsAPI) GetDepartment(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	asOf, historic, err := parseAsOf(c)
+	if err != nil {
+		respondAsOfError(c, err)
 		return
 	}
-
-	db, ok := value.(db_service.DbService[Department])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	ctx, err := parseIncludeDeleted(c)
+	if err != nil {
+		respondIncludeDeletedError(c, err)
 		return
 	}
 
 	departmentId := c.Param("departmentId")
-	department, err := db.FindDocument(c, departmentId)
+	var department *Department
+	if historic {
+		department, err = findDocumentAsOf(ctx, o.departments, o.revisions, departmentId, asOf)
+	} else {
+		department, err = o.departments.FindDocument(ctx, departmentId)
+	}
 
 	switch err {
 	case nil:
+		// past states cannot be written, they carry no version to match
+		if !historic {
+			setETag(c, department.Version)
+		}
 		c.JSON(
 			http.StatusOK,
 			department,
 		)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Department not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codeDepartmentNotFound, "Department not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to find department in database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to find department in database")
 	}
 }
 
 func (o *implDepartmentsAPI) GetDepartments(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	query, err := parsePageQuery(c, departmentSortFields)
+	if err != nil {
+		respondPageQueryError(c, err)
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Department])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	ctx, err := parseIncludeDeleted(c)
+	if err != nil {
+		respondIncludeDeletedError(c, err)
 		return
 	}
 
-	departments, err := db.FindAllDocuments(c)
+	page, err := o.departments.FindDocumentsPage(ctx, query.request(nil))
 	switch err {
 	case nil:
+		writePageHeaders(c, query, page.TotalCount)
 		c.JSON(
 			http.StatusOK,
-			departments,
+			page.Documents,
 		)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to retrieve departments from database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to retrieve departments from database")
 	}
 }
 
 func (o *implDepartmentsAPI) UpdateDepartment(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	updatedDepartment := Department{}
+	err := c.ShouldBindJSON(&updatedDepartment)
+	if err != nil {
+		respondInvalidBody(c, err)
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Department])
-	if !ok {
+	o.replaceDepartment(c, c.Param("departmentId"), updatedDepartment)
+}
+
+// replaceDepartment replaces the department with the updated one keeping the fields
+// maintained by the service, and writes the response
+func (o *implDepartmentsAPI) replaceDepartment(c *gin.Context, departmentId string, updatedDepartment Department) {
+	precondition := parseIfMatch(c)
+	err := o.departments.WithTransaction(c, func(ctx context.Context) error {
+		existingDepartment, err := o.departments.FindDocument(ctx, departmentId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(existingDepartment.Version); err != nil {
+			return err
+		}
+
+		// Preserve certain fields, bed counts are maintained by the beds endpoints
+		updatedDepartment.Id = departmentId
+		updatedDepartment.Version = existingDepartment.Version
+		updatedDepartment.CreatedAt = existingDepartment.CreatedAt
+		updatedDepartment.UpdatedAt = time.Now()
+		updatedDepartment.DeletedAt = existingDepartment.DeletedAt
+		updatedDepartment.HospitalId = existingDepartment.HospitalId
+		updatedDepartment.Capacity.ActualBeds = existingDepartment.Capacity.ActualBeds
+		updatedDepartment.Capacity.OccupiedBeds = existingDepartment.Capacity.OccupiedBeds
+
+		maximum := updatedDepartment.Capacity.MaximumBeds
+		if maximum > 0 && maximum < updatedDepartment.Capacity.ActualBeds {
+			return errMaximumBelowActual
+		}
+		return saveDocument(ctx, o.departments, &updatedDepartment)
+	})
+
+	if respondConcurrencyError(c, err) {
+		return
+	}
+	switch err {
+	case nil:
+		setETag(c, updatedDepartment.Version)
 		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+			http.StatusOK,
+			updatedDepartment,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeDepartmentNotFound, "Department not found")
+	case errMaximumBelowActual:
+		respondProblem(c, http.StatusConflict, codeMaximumBelowActual,
+			"Cannot update department capacity, maximum_beds is lower than the number of existing beds")
+	default:
+		respondDatabaseError(c, err, "Failed to update department in database")
+	}
+}
+
+func (o *implDepartmentsAPI) PatchDepartment(c *gin.Context) {
+	patch, err := readDocumentPatch(c)
+	if respondPatchError(c, err) {
 		return
 	}
 
 	departmentId := c.Param("departmentId")
+	precondition := parseIfMatch(c)
 
-	// First check if department exists
-	existingDepartment, err := db.FindDocument(c, departmentId)
-	if err != nil {
-		switch err {
-		case db_service.ErrNotFound:
-			c.JSON(
-				http.StatusNotFound,
-				gin.H{
-					"status":  "Not Found",
-					"message": "Department not found",
-					"error":   err.Error(),
-				},
-			)
-		default:
-			c.JSON(
-				http.StatusBadGateway,
-				gin.H{
-					"status":  "Bad Gateway",
-					"message": "Failed to find department in database",
-					"error":   err.Error(),
-				})
+	var patchedDepartment *Department
+	err = o.departments.WithTransaction(c, func(ctx context.Context) error {
+		existingDepartment, err := o.departments.FindDocument(ctx, departmentId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(existingDepartment.Version); err != nil {
+			return err
+		}
+
+		patchedDepartment, err = applyDocumentPatch(patch, existingDepartment)
+		if err != nil {
+			return err
+		}
+
+		// Preserve certain fields, bed counts are maintained by the beds endpoints
+		patchedDepartment.Id = departmentId
+		patchedDepartment.CreatedAt = existingDepartment.CreatedAt
+		patchedDepartment.UpdatedAt = time.Now()
+		patchedDepartment.DeletedAt = existingDepartment.DeletedAt
+		patchedDepartment.HospitalId = existingDepartment.HospitalId
+		patchedDepartment.Capacity.ActualBeds = existingDepartment.Capacity.ActualBeds
+		patchedDepartment.Capacity.OccupiedBeds = existingDepartment.Capacity.OccupiedBeds
+
+		maximum := patchedDepartment.Capacity.MaximumBeds
+		if maximum > 0 && maximum < patchedDepartment.Capacity.ActualBeds {
+			return errMaximumBelowActual
 		}
+		return saveChangedFields(ctx, o.departments, existingDepartment, patchedDepartment)
+	})
+
+	if respondPatchError(c, err) || respondConcurrencyError(c, err) {
 		return
 	}
-
-	updatedDepartment := Department{}
-	err = c.BindJSON(&updatedDepartment)
-	if err != nil {
+	switch err {
+	case nil:
+		setETag(c, patchedDepartment.Version)
 		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+			http.StatusOK,
+			patchedDepartment,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeDepartmentNotFound, "Department not found")
+	case errMaximumBelowActual:
+		respondProblem(c, http.StatusConflict, codeMaximumBelowActual,
+			"Cannot update department capacity, maximum_beds is lower than the number of existing beds")
+	default:
+		respondDatabaseError(c, err, "Failed to patch department in database")
+	}
+}
+
+func (o *implDepartmentsAPI) DeleteDepartment(c *gin.Context) {
+	departmentId := c.Param("departmentId")
+	precondition := parseIfMatch(c)
+	err := o.departments.WithTransaction(c, func(ctx context.Context) error {
+		department, err := o.departments.FindDocument(ctx, departmentId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(department.Version); err != nil {
+			return err
+		}
+		if err := deleteDepartmentBeds(ctx, o.beds, o.policy.DepartmentBeds, departmentId); err != nil {
+			return err
+		}
+		return deleteDocument(ctx, o.departments, department)
+	})
+
+	if respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
 		return
 	}
+	switch err {
+	case nil:
+		c.AbortWithStatus(http.StatusNoContent)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeDepartmentNotFound, "Department not found")
+	default:
+		respondDatabaseError(c, err, "Failed to delete department from database")
+	}
+} 
 
-	// Preserve certain fields
-	updatedDepartment.Id = departmentId
-	updatedDepartment.CreatedAt = existingDepartment.CreatedAt
-	updatedDepartment.UpdatedAt = time.Now()
+func (o *implDepartmentsAPI) RestoreDepartment(c *gin.Context) {
+	departmentId := c.Param("departmentId")
+	precondition := parseIfMatch(c)
 
-	err = db.UpdateDocument(c, departmentId, &updatedDepartment)
+	var department *Department
+	err := o.departments.WithTransaction(c, func(ctx context.Context) error {
+		var err error
+		department, err = findDeletedDocument(ctx, o.departments, departmentId, precondition)
+		if err != nil {
+			return err
+		}
+
+		// beds have been changed while the department was deleted, its bed counts are recounted
+		beds, err := findBedsOfDepartment(ctx, o.beds, departmentId)
+		if err != nil {
+			return err
+		}
+		department.Capacity = countDepartmentCapacity([]*Department{department}, beds)[departmentId]
+
+		department.DeletedAt = nil
+		department.UpdatedAt = time.Now()
+		return saveRestoredDocument(ctx, o.departments, department)
+	})
 
+	if respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
+		setETag(c, department.Version)
 		c.JSON(
 			http.StatusOK,
-			updatedDepartment,
+			department,
 		)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Department not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codeDepartmentNotFound, "Department not found")
+	case errNotDeleted:
+		respondProblem(c, http.StatusConflict, codeNotDeleted, "Department is not deleted")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update department in database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to restore department in database")
 	}
 }
 
-func (o *implDepartmentsAPI) DeleteDepartment(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+func (o *implDepartmentsAPI) GetDepartmentHistory(c *gin.Context) {
+	respondHistory(c, o.departments, o.revisions, c.Param("departmentId"), nil, codeDepartmentNotFound,
+		"Department not found")
+}
+
+func (o *implDepartmentsAPI) RestoreDepartmentRevision(c *gin.Context) {
+	departmentId := c.Param("departmentId")
+	restoredDepartment, err := findRevision(c, o.revisions, departmentId, c.Param("revisionId"))
+	if respondRevisionError(c, err) {
 		return
 	}
-
-	db, ok := value.(db_service.DbService[Department])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	if err != nil {
+		respondDatabaseError(c, err, "Failed to find revision in database")
 		return
 	}
 
-	departmentId := c.Param("departmentId")
-	err := db.DeleteDocument(c, departmentId)
+	o.replaceDepartment(c, departmentId, *restoredDepartment)
+}
 
+func (o *implDepartmentsAPI) ReconcileDepartmentCapacity(c *gin.Context) {
+	dryRun := false
+	if value := c.Query("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			respondProblem(c, http.StatusBadRequest, codeInvalidQuery, "dry_run must be true or false")
+			return
+		}
+		dryRun = parsed
+	}
+
+	var reconciliation CapacityReconciliation
+
+	// counts and repairs are done on one snapshot of departments and beds
+	err := o.departments.WithTransaction(c, func(ctx context.Context) error {
+		departments, err := o.departments.FindAllDocuments(ctx)
+		if err != nil {
+			return err
+		}
+		beds, err := o.beds.FindAllDocuments(ctx)
+		if err != nil {
+			return err
+		}
+
+		reconciliation = CapacityReconciliation{
+			Checked:       len(departments),
+			Discrepancies: []CapacityDiscrepancy{},
+			Repaired:      !dryRun,
+		}
+		capacities := countDepartmentCapacity(departments, beds)
+		now := time.Now()
+		for _, department := range departments {
+			actual := capacities[department.Id]
+			if actual == department.Capacity {
+				continue
+			}
+			reconciliation.Discrepancies = append(reconciliation.Discrepancies, CapacityDiscrepancy{
+				DepartmentId: department.Id,
+				Stored:       department.Capacity,
+				Actual:       actual,
+			})
+			if dryRun {
+				continue
+			}
+			department.Capacity = actual
+			department.UpdatedAt = now
+			if err := saveDocument(ctx, o.departments, department); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
-		c.AbortWithStatus(http.StatusNoContent)
-	case db_service.ErrNotFound:
 		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Department not found",
-				"error":   err.Error(),
-			},
+			http.StatusOK,
+			reconciliation,
 		)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete department from database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to reconcile department capacity")
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,335 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+var (
+	errPatientNotFound        = errors.New("patient not found")
+	errBedNotFound            = errors.New("bed not found")
+	errDepartmentNotFound     = errors.New("department not found")
+	errBedOccupied            = errors.New("bed is already occupied")
+	errPatientAlreadyAdmitted = errors.New("patient is already admitted")
+	errPatientNotAdmitted     = errors.New("patient is not admitted")
+	errSameBed                = errors.New("patient already occupies the bed")
+)
+
+// activeHospitalization returns the hospitalization record of the current admission, if any
+func (p *Patient) activeHospitalization() *HospitalizationRecord {
+	for i := range p.HospitalizationRecords {
+		record := &p.HospitalizationRecords[i]
+		if record.AdmittedAt != nil && record.DischargedAt == nil {
+			return record
+		}
+	}
+	return nil
+}
+
+func (o *implPatientsAPI) AdmitPatient(c *gin.Context) {
+	admission := Admission{}
+	err := c.ShouldBindJSON(&admission)
+	if err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+
+	if admission.BedId == "" {
+		respondProblem(c, http.StatusBadRequest, codeValidationFailed, "bed_id is required")
+		return
+	}
+
+	patientId := c.Param("patientId")
+	var record HospitalizationRecord
+
+	// patient, bed and department are updated together or not at all
+	err = o.patients.WithTransaction(c, func(ctx context.Context) error {
+		patient, err := findForUpdate(ctx, o.patients, patientId, errPatientNotFound)
+		if err != nil {
+			return err
+		}
+		if patient.activeHospitalization() != nil {
+			return errPatientAlreadyAdmitted
+		}
+
+		bed, err := findForUpdate(ctx, o.beds, admission.BedId, errBedNotFound)
+		if err != nil {
+			return err
+		}
+		if bed.Status.PatientId != "" {
+			return errBedOccupied
+		}
+
+		now := time.Now()
+
+		bed.Status = BedStatus{
+			PatientId:   patient.Id,
+			Description: "Occupied",
+		}
+		bed.UpdatedAt = now
+		if err := saveDocument(ctx, o.beds, bed); err != nil {
+			return err
+		}
+
+		if err := adjustDepartmentCapacity(ctx, o.departments, bed.DepartmentId, 0, 1); err != nil {
+			return err
+		}
+
+		record = HospitalizationRecord{
+			Id:           uuid.New().String(),
+			Description:  admission.Description,
+			DepartmentId: bed.DepartmentId,
+			BedId:        bed.Id,
+			AdmittedAt:   &now,
+		}
+		patient.HospitalizationRecords = append(patient.HospitalizationRecords, record)
+		patient.UpdatedAt = now
+		return saveDocument(ctx, o.patients, patient)
+	})
+
+	switch err {
+	case nil:
+		c.JSON(
+			http.StatusCreated,
+			record,
+		)
+	default:
+		respondTransactionError(c, err, "Failed to admit patient")
+	}
+}
+
+func (o *implPatientsAPI) DischargePatient(c *gin.Context) {
+	discharge := Discharge{}
+	err := c.ShouldBindJSON(&discharge)
+	if err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+
+	patientId := c.Param("patientId")
+	var record HospitalizationRecord
+
+	err = o.patients.WithTransaction(c, func(ctx context.Context) error {
+		patient, err := findForUpdate(ctx, o.patients, patientId, errPatientNotFound)
+		if err != nil {
+			return err
+		}
+		active := patient.activeHospitalization()
+		if active == nil {
+			return errPatientNotAdmitted
+		}
+
+		now := time.Now()
+
+		// the bed may have been reassigned by hand meanwhile, free it only if it is still ours,
+		// the occupancy of its department drops only with the bed actually freed
+		bed, err := o.beds.FindDocument(ctx, active.BedId)
+		switch err {
+		case nil:
+			if bed.Status.PatientId == patient.Id {
+				bed.Status = BedStatus{Description: "Available"}
+				bed.UpdatedAt = now
+				if err := saveDocument(ctx, o.beds, bed); err != nil {
+					return err
+				}
+				err = adjustDepartmentCapacity(ctx, o.departments, bed.DepartmentId, 0, -1)
+				if err != nil && err != errDepartmentNotFound {
+					return err
+				}
+			}
+		case db_service.ErrNotFound:
+		default:
+			return err
+		}
+
+		active.DischargedAt = &now
+		active.DischargeReason = discharge.Reason
+		record = *active
+		patient.UpdatedAt = now
+		return saveDocument(ctx, o.patients, patient)
+	})
+
+	switch err {
+	case nil:
+		c.JSON(
+			http.StatusOK,
+			record,
+		)
+	default:
+		respondTransactionError(c, err, "Failed to discharge patient")
+	}
+}
+
+func (o *implPatientsAPI) TransferPatient(c *gin.Context) {
+	transfer := Transfer{}
+	err := c.ShouldBindJSON(&transfer)
+	if err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+
+	if transfer.ToBedId == "" {
+		respondProblem(c, http.StatusBadRequest, codeValidationFailed, "to_bed_id is required")
+		return
+	}
+
+	patientId := c.Param("patientId")
+	var entry BedTransfer
+
+	err = o.patients.WithTransaction(c, func(ctx context.Context) error {
+		patient, err := findForUpdate(ctx, o.patients, patientId, errPatientNotFound)
+		if err != nil {
+			return err
+		}
+		active := patient.activeHospitalization()
+		if active == nil {
+			return errPatientNotAdmitted
+		}
+		if active.BedId == transfer.ToBedId {
+			return errSameBed
+		}
+
+		toBed, err := findForUpdate(ctx, o.beds, transfer.ToBedId, errBedNotFound)
+		if err != nil {
+			return err
+		}
+		if toBed.Status.PatientId != "" {
+			return errBedOccupied
+		}
+
+		now := time.Now()
+
+		// occupancy follows the beds actually written, the from-bed may have been reassigned
+		// by hand meanwhile and is freed only if it is still ours
+		occupancyDeltas := map[string]int{}
+		fromBed, err := o.beds.FindDocument(ctx, active.BedId)
+		switch err {
+		case nil:
+			if fromBed.Status.PatientId == patient.Id {
+				fromBed.Status = BedStatus{Description: "Available"}
+				fromBed.UpdatedAt = now
+				if err := saveDocument(ctx, o.beds, fromBed); err != nil {
+					return err
+				}
+				occupancyDeltas[fromBed.DepartmentId]--
+			}
+		case db_service.ErrNotFound:
+		default:
+			return err
+		}
+
+		toBed.Status = BedStatus{
+			PatientId:   patient.Id,
+			Description: "Occupied",
+		}
+		toBed.UpdatedAt = now
+		if err := saveDocument(ctx, o.beds, toBed); err != nil {
+			return err
+		}
+		occupancyDeltas[toBed.DepartmentId]++
+
+		if err := adjustDepartmentOccupancy(ctx, o.departments, occupancyDeltas); err != nil {
+			return err
+		}
+
+		entry = BedTransfer{
+			Id:                uuid.New().String(),
+			HospitalizationId: active.Id,
+			FromBedId:         active.BedId,
+			FromDepartmentId:  active.DepartmentId,
+			ToBedId:           toBed.Id,
+			ToDepartmentId:    toBed.DepartmentId,
+			TransferredAt:     now,
+			Reason:            transfer.Reason,
+		}
+		active.Transfers = append(active.Transfers, entry)
+		active.BedId = toBed.Id
+		active.DepartmentId = toBed.DepartmentId
+		patient.UpdatedAt = now
+		return saveDocument(ctx, o.patients, patient)
+	})
+
+	switch err {
+	case nil:
+		c.JSON(
+			http.StatusCreated,
+			entry,
+		)
+	default:
+		respondTransactionError(c, err, "Failed to transfer patient")
+	}
+}
+
+func (o *implPatientsAPI) GetPatientTransfers(c *gin.Context) {
+	patientId := c.Param("patientId")
+	patient, err := o.patients.FindDocument(c, patientId)
+
+	switch err {
+	case nil:
+		transfers := []BedTransfer{}
+		for _, record := range patient.HospitalizationRecords {
+			transfers = append(transfers, record.Transfers...)
+		}
+		c.JSON(
+			http.StatusOK,
+			transfers,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
+	default:
+		respondDatabaseError(c, err, "Failed to find patient in database")
+	}
+}
+
+// findForUpdate loads the document and replaces db_service.ErrNotFound with notFoundErr
+func findForUpdate[DocType interface{}](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	id string,
+	notFoundErr error,
+) (*DocType, error) {
+	document, err := db.FindDocument(ctx, id)
+	if err == db_service.ErrNotFound {
+		return nil, notFoundErr
+	}
+	return document, err
+}
+
+// respondTransactionError maps errors of the cross-collection workflows to http responses,
+// the workflows are refused with the code of the error
+func respondTransactionError(c *gin.Context, err error, message string) {
+	var status int
+	var code string
+	switch err {
+	case errPatientNotFound:
+		status, code = http.StatusNotFound, codePatientNotFound
+	case errBedNotFound:
+		status, code = http.StatusNotFound, codeBedNotFound
+	case errDepartmentNotFound:
+		status, code = http.StatusConflict, codeDepartmentNotFound
+	case errBedOccupied:
+		status, code = http.StatusConflict, codeBedOccupied
+	case errPatientAlreadyAdmitted:
+		status, code = http.StatusConflict, codePatientAlreadyAdmitted
+	case errPatientNotAdmitted:
+		status, code = http.StatusConflict, codePatientNotAdmitted
+	case errSameBed:
+		status, code = http.StatusConflict, codeSameBed
+	case errDepartmentFull:
+		status, code = http.StatusConflict, codeDepartmentFull
+	case errMaximumBelowActual:
+		status, code = http.StatusConflict, codeMaximumBelowActual
+	default:
+		if !respondConcurrencyError(c, err) {
+			respondDatabaseError(c, err, message)
+		}
+		return
+	}
+	respondProblem(c, status, code, message+", "+err.Error())
+}
@@ -0,0 +1,246 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+var (
+	errHospitalNameMissing = errors.New("the hospital has to have a name")
+	errHospitalIdInvalid   = errors.New("the id of the hospital cannot contain a slash")
+)
+
+func (h *Hospital) documentId() string               { return h.Id }
+func (h *Hospital) documentVersion() int64           { return h.Version }
+func (h *Hospital) setDocumentVersion(version int64) { h.Version = version }
+
+type implHospitalsAPI struct {
+	hospitals   db_service.DbService[Hospital]
+	departments db_service.DbService[Department]
+	beds        db_service.DbService[Bed]
+	patients    db_service.DbService[Patient]
+}
+
+// NewHospitalsAPI creates the hospitals api, a hospital is deleted only without documents in the
+// other collections
+func NewHospitalsAPI(
+	hospitals db_service.DbService[Hospital],
+	departments db_service.DbService[Department],
+	beds db_service.DbService[Bed],
+	patients db_service.DbService[Patient],
+) HospitalsAPI {
+	return &implHospitalsAPI{
+		hospitals:   hospitals,
+		departments: departments,
+		beds:        beds,
+		patients:    patients,
+	}
+}
+
+// validateHospital checks the fields of the hospital given by the client
+func validateHospital(hospital *Hospital) error {
+	if strings.TrimSpace(hospital.Name) == "" {
+		return errHospitalNameMissing
+	}
+	// the id is a segment of the /api/hospitals/:hospitalId/ paths
+	if strings.Contains(hospital.Id, "/") {
+		return errHospitalIdInvalid
+	}
+	return nil
+}
+
+func (o *implHospitalsAPI) CreateHospital(c *gin.Context) {
+	hospital := Hospital{}
+	err := c.ShouldBindJSON(&hospital)
+	if err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+	if err := validateHospital(&hospital); err != nil {
+		respondProblem(c, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+
+	if hospital.Id == "" {
+		hospital.Id = uuid.New().String()
+	}
+
+	now := time.Now()
+	hospital.CreatedAt = now
+	hospital.UpdatedAt = now
+	hospital.Version = 1
+
+	err = o.hospitals.CreateDocument(c, hospital.Id, &hospital)
+
+	switch err {
+	case nil:
+		setETag(c, hospital.Version)
+		c.JSON(
+			http.StatusCreated,
+			hospital,
+		)
+	case db_service.ErrConflict:
+		respondProblem(c, http.StatusConflict, codeHospitalExists, "Hospital already exists")
+	default:
+		respondDatabaseError(c, err, "Failed to create hospital in database")
+	}
+}
+
+func (o *implHospitalsAPI) GetHospital(c *gin.Context) {
+	hospital, err := o.hospitals.FindDocument(c, c.Param("hospitalId"))
+
+	switch err {
+	case nil:
+		setETag(c, hospital.Version)
+		c.JSON(
+			http.StatusOK,
+			hospital,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+	default:
+		respondDatabaseError(c, err, "Failed to find hospital in database")
+	}
+}
+
+func (o *implHospitalsAPI) GetHospitals(c *gin.Context) {
+	query, err := parsePageQuery(c, hospitalSortFields)
+	if err != nil {
+		respondPageQueryError(c, err)
+		return
+	}
+
+	page, err := o.hospitals.FindDocumentsPage(c, query.request(nil))
+	switch err {
+	case nil:
+		writePageHeaders(c, query, page.TotalCount)
+		c.JSON(
+			http.StatusOK,
+			page.Documents,
+		)
+	default:
+		respondDatabaseError(c, err, "Failed to retrieve hospitals from database")
+	}
+}
+
+func (o *implHospitalsAPI) UpdateHospital(c *gin.Context) {
+	updatedHospital := Hospital{}
+	err := c.ShouldBindJSON(&updatedHospital)
+	if err != nil {
+		respondInvalidBody(c, err)
+		return
+	}
+	if err := validateHospital(&updatedHospital); err != nil {
+		respondProblem(c, http.StatusBadRequest, codeValidationFailed, err.Error())
+		return
+	}
+
+	hospitalId := c.Param("hospitalId")
+	precondition := parseIfMatch(c)
+	err = o.hospitals.WithTransaction(c, func(ctx context.Context) error {
+		existingHospital, err := o.hospitals.FindDocument(ctx, hospitalId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(existingHospital.Version); err != nil {
+			return err
+		}
+
+		// Preserve certain fields
+		updatedHospital.Id = hospitalId
+		updatedHospital.Version = existingHospital.Version
+		updatedHospital.CreatedAt = existingHospital.CreatedAt
+		updatedHospital.UpdatedAt = time.Now()
+		return saveDocument(ctx, o.hospitals, &updatedHospital)
+	})
+
+	if respondConcurrencyError(c, err) {
+		return
+	}
+	switch err {
+	case nil:
+		setETag(c, updatedHospital.Version)
+		c.JSON(
+			http.StatusOK,
+			updatedHospital,
+		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+	default:
+		respondDatabaseError(c, err, "Failed to update hospital in database")
+	}
+}
+
+func (o *implHospitalsAPI) DeleteHospital(c *gin.Context) {
+	hospitalId := c.Param("hospitalId")
+	precondition := parseIfMatch(c)
+	err := o.hospitals.WithTransaction(c, func(ctx context.Context) error {
+		hospital, err := o.hospitals.FindDocument(ctx, hospitalId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(hospital.Version); err != nil {
+			return err
+		}
+
+		// documents marked as deleted still belong to the hospital until they are purged
+		scoped := withDeleted(WithHospital(ctx, hospitalId))
+		var blockers []Blocker
+		for _, count := range []func(context.Context) (*Blocker, error){
+			hospitalDocumentsBlocker(o.departments, "department"),
+			hospitalDocumentsBlocker(o.beds, "bed"),
+			hospitalDocumentsBlocker(o.patients, "patient"),
+		} {
+			blocker, err := count(scoped)
+			if err != nil {
+				return err
+			}
+			if blocker != nil {
+				blockers = append(blockers, *blocker)
+			}
+		}
+		if len(blockers) > 0 {
+			return &integrityError{code: codeHospitalHasDocuments, message: "hospital has documents", blockers: blockers}
+		}
+		return deleteDocument(ctx, o.hospitals, hospital)
+	})
+
+	if respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
+		return
+	}
+	switch err {
+	case nil:
+		c.AbortWithStatus(http.StatusNoContent)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+	default:
+		respondDatabaseError(c, err, "Failed to delete hospital from database")
+	}
+}
+
+// hospitalDocumentsBlocker returns a function finding a blocker naming the first document of
+// the collection in the hospital of the context and their count, nil if there is none
+func hospitalDocumentsBlocker[DocType interface{}, P versionedDocument[DocType]](
+	db db_service.DbService[DocType],
+	entity string,
+) func(context.Context) (*Blocker, error) {
+	return func(ctx context.Context) (*Blocker, error) {
+		page, err := db.FindDocumentsPage(ctx, db_service.PageRequest{Limit: 1})
+		if err != nil || len(page.Documents) == 0 {
+			return nil, err
+		}
+		return &Blocker{
+			Entity: entity,
+			Id:     P(page.Documents[0]).documentId(),
+			Reason: fmt.Sprintf("belongs to the hospital with %d %s documents", page.TotalCount, entity),
+		}, nil
+	}
+}
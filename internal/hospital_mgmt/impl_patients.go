@@ -1,6 +1,7 @@
 package hospital_mgmt
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -10,47 +11,36 @@ import (
 )
 
 type implPatientsAPI struct {
+	patients    db_service.DbService[Patient]
+	beds        db_service.DbService[Bed]
+	departments db_service.DbService[Department]
+	revisions   db_service.DbService[PatientRevision]
+	policy      IntegrityPolicy
 }
 
-func NewPatientsAPI() PatientsAPI {
-	return &implPatientsAPI{}
+// NewPatientsAPI creates the patients api, admissions occupy the beds and count them in the
+// capacities of their departments, the beds of a deleted patient are handled by the policy
+func NewPatientsAPI(
+	patients db_service.DbService[Patient],
+	beds db_service.DbService[Bed],
+	departments db_service.DbService[Department],
+	revisions db_service.DbService[PatientRevision],
+	policy IntegrityPolicy,
+) PatientsAPI {
+	return &implPatientsAPI{
+		patients:    patients,
+		beds:        beds,
+		departments: departments,
+		revisions:   revisions,
+		policy:      policy,
+	}
 }
 
 func (o *implPatientsAPI) CreatePatient(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
-	}
-
-	db, ok := value.(db_service.DbService[Patient])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
-		return
-	}
-
 	patient := Patient{}
-	err := c.BindJSON(&patient)
+	err := c.ShouldBindJSON(&patient)
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		respondInvalidBody(c, err)
 		return
 	}
 
@@ -61,344 +51,365 @@ func (o *implPatientsAPI) CreatePatient(c *gin.Context) {
 	now := time.Now()
 	patient.CreatedAt = now
 	patient.UpdatedAt = now
+	patient.DeletedAt = nil
+	patient.HospitalId = c.GetString(hospitalIdKey)
+	patient.Version = 1
+	patient.UpdateSearchTerms()
 
-	err = db.CreateDocument(c, patient.Id, &patient)
+	if !hasPermission(c, permissionHospitalizationsWrite) && len(patient.HospitalizationRecords) > 0 {
+		respondRecordsNotPermitted(c)
+		return
+	}
+	// an admission is opened only by the admit endpoint, which occupies the bed as well
+	if respondIntegrityError(c, checkActiveAdmissionUnchanged(patient.Id, nil, patient.HospitalizationRecords)) {
+		return
+	}
+
+	err = o.patients.CreateDocument(c, patient.Id, &patient)
 
 	switch err {
 	case nil:
+		setETag(c, patient.Version)
 		c.JSON(
 			http.StatusCreated,
-			patient,
+			readablePatient(c, &patient),
 		)
 	case db_service.ErrConflict:
-		c.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "Patient already exists",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusConflict, codePatientExists, "Patient already exists")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create patient in database",
-				"error":   err.Error(),
-			},
-		)
+		respondDatabaseError(c, err, "Failed to create patient in database")
 	}
 }
 
 func (o *implPatientsAPI) GetPatient(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	asOf, historic, err := parseAsOf(c)
+	if err != nil {
+		respondAsOfError(c, err)
 		return
 	}
-
-	db, ok := value.(db_service.DbService[Patient])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	ctx, err := parseIncludeDeleted(c)
+	if err != nil {
+		respondIncludeDeletedError(c, err)
 		return
 	}
 
 	patientId := c.Param("patientId")
-	patient, err := db.FindDocument(c, patientId)
+	var patient *Patient
+	if historic {
+		patient, err = findDocumentAsOf(ctx, o.patients, o.revisions, patientId, asOf)
+	} else {
+		patient, err = o.patients.FindDocument(ctx, patientId)
+	}
 
 	switch err {
 	case nil:
+		// past states cannot be written, they carry no version to match
+		if !historic {
+			setETag(c, patient.Version)
+		}
 		c.JSON(
 			http.StatusOK,
-			patient,
+			readablePatient(c, patient),
 		)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Patient not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to find patient in database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to find patient in database")
 	}
 }
 
 func (o *implPatientsAPI) GetPatients(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	query, err := parsePageQuery(c, patientSortFields)
+	if err != nil {
+		respondPageQueryError(c, err)
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Patient])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	ctx, err := parseIncludeDeleted(c)
+	if err != nil {
+		respondIncludeDeletedError(c, err)
 		return
 	}
 
-	patients, err := db.FindAllDocuments(c)
+	page, err := o.patients.FindDocumentsPage(ctx, query.request(nil))
 	switch err {
 	case nil:
+		writePageHeaders(c, query, page.TotalCount)
 		c.JSON(
 			http.StatusOK,
-			patients,
+			readablePatients(c, page.Documents),
 		)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to retrieve patients from database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to retrieve patients from database")
 	}
 }
 
 func (o *implPatientsAPI) UpdatePatient(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+	// First check if patient exists
+	existingPatient, ok := o.findPatientToReplace(c, c.Param("patientId"))
+	if !ok {
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Patient])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	updatedPatient := Patient{}
+	err := c.ShouldBindJSON(&updatedPatient)
+	if err != nil {
+		respondInvalidBody(c, err)
 		return
 	}
 
-	patientId := c.Param("patientId")
+	o.replacePatient(c, existingPatient, updatedPatient)
+}
 
-	// First check if patient exists
-	existingPatient, err := db.FindDocument(c, patientId)
-	if err != nil {
-		switch err {
-		case db_service.ErrNotFound:
-			c.JSON(
-				http.StatusNotFound,
-				gin.H{
-					"status":  "Not Found",
-					"message": "Patient not found",
-					"error":   err.Error(),
-				},
-			)
-		default:
-			c.JSON(
-				http.StatusBadGateway,
-				gin.H{
-					"status":  "Bad Gateway",
-					"message": "Failed to find patient in database",
-					"error":   err.Error(),
-				})
-		}
-		return
+// findPatientToReplace returns the patient matching the If-Match header of the request,
+// or writes the error response
+func (o *implPatientsAPI) findPatientToReplace(c *gin.Context, patientId string) (*Patient, bool) {
+	existingPatient, err := o.patients.FindDocument(c, patientId)
+	if err == nil {
+		err = parseIfMatch(c).check(existingPatient.Version)
+	}
+	if err == nil {
+		return existingPatient, true
 	}
 
-	updatedPatient := Patient{}
-	err = c.BindJSON(&updatedPatient)
-	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
-		return
+	if respondConcurrencyError(c, err) {
+		return nil, false
+	}
+	switch err {
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
+	default:
+		respondDatabaseError(c, err, "Failed to find patient in database")
 	}
+	return nil, false
+}
 
+// replacePatient replaces the existing patient with the updated one keeping the fields
+// maintained by the service, and writes the response
+func (o *implPatientsAPI) replacePatient(c *gin.Context, existingPatient *Patient, updatedPatient Patient) {
 	// Preserve certain fields
-	updatedPatient.Id = patientId
+	updatedPatient.Id = existingPatient.Id
 	updatedPatient.CreatedAt = existingPatient.CreatedAt
 	updatedPatient.UpdatedAt = time.Now()
+	updatedPatient.DeletedAt = existingPatient.DeletedAt
+	updatedPatient.HospitalId = existingPatient.HospitalId
+	updatedPatient.Version = existingPatient.Version
+	// records are managed by the admission and hospitalization record endpoints
+	updatedPatient.HospitalizationRecords = existingPatient.HospitalizationRecords
+	updatedPatient.UpdateSearchTerms()
 
-	err = db.UpdateDocument(c, patientId, &updatedPatient)
+	err := saveDocument(c, o.patients, &updatedPatient)
 
+	if respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
+		setETag(c, updatedPatient.Version)
 		c.JSON(
 			http.StatusOK,
-			updatedPatient,
+			readablePatient(c, &updatedPatient),
 		)
 	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
+	default:
+		respondDatabaseError(c, err, "Failed to update patient in database")
+	}
+}
+
+func (o *implPatientsAPI) RestorePatient(c *gin.Context) {
+	patientId := c.Param("patientId")
+	precondition := parseIfMatch(c)
+
+	var patient *Patient
+	err := o.patients.WithTransaction(c, func(ctx context.Context) error {
+		var err error
+		patient, err = findDeletedDocument(ctx, o.patients, patientId, precondition)
+		if err != nil {
+			return err
+		}
+
+		// the bed of the patient has been freed by the delete, the hospitalization ended then
+		if active := patient.activeHospitalization(); active != nil {
+			bed, err := o.beds.FindDocument(ctx, active.BedId)
+			if err != nil && err != db_service.ErrNotFound {
+				return err
+			}
+			if err == db_service.ErrNotFound || bed.Status.PatientId != patientId {
+				dischargedAt := *patient.DeletedAt
+				active.DischargedAt = &dischargedAt
+			}
+		}
+
+		patient.DeletedAt = nil
+		patient.UpdatedAt = time.Now()
+		return saveRestoredDocument(ctx, o.patients, patient)
+	})
+
+	if respondConcurrencyError(c, err) {
+		return
+	}
+	switch err {
+	case nil:
+		setETag(c, patient.Version)
 		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Patient not found",
-				"error":   err.Error(),
-			},
+			http.StatusOK,
+			readablePatient(c, patient),
 		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
+	case errNotDeleted:
+		respondProblem(c, http.StatusConflict, codeNotDeleted, "Patient is not deleted")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update patient in database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to restore patient in database")
 	}
 }
 
-func (o *implPatientsAPI) DeletePatient(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+func (o *implPatientsAPI) GetPatientHistory(c *gin.Context) {
+	respondHistory(c, o.patients, o.revisions, c.Param("patientId"), readablePatient, codePatientNotFound,
+		"Patient not found")
+}
+
+func (o *implPatientsAPI) RestorePatientRevision(c *gin.Context) {
+	patientId := c.Param("patientId")
+	restoredPatient, err := findRevision(c, o.revisions, patientId, c.Param("revisionId"))
+	if respondRevisionError(c, err) {
+		return
+	}
+	if err != nil {
+		respondDatabaseError(c, err, "Failed to find revision in database")
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Patient])
+	existingPatient, ok := o.findPatientToReplace(c, patientId)
 	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+		return
+	}
+	o.replacePatient(c, existingPatient, *restoredPatient)
+}
+
+func (o *implPatientsAPI) PatchPatient(c *gin.Context) {
+	patch, err := readDocumentPatch(c)
+	if respondPatchError(c, err) {
 		return
 	}
 
 	patientId := c.Param("patientId")
-	err := db.DeleteDocument(c, patientId)
+	precondition := parseIfMatch(c)
+	recordsReadOnly := !hasPermission(c, permissionHospitalizationsWrite)
+
+	var patchedPatient *Patient
+	err = o.patients.WithTransaction(c, func(ctx context.Context) error {
+		existingPatient, err := o.patients.FindDocument(ctx, patientId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(existingPatient.Version); err != nil {
+			return err
+		}
 
+		patchedPatient, err = applyDocumentPatch(patch, existingPatient)
+		if err != nil {
+			return err
+		}
+
+		// Preserve certain fields
+		patchedPatient.Id = patientId
+		patchedPatient.CreatedAt = existingPatient.CreatedAt
+		patchedPatient.UpdatedAt = time.Now()
+		patchedPatient.DeletedAt = existingPatient.DeletedAt
+		patchedPatient.HospitalId = existingPatient.HospitalId
+		patchedPatient.UpdateSearchTerms()
+
+		if recordsReadOnly {
+			err = checkHospitalizationRecordsUnchanged(
+				existingPatient.HospitalizationRecords, patchedPatient.HospitalizationRecords)
+			if err != nil {
+				return err
+			}
+		}
+		err = checkActiveAdmissionUnchanged(
+			patientId, existingPatient.HospitalizationRecords, patchedPatient.HospitalizationRecords)
+		if err != nil {
+			return err
+		}
+		return saveChangedFields(ctx, o.patients, existingPatient, patchedPatient)
+	})
+
+	if respondPatchError(c, err) || respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
-		c.AbortWithStatus(http.StatusNoContent)
-	case db_service.ErrNotFound:
+		setETag(c, patchedPatient.Version)
 		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Patient not found",
-				"error":   err.Error(),
-			},
+			http.StatusOK,
+			readablePatient(c, patchedPatient),
 		)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
+	case errHospitalizationRecordsReadOnly:
+		respondRecordsNotPermitted(c)
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete patient from database",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to patch patient in database")
 	}
 }
 
-func (o *implPatientsAPI) AddHospitalizationRecord(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
-	}
+func (o *implPatientsAPI) DeletePatient(c *gin.Context) {
+	patientId := c.Param("patientId")
+	precondition := parseIfMatch(c)
+	err := o.patients.WithTransaction(c, func(ctx context.Context) error {
+		patient, err := o.patients.FindDocument(ctx, patientId)
+		if err != nil {
+			return err
+		}
+		if err := precondition.check(patient.Version); err != nil {
+			return err
+		}
+		if err := releasePatientBeds(ctx, o.beds, o.departments, o.policy.PatientBeds, patientId); err != nil {
+			return err
+		}
+		return deleteDocument(ctx, o.patients, patient)
+	})
 
-	db, ok := value.(db_service.DbService[Patient])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	if respondIntegrityError(c, err) || respondConcurrencyError(c, err) {
 		return
 	}
+	switch err {
+	case nil:
+		c.AbortWithStatus(http.StatusNoContent)
+	case db_service.ErrNotFound:
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
+	default:
+		respondDatabaseError(c, err, "Failed to delete patient from database")
+	}
+}
 
+func (o *implPatientsAPI) AddHospitalizationRecord(c *gin.Context) {
 	patientId := c.Param("patientId")
 
 	// First find the existing patient
-	patient, err := db.FindDocument(c, patientId)
+	patient, err := o.patients.FindDocument(c, patientId)
+	if err == nil {
+		err = parseIfMatch(c).check(patient.Version)
+	}
 	if err != nil {
+		if respondConcurrencyError(c, err) {
+			return
+		}
 		switch err {
 		case db_service.ErrNotFound:
-			c.JSON(
-				http.StatusNotFound,
-				gin.H{
-					"status":  "Not Found",
-					"message": "Patient not found",
-					"error":   err.Error(),
-				},
-			)
+			respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
 		default:
-			c.JSON(
-				http.StatusBadGateway,
-				gin.H{
-					"status":  "Bad Gateway",
-					"message": "Failed to find patient in database",
-					"error":   err.Error(),
-				})
+			respondDatabaseError(c, err, "Failed to find patient in database")
 		}
 		return
 	}
 
 	newRecord := HospitalizationRecord{}
-	err = c.BindJSON(&newRecord)
+	err = c.ShouldBindJSON(&newRecord)
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		respondInvalidBody(c, err)
 		return
 	}
 
@@ -407,11 +418,18 @@ func (o *implPatientsAPI) AddHospitalizationRecord(c *gin.Context) {
 	}
 
 	// Add the new record to the patient's list
-	patient.HospitalizationRecords = append(patient.HospitalizationRecords, newRecord)
+	records := patient.HospitalizationRecords
+	patient.HospitalizationRecords = append(records, newRecord)
+	if respondIntegrityError(c, checkActiveAdmissionUnchanged(patientId, records, patient.HospitalizationRecords)) {
+		return
+	}
 	patient.UpdatedAt = time.Now()
 
-	err = db.UpdateDocument(c, patientId, patient)
+	err = saveDocument(c, o.patients, patient)
 
+	if respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
 		c.JSON(
@@ -419,92 +437,43 @@ func (o *implPatientsAPI) AddHospitalizationRecord(c *gin.Context) {
 			newRecord,
 		)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Patient not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to add hospitalization record",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to add hospitalization record")
 	}
 }
 
 func (o *implPatientsAPI) UpdateHospitalizationRecord(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
-	}
-
-	db, ok := value.(db_service.DbService[Patient])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
-		return
-	}
-
 	patientId := c.Param("patientId")
 	recordId := c.Param("recordId")
 
 	// First find the existing patient
-	patient, err := db.FindDocument(c, patientId)
+	patient, err := o.patients.FindDocument(c, patientId)
+	if err == nil {
+		err = parseIfMatch(c).check(patient.Version)
+	}
 	if err != nil {
+		if respondConcurrencyError(c, err) {
+			return
+		}
 		switch err {
 		case db_service.ErrNotFound:
-			c.JSON(
-				http.StatusNotFound,
-				gin.H{
-					"status":  "Not Found",
-					"message": "Patient not found",
-					"error":   err.Error(),
-				},
-			)
+			respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
 		default:
-			c.JSON(
-				http.StatusBadGateway,
-				gin.H{
-					"status":  "Bad Gateway",
-					"message": "Failed to find patient in database",
-					"error":   err.Error(),
-				})
+			respondDatabaseError(c, err, "Failed to find patient in database")
 		}
 		return
 	}
 
 	updatedRecord := HospitalizationRecord{}
-	err = c.BindJSON(&updatedRecord)
+	err = c.ShouldBindJSON(&updatedRecord)
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		respondInvalidBody(c, err)
 		return
 	}
 
 	// Find and update the specific record
+	records := append([]HospitalizationRecord{}, patient.HospitalizationRecords...)
 	recordFound := false
 	for i, record := range patient.HospitalizationRecords {
 		if record.Id == recordId {
@@ -516,21 +485,20 @@ func (o *implPatientsAPI) UpdateHospitalizationRecord(c *gin.Context) {
 	}
 
 	if !recordFound {
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Hospitalization record not found",
-				"error":   "record with specified ID not found",
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codeRecordNotFound, "Hospitalization record not found")
+		return
+	}
+	if respondIntegrityError(c, checkActiveAdmissionUnchanged(patientId, records, patient.HospitalizationRecords)) {
 		return
 	}
 
 	patient.UpdatedAt = time.Now()
 
-	err = db.UpdateDocument(c, patientId, patient)
+	err = saveDocument(c, o.patients, patient)
 
+	if respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
 		c.JSON(
@@ -538,79 +506,36 @@ func (o *implPatientsAPI) UpdateHospitalizationRecord(c *gin.Context) {
 			updatedRecord,
 		)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Patient not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update hospitalization record",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to update hospitalization record")
 	}
 }
 
 func (o *implPatientsAPI) DeleteHospitalizationRecord(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
-	}
-
-	db, ok := value.(db_service.DbService[Patient])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
-		return
-	}
-
 	patientId := c.Param("patientId")
 	recordId := c.Param("recordId")
 
 	// First find the existing patient
-	patient, err := db.FindDocument(c, patientId)
+	patient, err := o.patients.FindDocument(c, patientId)
+	if err == nil {
+		err = parseIfMatch(c).check(patient.Version)
+	}
 	if err != nil {
+		if respondConcurrencyError(c, err) {
+			return
+		}
 		switch err {
 		case db_service.ErrNotFound:
-			c.JSON(
-				http.StatusNotFound,
-				gin.H{
-					"status":  "Not Found",
-					"message": "Patient not found",
-					"error":   err.Error(),
-				},
-			)
+			respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
 		default:
-			c.JSON(
-				http.StatusBadGateway,
-				gin.H{
-					"status":  "Bad Gateway",
-					"message": "Failed to find patient in database",
-					"error":   err.Error(),
-				})
+			respondDatabaseError(c, err, "Failed to find patient in database")
 		}
 		return
 	}
 
 	// Find and remove the specific record
+	records := append([]HospitalizationRecord{}, patient.HospitalizationRecords...)
 	recordFound := false
 	for i, record := range patient.HospitalizationRecords {
 		if record.Id == recordId {
@@ -625,40 +550,26 @@ func (o *implPatientsAPI) DeleteHospitalizationRecord(c *gin.Context) {
 	}
 
 	if !recordFound {
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Hospitalization record not found",
-				"error":   "record with specified ID not found",
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codeRecordNotFound, "Hospitalization record not found")
+		return
+	}
+	if respondIntegrityError(c, checkActiveAdmissionUnchanged(patientId, records, patient.HospitalizationRecords)) {
 		return
 	}
 
 	patient.UpdatedAt = time.Now()
 
-	err = db.UpdateDocument(c, patientId, patient)
+	err = saveDocument(c, o.patients, patient)
 
+	if respondConcurrencyError(c, err) {
+		return
+	}
 	switch err {
 	case nil:
 		c.AbortWithStatus(http.StatusNoContent)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Patient not found",
-				"error":   err.Error(),
-			},
-		)
+		respondProblem(c, http.StatusNotFound, codePatientNotFound, "Patient not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete hospitalization record",
-				"error":   err.Error(),
-			})
+		respondDatabaseError(c, err, "Failed to delete hospitalization record")
 	}
 } 
\ No newline at end of file
@@ -0,0 +1,259 @@
+package hospital_mgmt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+)
+
+// DeletePolicy decides what happens with documents referencing a deleted document
+type DeletePolicy string
+
+const (
+	// DeleteRestrict refuses the delete while referencing documents exist
+	DeleteRestrict DeletePolicy = "restrict"
+	// DeleteCascade deletes or releases the referencing documents together with the deleted one
+	DeleteCascade DeletePolicy = "cascade"
+)
+
+func ParseDeletePolicy(value string) (DeletePolicy, error) {
+	switch policy := DeletePolicy(strings.ToLower(value)); policy {
+	case DeleteRestrict, DeleteCascade:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown delete policy: %q", value)
+	}
+}
+
+// IntegrityPolicy configures the delete policy of every relationship between collections
+type IntegrityPolicy struct {
+	// Beds located in a deleted department - cascade deletes the free beds,
+	// occupied beds always block the delete
+	DepartmentBeds DeletePolicy
+	// Beds occupied by a deleted patient - cascade frees the beds
+	PatientBeds DeletePolicy
+}
+
+var DefaultIntegrityPolicy = IntegrityPolicy{
+	DepartmentBeds: DeleteRestrict,
+	PatientBeds:    DeleteRestrict,
+}
+
+type Blocker struct {
+	// Collection of the blocking document
+	Entity string `json:"entity"`
+
+	// ID of the blocking document
+	Id string `json:"id"`
+
+	// Why the document blocks the operation
+	Reason string `json:"reason"`
+}
+
+// integrityError refuses a write which would break references between collections
+type integrityError struct {
+	code     string
+	message  string
+	blockers []Blocker
+}
+
+func (e *integrityError) Error() string {
+	return e.message
+}
+
+// respondIntegrityError writes 409 with the list of blockers, returns false for other errors
+func respondIntegrityError(c *gin.Context, err error) bool {
+	integrityErr, ok := err.(*integrityError)
+	if !ok {
+		return false
+	}
+	problem.Respond(c, &integrityProblem{
+		Problem: *problem.New(http.StatusConflict, integrityErr.code,
+			"Operation would break references between documents, "+integrityErr.message),
+		Blockers: integrityErr.blockers,
+	})
+	return true
+}
+
+// validateBedReferences checks that the department and the patient of the bed exist
+func validateBedReferences(
+	ctx context.Context,
+	departmentDb db_service.DbService[Department],
+	patientDb db_service.DbService[Patient],
+	bed *Bed,
+) error {
+	var blockers []Blocker
+
+	_, err := departmentDb.FindDocument(ctx, bed.DepartmentId)
+	switch err {
+	case nil:
+	case db_service.ErrNotFound:
+		blockers = append(blockers, Blocker{Entity: "department", Id: bed.DepartmentId, Reason: "does not exist"})
+	default:
+		return err
+	}
+
+	if bed.isOccupied() {
+		_, err := patientDb.FindDocument(ctx, bed.Status.PatientId)
+		switch err {
+		case nil:
+		case db_service.ErrNotFound:
+			blockers = append(blockers, Blocker{Entity: "patient", Id: bed.Status.PatientId, Reason: "does not exist"})
+		default:
+			return err
+		}
+	}
+
+	if len(blockers) > 0 {
+		return &integrityError{code: codeMissingReference, message: "bed references missing documents", blockers: blockers}
+	}
+	return nil
+}
+
+// checkBedOccupancyUnchanged refuses a bed write which assigns the bed to another patient or
+// moves an occupied bed to another department, occupancy follows the patient's admission and
+// is changed only by admit, discharge and transfer. before is nil for a new bed.
+func checkBedOccupancyUnchanged(before *Bed, after *Bed) error {
+	var beforePatient, beforeDepartment string
+	if before != nil {
+		beforePatient, beforeDepartment = before.Status.PatientId, before.DepartmentId
+	}
+
+	var blockers []Blocker
+	switch {
+	case after.Status.PatientId != beforePatient:
+		blockers = append(blockers, Blocker{
+			Entity: "bed", Id: after.Id, Reason: "patient of the bed is changed by admit, discharge and transfer",
+		})
+	case after.isOccupied() && after.DepartmentId != beforeDepartment:
+		blockers = append(blockers, Blocker{
+			Entity: "bed", Id: after.Id, Reason: "occupied bed cannot move to another department",
+		})
+	}
+	if len(blockers) > 0 {
+		return &integrityError{code: codeAdmissionWorkflow, message: "bed occupancy does not match the admission", blockers: blockers}
+	}
+	return nil
+}
+
+// checkActiveAdmissionUnchanged refuses a change of the patient's hospitalization records which
+// opens, closes, edits or removes an admission in progress, these are changed only by admit,
+// discharge and transfer together with the occupied bed
+func checkActiveAdmissionUnchanged(patientId string, before []HospitalizationRecord, after []HospitalizationRecord) error {
+	beforeOpen, err := json.Marshal(openHospitalizations(before))
+	if err != nil {
+		return err
+	}
+	afterOpen, err := json.Marshal(openHospitalizations(after))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(beforeOpen, afterOpen) {
+		return nil
+	}
+	return &integrityError{
+		code:    codeAdmissionWorkflow,
+		message: "active admission is changed by admit, discharge and transfer",
+		blockers: []Blocker{
+			{Entity: "patient", Id: patientId, Reason: "hospitalization record of the active admission"},
+		},
+	}
+}
+
+func openHospitalizations(records []HospitalizationRecord) []HospitalizationRecord {
+	open := []HospitalizationRecord{}
+	for _, record := range records {
+		if record.AdmittedAt != nil && record.DischargedAt == nil {
+			open = append(open, record)
+		}
+	}
+	return open
+}
+
+func findBedsOfDepartment(ctx context.Context, bedDb db_service.DbService[Bed], departmentId string) ([]*Bed, error) {
+	return bedDb.FindDocumentsByFilter(ctx, map[string]interface{}{
+		"department_id": departmentId,
+	})
+}
+
+func findBedsOfPatient(ctx context.Context, bedDb db_service.DbService[Bed], patientId string) ([]*Bed, error) {
+	return bedDb.FindDocumentsByFilter(ctx, map[string]interface{}{
+		"status.patient_id": patientId,
+	})
+}
+
+// deleteDepartmentBeds applies the department-beds policy before the department is deleted
+func deleteDepartmentBeds(
+	ctx context.Context,
+	bedDb db_service.DbService[Bed],
+	policy DeletePolicy,
+	departmentId string,
+) error {
+	beds, err := findBedsOfDepartment(ctx, bedDb, departmentId)
+	if err != nil {
+		return err
+	}
+
+	var blockers []Blocker
+	for _, bed := range beds {
+		switch {
+		case bed.isOccupied():
+			blockers = append(blockers, Blocker{Entity: "bed", Id: bed.Id, Reason: "bed is occupied"})
+		case policy != DeleteCascade:
+			blockers = append(blockers, Blocker{Entity: "bed", Id: bed.Id, Reason: "bed is located in the department"})
+		}
+	}
+	if len(blockers) > 0 {
+		return &integrityError{code: codeDepartmentHasBeds, message: "department has beds", blockers: blockers}
+	}
+
+	for _, bed := range beds {
+		if err := deleteDocument(ctx, bedDb, bed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePatientBeds applies the patient-beds policy before the patient is deleted
+func releasePatientBeds(
+	ctx context.Context,
+	bedDb db_service.DbService[Bed],
+	departmentDb db_service.DbService[Department],
+	policy DeletePolicy,
+	patientId string,
+) error {
+	beds, err := findBedsOfPatient(ctx, bedDb, patientId)
+	if err != nil {
+		return err
+	}
+
+	if len(beds) > 0 && policy != DeleteCascade {
+		blockers := make([]Blocker, 0, len(beds))
+		for _, bed := range beds {
+			blockers = append(blockers, Blocker{Entity: "bed", Id: bed.Id, Reason: "bed is occupied by the patient"})
+		}
+		return &integrityError{code: codePatientOccupiesBeds, message: "patient occupies beds", blockers: blockers}
+	}
+
+	for _, bed := range beds {
+		released := *bed
+		released.Status = BedStatus{Description: "Available"}
+		released.UpdatedAt = time.Now()
+		if err := updateBedCapacity(ctx, departmentDb, bed, &released); err != nil {
+			return err
+		}
+		if err := saveDocument(ctx, bedDb, &released); err != nil {
+			return err
+		}
+	}
+	return nil
+}
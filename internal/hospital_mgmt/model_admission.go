@@ -0,0 +1,31 @@
+/*
+ * Hospital Management Api
+ *
+ * Hospital Management system for Web-In-Cloud
+ *
+ * API version: 1.0.0
+ * Contact: xsabol@stuba.sk
+ */
+
+package hospital_mgmt
+
+type Admission struct {
+	// Bed ID to assign to the patient
+	BedId string `json:"bed_id"`
+
+	// Description of the hospitalization
+	Description string `json:"description,omitempty"`
+}
+
+type Discharge struct {
+	// Reason of the discharge
+	Reason string `json:"reason"`
+}
+
+type Transfer struct {
+	// Bed ID to move the patient to
+	ToBedId string `json:"to_bed_id"`
+
+	// Reason of the transfer
+	Reason string `json:"reason,omitempty"`
+}
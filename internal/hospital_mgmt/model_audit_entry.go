@@ -0,0 +1,44 @@
+/*
+ * Hospital Management Api
+ *
+ * Hospital Management system for Web-In-Cloud
+ *
+ * API version: 1.0.0
+ * Contact: xsabol@stuba.sk
+ */
+
+package hospital_mgmt
+
+import "time"
+
+type AuditEntry struct {
+	// Unique identifier of the entry, ordered by the time of the write
+	Id string `json:"id" bson:"id"`
+
+	// Kind of the written document: department, bed or patient
+	Entity string `json:"entity" bson:"entity"`
+
+	// ID of the written document
+	EntityId string `json:"entity_id" bson:"entity_id"`
+
+	// ID of the hospital of the written document
+	HospitalId string `json:"hospital_id,omitempty" bson:"hospital_id,omitempty"`
+
+	// Kind of the write: create, update, delete or purge
+	Action string `json:"action" bson:"action"`
+
+	// Who made the request
+	Actor string `json:"actor" bson:"actor"`
+
+	// Method and route of the request, e.g. PUT /api/patients/:patientId
+	Route string `json:"route,omitempty" bson:"route,omitempty"`
+
+	// Time of the write
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+
+	// Previous values of the changed fields, keyed by their JSON name
+	Before map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"`
+
+	// New values of the changed fields, keyed by their JSON name
+	After map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`
+}
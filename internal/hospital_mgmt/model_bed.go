@@ -13,31 +13,40 @@ import "time"
 
 type BedStatus struct {
 	// Patient ID currently occupying the bed (if any)
-	PatientId string `json:"patient_id,omitempty"`
+	PatientId string `json:"patient_id,omitempty" bson:"patient_id,omitempty"`
 
 	// Description of the bed status
-	Description string `json:"description,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
 }
 
 type Bed struct {
 	// Unique identifier of the bed
-	Id string `json:"id"`
+	Id string `json:"id" bson:"id"`
+
+	// ID of the hospital the bed belongs to, set from the request
+	HospitalId string `json:"hospital_id" bson:"hospital_id"`
 
 	// Department ID where the bed is located
-	DepartmentId string `json:"department_id"`
+	DepartmentId string `json:"department_id" bson:"department_id"`
 
 	// Type of the bed (standard, ICU, etc.)
-	BedType string `json:"bed_type"`
+	BedType string `json:"bed_type" bson:"bed_type"`
 
 	// Quality rating of the bed (0.0 - 1.0)
-	BedQuality float64 `json:"bed_quality"`
+	BedQuality float64 `json:"bed_quality" bson:"bed_quality"`
 
 	// Current status of the bed
-	Status BedStatus `json:"status"`
+	Status BedStatus `json:"status" bson:"status"`
 
 	// Creation timestamp
-	CreatedAt time.Time `json:"created_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 
 	// Last update timestamp
-	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+
+	// Deletion timestamp, set while the document is deleted and can still be restored
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	// Version of the document, incremented on every write, used as the ETag
+	Version int64 `json:"version" bson:"version"`
 } 
\ No newline at end of file
@@ -0,0 +1,32 @@
+/*
+ * Hospital Management Api
+ *
+ * Hospital Management system for Web-In-Cloud
+ *
+ * API version: 1.0.0
+ * Contact: xsabol@stuba.sk
+ */
+
+package hospital_mgmt
+
+type CapacityDiscrepancy struct {
+	// ID of the department with wrong capacity counts
+	DepartmentId string `json:"department_id"`
+
+	// Capacity stored in the department document
+	Stored DepartmentCapacity `json:"stored"`
+
+	// Capacity computed from the beds collection
+	Actual DepartmentCapacity `json:"actual"`
+}
+
+type CapacityReconciliation struct {
+	// Number of checked departments
+	Checked int `json:"checked"`
+
+	// Departments whose stored capacity disagrees with the beds collection
+	Discrepancies []CapacityDiscrepancy `json:"discrepancies"`
+
+	// Whether the discrepancies were repaired
+	Repaired bool `json:"repaired"`
+}
@@ -13,34 +13,43 @@ import "time"
 
 type DepartmentCapacity struct {
 	// Maximum number of beds in the department
-	MaximumBeds int `json:"maximum_beds"`
+	MaximumBeds int `json:"maximum_beds" bson:"maximum_beds"`
 
 	// Actual number of beds available
-	ActualBeds int `json:"actual_beds"`
+	ActualBeds int `json:"actual_beds" bson:"actual_beds"`
 
 	// Number of currently occupied beds
-	OccupiedBeds int `json:"occupied_beds"`
+	OccupiedBeds int `json:"occupied_beds" bson:"occupied_beds"`
 }
 
 type Department struct {
 	// Unique identifier of the department
-	Id string `json:"id"`
+	Id string `json:"id" bson:"id"`
+
+	// ID of the hospital the department belongs to, set from the request
+	HospitalId string `json:"hospital_id" bson:"hospital_id"`
 
 	// Name of the department
-	Name string `json:"name"`
+	Name string `json:"name" bson:"name"`
 
 	// Description of the department
-	Description string `json:"description"`
+	Description string `json:"description" bson:"description"`
 
 	// Floor number where the department is located
-	Floor int `json:"floor"`
+	Floor int `json:"floor" bson:"floor"`
 
 	// Capacity information for the department
-	Capacity DepartmentCapacity `json:"capacity"`
+	Capacity DepartmentCapacity `json:"capacity" bson:"capacity"`
 
 	// Creation timestamp
-	CreatedAt time.Time `json:"created_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 
 	// Last update timestamp
-	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+
+	// Deletion timestamp, set while the document is deleted and can still be restored
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	// Version of the document, incremented on every write, used as the ETag
+	Version int64 `json:"version" bson:"version"`
 } 
\ No newline at end of file
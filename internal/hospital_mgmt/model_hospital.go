@@ -0,0 +1,32 @@
+/*
+ * Hospital Management Api
+ *
+ * Hospital Management system for Web-In-Cloud
+ *
+ * API version: 1.0.0
+ * Contact: xsabol@stuba.sk
+ */
+
+package hospital_mgmt
+
+import "time"
+
+type Hospital struct {
+	// Unique identifier of the hospital, selects its data in the requests
+	Id string `json:"id" bson:"id"`
+
+	// Name of the hospital
+	Name string `json:"name" bson:"name"`
+
+	// Postal address of the hospital
+	Address string `json:"address" bson:"address"`
+
+	// Creation timestamp
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+
+	// Last update timestamp
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+
+	// Version of the document, incremented on every write, used as the ETag
+	Version int64 `json:"version" bson:"version"`
+}
@@ -11,42 +11,112 @@ package hospital_mgmt
 
 import "time"
 
+type BedTransfer struct {
+	// Unique identifier of the transfer
+	Id string `json:"id" bson:"id"`
+
+	// ID of the hospitalization record the transfer belongs to
+	HospitalizationId string `json:"hospitalization_id" bson:"hospitalization_id"`
+
+	// Bed ID the patient was moved from
+	FromBedId string `json:"from_bed_id" bson:"from_bed_id"`
+
+	// Department ID the patient was moved from
+	FromDepartmentId string `json:"from_department_id" bson:"from_department_id"`
+
+	// Bed ID the patient was moved to
+	ToBedId string `json:"to_bed_id" bson:"to_bed_id"`
+
+	// Department ID the patient was moved to
+	ToDepartmentId string `json:"to_department_id" bson:"to_department_id"`
+
+	// Transfer timestamp
+	TransferredAt time.Time `json:"transferred_at" bson:"transferred_at"`
+
+	// Reason of the transfer
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+}
+
 type HospitalizationRecord struct {
 	// Unique identifier of the hospitalization record
-	Id string `json:"id"`
+	Id string `json:"id" bson:"id"`
 
 	// Description of the hospitalization
-	Description string `json:"description"`
+	Description string `json:"description" bson:"description"`
+
+	// Department ID where the patient is hospitalized
+	DepartmentId string `json:"department_id,omitempty" bson:"department_id,omitempty"`
+
+	// Bed ID assigned to the patient
+	BedId string `json:"bed_id,omitempty" bson:"bed_id,omitempty"`
+
+	// Admission timestamp
+	AdmittedAt *time.Time `json:"admitted_at,omitempty" bson:"admitted_at,omitempty"`
+
+	// Discharge timestamp, empty while the patient is hospitalized
+	DischargedAt *time.Time `json:"discharged_at,omitempty" bson:"discharged_at,omitempty"`
+
+	// Reason of the discharge
+	DischargeReason string `json:"discharge_reason,omitempty" bson:"discharge_reason,omitempty"`
+
+	// Bed transfers during the hospitalization, in chronological order
+	Transfers []BedTransfer `json:"transfers,omitempty" bson:"transfers,omitempty"`
+}
+
+type PatientSearchTerms struct {
+	// First name without diacritics in lowercase
+	FirstName string `bson:"first_name"`
+
+	// Last name without diacritics in lowercase
+	LastName string `bson:"last_name"`
+
+	// Email in lowercase
+	Email string `bson:"email"`
+
+	// Digits of the phone number
+	Phone string `bson:"phone"`
 }
 
 type Patient struct {
 	// Unique identifier of the patient
-	Id string `json:"id"`
+	Id string `json:"id" bson:"id"`
+
+	// ID of the hospital the patient belongs to, set from the request
+	HospitalId string `json:"hospital_id" bson:"hospital_id"`
 
 	// First name of the patient
-	FirstName string `json:"first_name"`
+	FirstName string `json:"first_name" bson:"first_name"`
 
 	// Last name of the patient
-	LastName string `json:"last_name"`
+	LastName string `json:"last_name" bson:"last_name"`
 
 	// Birth date of the patient
-	BirthDate string `json:"birth_date"`
+	BirthDate string `json:"birth_date" bson:"birth_date"`
 
 	// Gender of the patient (M/F/Other)
-	Gender string `json:"gender"`
+	Gender string `json:"gender" bson:"gender"`
 
 	// Phone number of the patient
-	Phone string `json:"phone,omitempty"`
+	Phone string `json:"phone,omitempty" bson:"phone,omitempty"`
 
 	// Email address of the patient
-	Email string `json:"email,omitempty"`
+	Email string `json:"email,omitempty" bson:"email,omitempty"`
 
 	// List of hospitalization records
-	HospitalizationRecords []HospitalizationRecord `json:"hospitalization_records,omitempty"`
+	HospitalizationRecords []HospitalizationRecord `json:"hospitalization_records,omitempty" bson:"hospitalization_records,omitempty"`
+
+	// Normalized fields for searching, maintained on every write
+	SearchTerms PatientSearchTerms `json:"-" bson:"search"`
 
 	// Creation timestamp
-	CreatedAt time.Time `json:"created_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 
 	// Last update timestamp
-	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+
+	// Deletion timestamp, set while the document is deleted and can still be restored
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	// Version of the document, incremented on every write, used as the ETag
+	Version int64 `json:"version" bson:"version"`
 } 
\ No newline at end of file
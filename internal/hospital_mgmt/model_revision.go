@@ -0,0 +1,41 @@
+/*
+ * Hospital Management Api
+ *
+ * Hospital Management system for Web-In-Cloud
+ *
+ * API version: 1.0.0
+ * Contact: xsabol@stuba.sk
+ */
+
+package hospital_mgmt
+
+import "time"
+
+type Revision[DocType interface{}] struct {
+	// Unique identifier of the revision, ordered by the time of the write
+	Id string `json:"id" bson:"id"`
+
+	// ID of the document
+	EntityId string `json:"entity_id" bson:"entity_id"`
+
+	// ID of the hospital the document belongs to
+	HospitalId string `json:"hospital_id,omitempty" bson:"hospital_id,omitempty"`
+
+	// Version of the document in the revision
+	Version int64 `json:"version" bson:"version"`
+
+	// Time since which the revision was the current state of the document
+	ValidFrom time.Time `json:"valid_from" bson:"valid_from"`
+
+	// The document was deleted by the write
+	Deleted bool `json:"deleted,omitempty" bson:"deleted,omitempty"`
+
+	// State of the document, missing if it was deleted
+	Document *DocType `json:"document,omitempty" bson:"document,omitempty"`
+}
+
+type DepartmentRevision = Revision[Department]
+
+type BedRevision = Revision[Bed]
+
+type PatientRevision = Revision[Patient]
@@ -0,0 +1,132 @@
+package hospital_mgmt
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// sortable fields of the list endpoints, the query uses the JSON names
+var (
+	departmentSortFields = []string{"id", "name", "floor", "created_at", "updated_at",
+		"capacity.maximum_beds", "capacity.actual_beds", "capacity.occupied_beds"}
+	bedSortFields     = []string{"id", "department_id", "bed_type", "bed_quality", "created_at", "updated_at"}
+	patientSortFields = []string{"id", "first_name", "last_name", "birth_date", "gender",
+		"created_at", "updated_at"}
+	hospitalSortFields = []string{"id", "name", "created_at", "updated_at"}
+)
+
+// pageQuery holds the paging query parameters of a list request
+type pageQuery struct {
+	page     int
+	pageSize int
+	sort     []db_service.SortField
+}
+
+// parsePageQuery reads `page`, `page_size` and `sort` query parameters, sort is a comma
+// separated list of fields from sortFields, a `-` prefix sorts descending
+func parsePageQuery(c *gin.Context, sortFields []string) (pageQuery, error) {
+	query := pageQuery{page: 1, pageSize: defaultPageSize}
+
+	if value := c.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return query, fmt.Errorf("page must be a positive integer")
+		}
+		query.page = page
+	}
+
+	if value := c.Query("page_size"); value != "" {
+		pageSize, err := strconv.Atoi(value)
+		if err != nil || pageSize < 1 || pageSize > maxPageSize {
+			return query, fmt.Errorf("page_size must be an integer between 1 and %d", maxPageSize)
+		}
+		query.pageSize = pageSize
+	}
+
+	// the offset of the page has to fit the int64 the databases skip by
+	if int64(query.page-1) > math.MaxInt64/int64(query.pageSize) {
+		return query, fmt.Errorf("page must be at most %d for page_size %d",
+			math.MaxInt64/int64(query.pageSize)+1, query.pageSize)
+	}
+
+	if value := c.Query("sort"); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			descending := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			if !isSortable(field, sortFields) {
+				return query, fmt.Errorf("cannot sort by %q, sortable fields: %s", field, strings.Join(sortFields, ", "))
+			}
+			query.sort = append(query.sort, db_service.SortField{Field: field, Descending: descending})
+		}
+	}
+	return query, nil
+}
+
+func isSortable(field string, sortFields []string) bool {
+	for _, sortable := range sortFields {
+		if field == sortable {
+			return true
+		}
+	}
+	return false
+}
+
+// offset is the number of documents before the page, parsePageQuery guarantees it does not overflow
+func (q pageQuery) offset() int64 {
+	return int64(q.page-1) * int64(q.pageSize)
+}
+
+func (q pageQuery) request(filter interface{}) db_service.PageRequest {
+	return db_service.PageRequest{
+		Filter: filter,
+		Sort:   q.sort,
+		Offset: q.offset(),
+		Limit:  int64(q.pageSize),
+	}
+}
+
+// respondPageQueryError writes 400 for invalid paging query parameters
+func respondPageQueryError(c *gin.Context, err error) {
+	respondProblem(c, http.StatusBadRequest, codeInvalidQuery, "Invalid query parameters, "+err.Error())
+}
+
+// writePageHeaders sets `X-Total-Count` and the RFC 8288 `Link` header
+// with first, prev, next and last pages
+func writePageHeaders(c *gin.Context, query pageQuery, totalCount int64) {
+	c.Header("X-Total-Count", strconv.FormatInt(totalCount, 10))
+
+	lastPage := int((totalCount + int64(query.pageSize) - 1) / int64(query.pageSize))
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	pageLink := func(page int, rel string) string {
+		url := *c.Request.URL
+		values := url.Query()
+		values.Set("page", strconv.Itoa(page))
+		values.Set("page_size", strconv.Itoa(query.pageSize))
+		url.RawQuery = values.Encode()
+		return fmt.Sprintf("<%s>; rel=\"%s\"", url.RequestURI(), rel)
+	}
+
+	links := []string{pageLink(1, "first")}
+	if query.page > 1 {
+		links = append(links, pageLink(min(query.page-1, lastPage), "prev"))
+	}
+	if query.page < lastPage {
+		links = append(links, pageLink(query.page+1, "next"))
+	}
+	links = append(links, pageLink(lastPage, "last"))
+	c.Header("Link", strings.Join(links, ", "))
+}
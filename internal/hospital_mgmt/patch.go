@@ -0,0 +1,145 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+// media types accepted by the PATCH endpoints
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+var (
+	errUnsupportedPatchType = errors.New("content type must be " + mergePatchContentType + " or " + jsonPatchContentType)
+	errPatchTestFailed      = errors.New("json patch test operation failed")
+)
+
+// invalidPatchError refuses a patch which cannot be parsed or applied to the document
+type invalidPatchError struct {
+	err error
+}
+
+func (e *invalidPatchError) Error() string {
+	return e.err.Error()
+}
+
+// detail describes the error to the client without the types of the service
+func (e *invalidPatchError) detail() string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(e.err, &typeErr) {
+		return "the patched document has a value of a wrong type in " + typeErr.Field
+	}
+	return e.err.Error()
+}
+
+// documentPatch is the body of a PATCH request, either RFC 7396 merge patch or RFC 6902 json patch
+type documentPatch struct {
+	contentType string
+	body        []byte
+	// validate checks the patched document against the api description, nil if the request
+	// is not validated
+	validate documentValidator
+}
+
+// readDocumentPatch reads the patch from the request body, the patch itself is parsed
+// only when it is applied to the stored document
+func readDocumentPatch(c *gin.Context) (documentPatch, error) {
+	patch := documentPatch{contentType: c.ContentType(), validate: requestDocumentValidator(c)}
+	if patch.contentType != mergePatchContentType && patch.contentType != jsonPatchContentType {
+		return patch, errUnsupportedPatchType
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		return patch, &invalidPatchError{err: err}
+	}
+	patch.body = body
+	return patch, nil
+}
+
+// applyDocumentPatch returns a copy of the document with the patch applied
+func applyDocumentPatch[DocType interface{}](patch documentPatch, document *DocType) (*DocType, error) {
+	original, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	switch patch.contentType {
+	case mergePatchContentType:
+		patched, err = jsonpatch.MergePatch(original, patch.body)
+	case jsonPatchContentType:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch.body)
+		if err == nil {
+			patched, err = operations.Apply(original)
+		}
+	default:
+		return nil, errUnsupportedPatchType
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return nil, errPatchTestFailed
+	}
+	if err != nil {
+		return nil, &invalidPatchError{err: err}
+	}
+	if patch.validate != nil {
+		if err := patch.validate(patched); err != nil {
+			return nil, err
+		}
+	}
+
+	result := new(DocType)
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, &invalidPatchError{err: err}
+	}
+	return result, nil
+}
+
+// saveChangedFields writes the next version of the document with only the fields which differ
+// between the stored and the patched document. The write is version checked, it fails with
+// db_service.ErrVersionMismatch if the document was modified since it has been read, even when
+// the concurrent update changed other fields - patches are not merged.
+func saveChangedFields[DocType interface{}, P versionedDocument[DocType]](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	before P,
+	after P,
+) error {
+	after.setDocumentVersion(before.documentVersion() + 1)
+	set, unset, err := db_service.DiffDocuments((*DocType)(before), (*DocType)(after))
+	if err != nil {
+		return err
+	}
+	return db.UpdateDocumentFieldsVersioned(ctx, before.documentId(), before.documentVersion(), set, unset)
+}
+
+// respondPatchError writes 415, 400 or 409 for errors of the patch document,
+// returns false for other errors
+func respondPatchError(c *gin.Context, err error) bool {
+	var invalidErr *invalidPatchError
+	var documentErr *documentValidationError
+	switch {
+	case err == errUnsupportedPatchType:
+		respondProblem(c, http.StatusUnsupportedMediaType, codeUnsupportedPatchType,
+			"Unsupported patch format, "+err.Error())
+	case errors.As(err, &invalidErr):
+		respondProblem(c, http.StatusBadRequest, codeInvalidPatch, "Invalid patch document, "+invalidErr.detail())
+	case errors.As(err, &documentErr):
+		respondFieldErrors(c, codeValidationFailed, "Patched document does not match the api description",
+			documentErr.errors)
+	case err == errPatchTestFailed:
+		respondProblem(c, http.StatusConflict, codePatchTestFailed, "Patch precondition does not hold")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+package hospital_mgmt
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maximal number of matching patients ranked by a single search
+const maxSearchCandidates = 1000
+
+// normalizeSearchText lowercases the text and strips diacritics, "Svobodová" becomes "svobodova"
+func normalizeSearchText(text string) string {
+	stripDiacritics := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	normalized, _, err := transform.String(stripDiacritics, text)
+	if err != nil {
+		normalized = text
+	}
+	return strings.ToLower(strings.TrimSpace(normalized))
+}
+
+// normalizePhone keeps only the digits of the phone number
+func normalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// UpdateSearchTerms recomputes the normalized search fields, it has to be called on every write
+func (p *Patient) UpdateSearchTerms() {
+	p.SearchTerms = PatientSearchTerms{
+		FirstName: normalizeSearchText(p.FirstName),
+		LastName:  normalizeSearchText(p.LastName),
+		Email:     normalizeSearchText(p.Email),
+		Phone:     normalizePhone(p.Phone),
+	}
+}
+
+// patientSearch holds the normalized criteria of a patient search
+type patientSearch struct {
+	terms     []string
+	birthDate string
+	phone     string
+	email     string
+}
+
+func parsePatientSearch(c *gin.Context) (patientSearch, error) {
+	search := patientSearch{
+		terms:     strings.Fields(normalizeSearchText(c.Query("q"))),
+		birthDate: strings.TrimSpace(c.Query("birth_date")),
+		phone:     normalizePhone(c.Query("phone")),
+		email:     normalizeSearchText(c.Query("email")),
+	}
+	if len(search.terms) == 0 && search.birthDate == "" && search.phone == "" && search.email == "" {
+		return search, fmt.Errorf("at least one of q, birth_date, phone or email is required")
+	}
+	return search, nil
+}
+
+func prefixMatch(prefix string) map[string]interface{} {
+	return map[string]interface{}{"$regex": "^" + regexp.QuoteMeta(prefix)}
+}
+
+// filter matches patients where every criterion matches a prefix of its field,
+// each term of q may match first name, last name or email
+func (s patientSearch) filter() map[string]interface{} {
+	conditions := []interface{}{}
+	for _, term := range s.terms {
+		conditions = append(conditions, map[string]interface{}{
+			"$or": []interface{}{
+				map[string]interface{}{"search.first_name": prefixMatch(term)},
+				map[string]interface{}{"search.last_name": prefixMatch(term)},
+				map[string]interface{}{"search.email": prefixMatch(term)},
+			},
+		})
+	}
+	if s.birthDate != "" {
+		conditions = append(conditions, map[string]interface{}{"birth_date": prefixMatch(s.birthDate)})
+	}
+	if s.phone != "" {
+		conditions = append(conditions, map[string]interface{}{"search.phone": prefixMatch(s.phone)})
+	}
+	if s.email != "" {
+		conditions = append(conditions, map[string]interface{}{"search.email": prefixMatch(s.email)})
+	}
+	return map[string]interface{}{"$and": conditions}
+}
+
+// score ranks exact name matches above prefix matches and name matches above email matches
+func (s patientSearch) score(patient *Patient) int {
+	score := 0
+	terms := patient.SearchTerms
+	for _, term := range s.terms {
+		switch {
+		case terms.LastName == term:
+			score += 4
+		case terms.FirstName == term:
+			score += 3
+		case strings.HasPrefix(terms.LastName, term):
+			score += 2
+		case strings.HasPrefix(terms.FirstName, term):
+			score += 2
+		default:
+			score += 1
+		}
+	}
+	if s.birthDate != "" && patient.BirthDate == s.birthDate {
+		score += 2
+	}
+	if s.phone != "" && terms.Phone == s.phone {
+		score += 2
+	}
+	if s.email != "" && terms.Email == s.email {
+		score += 2
+	}
+	return score
+}
+
+func (o *implPatientsAPI) SearchPatients(c *gin.Context) {
+	query, err := parsePageQuery(c, nil)
+	if err != nil {
+		respondPageQueryError(c, err)
+		return
+	}
+
+	search, err := parsePatientSearch(c)
+	if err != nil {
+		respondPageQueryError(c, err)
+		return
+	}
+
+	// ranking is done in memory over a bounded set of candidates
+	page, err := o.patients.FindDocumentsPage(c, db_service.PageRequest{
+		Filter: search.filter(),
+		Sort:   []db_service.SortField{{Field: "search.last_name"}, {Field: "search.first_name"}},
+		Limit:  maxSearchCandidates,
+	})
+	if err != nil {
+		respondDatabaseError(c, err, "Failed to search patients in database")
+		return
+	}
+	candidates := page.Documents
+
+	scores := make(map[string]int, len(candidates))
+	for _, patient := range candidates {
+		scores[patient.Id] = search.score(patient)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if scores[a.Id] != scores[b.Id] {
+			return scores[a.Id] > scores[b.Id]
+		}
+		if a.SearchTerms.LastName != b.SearchTerms.LastName {
+			return a.SearchTerms.LastName < b.SearchTerms.LastName
+		}
+		if a.SearchTerms.FirstName != b.SearchTerms.FirstName {
+			return a.SearchTerms.FirstName < b.SearchTerms.FirstName
+		}
+		return a.Id < b.Id
+	})
+
+	// pages are cut from the ranked candidates, the total count and the last page are bounded
+	// by maxSearchCandidates as well
+	offset := min(query.offset(), int64(len(candidates)))
+	end := min(offset+int64(query.pageSize), int64(len(candidates)))
+
+	writePageHeaders(c, query, int64(len(candidates)))
+	c.JSON(
+		http.StatusOK,
+		readablePatients(c, candidates[offset:end]),
+	)
+}
@@ -0,0 +1,281 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePatient(t *testing.T) {
+	api := newTestApi(t)
+
+	response := api.post("/api/patients", Patient{Id: "patient-1", FirstName: "Jana", LastName: "Nováková", BirthDate: "1980-01-01", Gender: "F"})
+	patient := decode[Patient](t, response, http.StatusCreated)
+	assert.Equal(t, "Nováková", patient.LastName)
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+
+	requireStatus(t, api.post("/api/patients", Patient{Id: "patient-1", BirthDate: "1980-01-01", Gender: "F"}), http.StatusConflict)
+	requireStatus(t, api.post("/api/patients", "{"), http.StatusBadRequest)
+
+	// closed records may be imported, an open admission may not
+	admittedAt := time.Now().Add(-48 * time.Hour)
+	dischargedAt := time.Now().Add(-24 * time.Hour)
+	requireStatus(t, api.post("/api/patients", Patient{
+		Id:                     "patient-2",
+		FirstName:              "Eva",
+		LastName:               "Kralova",
+		BirthDate:              "1980-01-01",
+		Gender:                 "F",
+		HospitalizationRecords: []HospitalizationRecord{{Id: "record-1", AdmittedAt: &admittedAt, DischargedAt: &dischargedAt}},
+	}), http.StatusCreated)
+	body := requireProblem(t, api.post("/api/patients", Patient{
+		Id:                     "patient-3",
+		FirstName:              "Eva",
+		LastName:               "Kralova",
+		BirthDate:              "1980-01-01",
+		Gender:                 "F",
+		HospitalizationRecords: []HospitalizationRecord{{Id: "record-1", AdmittedAt: &admittedAt, BedId: "bed-1"}},
+	}), http.StatusConflict, codeAdmissionWorkflow)
+	require.Len(t, body.Blockers, 1)
+	assert.Equal(t, "patient-3", body.Blockers[0].Id)
+}
+
+func TestGetPatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Novakova")
+
+	response := api.get("/api/patients/patient-1")
+	patient := decode[Patient](t, response, http.StatusOK)
+	assert.Equal(t, "Jana", patient.FirstName)
+	assert.Equal(t, `"1"`, response.Header().Get("ETag"))
+
+	requireStatus(t, api.get("/api/patients/missing"), http.StatusNotFound)
+}
+
+func TestGetPatients(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	api.createPatient("patient-3", "Anna", "Horvathova")
+
+	response := api.get("/api/patients?sort=first_name")
+	patients := decode[[]Patient](t, response, http.StatusOK)
+	assert.Equal(t, []string{"patient-3", "patient-2", "patient-1"}, patientIds(patients))
+	assert.Equal(t, "3", response.Header().Get("X-Total-Count"))
+
+	patients = decode[[]Patient](t, api.get("/api/patients?sort=-last_name&page=1&page_size=1"), http.StatusOK)
+	assert.Equal(t, []string{"patient-1"}, patientIds(patients))
+
+	requireStatus(t, api.get("/api/patients?page_size=501"), http.StatusBadRequest)
+}
+
+func TestSearchPatients(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Nováková")
+	api.createPatient("patient-2", "Novak", "Kral")
+	api.createPatient("patient-3", "Anna", "Horvathova")
+	requireStatus(t, api.mergePatch("/api/patients/patient-3", map[string]interface{}{
+		"phone": "+421 900 123 456",
+		"email": "Anna@Example.com",
+	}), http.StatusOK)
+
+	// diacritics are ignored, equal scores are ordered by last name
+	response := api.get("/api/patients/search?q=novakova")
+	assert.Equal(t, []string{"patient-1"}, patientIds(decode[[]Patient](t, response, http.StatusOK)))
+
+	response = api.get("/api/patients/search?q=nova")
+	assert.Equal(t, []string{"patient-2", "patient-1"}, patientIds(decode[[]Patient](t, response, http.StatusOK)))
+	assert.Equal(t, "2", response.Header().Get("X-Total-Count"))
+
+	// an exact first name ranks above a last name prefix
+	response = api.get("/api/patients/search?q=novak")
+	assert.Equal(t, []string{"patient-2", "patient-1"}, patientIds(decode[[]Patient](t, response, http.StatusOK)))
+
+	for query, expected := range map[string][]string{
+		"q=nova&page=2&page_size=1": {"patient-1"},
+		"q=nova&page=3&page_size=1": {},
+		"phone=%2B421+900":          {"patient-3"},
+		"email=anna@":               {"patient-3"},
+		"q=anna&birth_date=1980":    {"patient-3"},
+		"q=anna&birth_date=1990":    {},
+		"q=jana+anna":               {},
+		"q=.*":                      {},
+		"q=nova&page=4611686018427387904&page_size=2": {},
+	} {
+		patients := decode[[]Patient](t, api.get("/api/patients/search?"+query), http.StatusOK)
+		assert.Equal(t, expected, patientIds(patients), "query %q", query)
+	}
+
+	requireStatus(t, api.get("/api/patients/search"), http.StatusBadRequest)
+	requireStatus(t, api.get("/api/patients/search?q=nova&page=0"), http.StatusBadRequest)
+}
+
+func TestUpdatePatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	record := api.admit("patient-1", "bed-1")
+
+	// records are kept, they are changed by their own endpoints
+	response := api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/patients/patient-1",
+		body:    Patient{FirstName: "Jana", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F"},
+		ifMatch: `"2"`,
+	})
+	patient := decode[Patient](t, response, http.StatusOK)
+	assert.Equal(t, "Kralova", patient.LastName)
+	assert.Equal(t, `"3"`, response.Header().Get("ETag"))
+	require.Len(t, patient.HospitalizationRecords, 1)
+	assert.Equal(t, record.Id, patient.HospitalizationRecords[0].Id)
+
+	found := decode[[]Patient](t, api.get("/api/patients/search?q=kralova"), http.StatusOK)
+	assert.Equal(t, []string{"patient-1"}, patientIds(found))
+
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/patients/patient-1",
+		body:    Patient{FirstName: "Stale", BirthDate: "1980-01-01", Gender: "F"},
+		ifMatch: `"2"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.put("/api/patients/missing", Patient{FirstName: "Missing", BirthDate: "1980-01-01", Gender: "F"}), http.StatusNotFound)
+	requireStatus(t, api.put("/api/patients/patient-1", "{"), http.StatusBadRequest)
+}
+
+func TestPatchPatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+
+	patient := decode[Patient](t, api.mergePatch("/api/patients/patient-1", map[string]interface{}{
+		"phone": "0900 111 222",
+	}), http.StatusOK)
+	assert.Equal(t, "0900 111 222", patient.Phone)
+	assert.Equal(t, "Novakova", patient.LastName)
+
+	patient = decode[Patient](t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/patients/patient-1",
+		body:        `[{"op": "replace", "path": "/last_name", "value": "Kralova"}]`,
+		contentType: jsonPatchContentType,
+	}), http.StatusOK)
+	assert.Equal(t, "Kralova", patient.LastName)
+
+	api.admit("patient-1", "bed-1")
+	requireStatus(t, api.mergePatch("/api/patients/patient-1", map[string]interface{}{
+		"hospitalization_records": nil,
+	}), http.StatusConflict)
+	requireStatus(t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/patients/patient-1",
+		body:        map[string]interface{}{"gender": "M"},
+		contentType: mergePatchContentType,
+		ifMatch:     `"1"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.mergePatch("/api/patients/missing", map[string]interface{}{"gender": "M"}), http.StatusNotFound)
+}
+
+func TestDeletePatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	api.admit("patient-1", "bed-1")
+
+	body := requireProblem(t, api.delete("/api/patients/patient-1"), http.StatusConflict, codePatientOccupiesBeds)
+	assert.Equal(t, []Blocker{{Entity: "bed", Id: "bed-1", Reason: "bed is occupied by the patient"}}, body.Blockers)
+
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodDelete,
+		path:    "/api/patients/patient-2",
+		ifMatch: `"2"`,
+	}), http.StatusPreconditionFailed)
+	requireStatus(t, api.delete("/api/patients/patient-2"), http.StatusNoContent)
+	requireStatus(t, api.delete("/api/patients/patient-2"), http.StatusNotFound)
+}
+
+func TestDeletePatientCascade(t *testing.T) {
+	api := newTestApiWithPolicy(t, IntegrityPolicy{DepartmentBeds: DeleteRestrict, PatientBeds: DeleteCascade})
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.admit("patient-1", "bed-1")
+
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNoContent)
+	bed := decode[Bed](t, api.get("/api/beds/bed-1"), http.StatusOK)
+	assert.Empty(t, bed.Status.PatientId)
+	api.requireCapacity("surgery", 1, 0)
+}
+
+func TestHospitalizationRecords(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Novakova")
+
+	admittedAt := time.Now().Add(-48 * time.Hour)
+	dischargedAt := time.Now().Add(-24 * time.Hour)
+	record := decode[HospitalizationRecord](t, api.post("/api/patients/patient-1/hospitalizations", HospitalizationRecord{
+		Description:  "appendectomy",
+		AdmittedAt:   &admittedAt,
+		DischargedAt: &dischargedAt,
+	}), http.StatusCreated)
+	assert.NotEmpty(t, record.Id)
+
+	path := "/api/patients/patient-1/hospitalizations/" + record.Id
+	updated := decode[HospitalizationRecord](t, api.put(path, HospitalizationRecord{
+		Description:  "laparoscopic appendectomy",
+		AdmittedAt:   &admittedAt,
+		DischargedAt: &dischargedAt,
+	}), http.StatusOK)
+	assert.Equal(t, record.Id, updated.Id)
+	assert.Equal(t, "laparoscopic appendectomy", updated.Description)
+
+	// admissions are opened by the admit endpoint only
+	requireStatus(t, api.put(path, HospitalizationRecord{AdmittedAt: &admittedAt}), http.StatusConflict)
+	requireStatus(t, api.post("/api/patients/patient-1/hospitalizations", HospitalizationRecord{
+		AdmittedAt: &admittedAt,
+	}), http.StatusConflict)
+
+	requireStatus(t, api.post("/api/patients/missing/hospitalizations", HospitalizationRecord{}), http.StatusNotFound)
+	requireStatus(t, api.post("/api/patients/patient-1/hospitalizations", "{"), http.StatusBadRequest)
+	requireStatus(t, api.put("/api/patients/patient-1/hospitalizations/missing", HospitalizationRecord{}), http.StatusNotFound)
+	requireStatus(t, api.put("/api/patients/missing/hospitalizations/"+record.Id, HospitalizationRecord{}), http.StatusNotFound)
+
+	requireStatus(t, api.delete(path), http.StatusNoContent)
+	requireStatus(t, api.delete(path), http.StatusNotFound)
+	requireStatus(t, api.delete("/api/patients/missing/hospitalizations/"+record.Id), http.StatusNotFound)
+
+	patient := decode[Patient](t, api.get("/api/patients/patient-1"), http.StatusOK)
+	assert.Empty(t, patient.HospitalizationRecords)
+}
+
+func TestActiveHospitalizationRecord(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	record := api.admit("patient-1", "bed-1")
+
+	path := "/api/patients/patient-1/hospitalizations/" + record.Id
+	now := time.Now()
+	requireStatus(t, api.put(path, HospitalizationRecord{AdmittedAt: record.AdmittedAt, DischargedAt: &now}), http.StatusConflict)
+	requireStatus(t, api.delete(path), http.StatusConflict)
+
+	patient := decode[Patient](t, api.get("/api/patients/patient-1"), http.StatusOK)
+	require.Len(t, patient.HospitalizationRecords, 1)
+	assert.Nil(t, patient.HospitalizationRecords[0].DischargedAt)
+	api.requireCapacity("surgery", 1, 1)
+}
+
+func patientIds(patients []Patient) []string {
+	ids := []string{}
+	for _, patient := range patients {
+		ids = append(ids, patient.Id)
+	}
+	return ids
+}
@@ -0,0 +1,246 @@
+package hospital_mgmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/auth"
+)
+
+// roles of the users of the api, carried in the `roles` claim of their tokens
+const (
+	RoleDoctor = "doctor"
+	RoleNurse  = "nurse"
+	RoleClerk  = "clerk"
+	RoleAdmin  = "admin"
+)
+
+// permissions granted by the roles, declared as the oauth2 scopes of the operations in the api
+// description
+const (
+	permissionDepartmentsRead       = "departments:read"
+	permissionDepartmentsWrite      = "departments:write"
+	permissionBedsRead              = "beds:read"
+	permissionBedsWrite             = "beds:write"
+	permissionBedsStatus            = "beds:status"
+	permissionPatientsRead          = "patients:read"
+	permissionPatientsWrite         = "patients:write"
+	permissionHospitalizationsRead  = "hospitalizations:read"
+	permissionHospitalizationsWrite = "hospitalizations:write"
+	permissionAdmissionsWrite       = "admissions:write"
+	permissionAuditRead             = "audit:read"
+	permissionHospitalsRead         = "hospitals:read"
+	permissionHospitalsWrite        = "hospitals:write"
+)
+
+// rolePermissions is the permission matrix of the roles
+var rolePermissions = map[string][]string{
+	RoleAdmin: {
+		permissionDepartmentsRead, permissionDepartmentsWrite,
+		permissionBedsRead, permissionBedsWrite, permissionBedsStatus,
+		permissionPatientsRead, permissionPatientsWrite,
+		permissionHospitalizationsRead, permissionHospitalizationsWrite,
+		permissionAdmissionsWrite,
+		permissionAuditRead,
+		permissionHospitalsRead, permissionHospitalsWrite,
+	},
+	RoleDoctor: {
+		permissionDepartmentsRead,
+		permissionBedsRead,
+		permissionPatientsRead,
+		permissionHospitalizationsRead, permissionHospitalizationsWrite,
+		permissionAdmissionsWrite,
+	},
+	RoleNurse: {
+		permissionDepartmentsRead,
+		permissionBedsRead, permissionBedsStatus,
+		permissionPatientsRead,
+		permissionHospitalizationsRead,
+	},
+	RoleClerk: {
+		permissionDepartmentsRead,
+		permissionBedsRead,
+		permissionPatientsRead, permissionPatientsWrite,
+		permissionAdmissionsWrite,
+	},
+}
+
+// routePermissions names the permission needed by each route of getRoutes, routes missing here
+// are refused to everyone
+var routePermissions = map[string]string{
+	"CreateDepartment":            permissionDepartmentsWrite,
+	"GetDepartment":               permissionDepartmentsRead,
+	"GetDepartments":              permissionDepartmentsRead,
+	"UpdateDepartment":            permissionDepartmentsWrite,
+	"PatchDepartment":             permissionDepartmentsWrite,
+	"DeleteDepartment":            permissionDepartmentsWrite,
+	"RestoreDepartment":           permissionDepartmentsWrite,
+	"GetDepartmentHistory":        permissionDepartmentsRead,
+	"RestoreDepartmentRevision":   permissionDepartmentsWrite,
+	"ReconcileDepartmentCapacity": permissionDepartmentsWrite,
+
+	"CreateBed":           permissionBedsWrite,
+	"GetBed":              permissionBedsRead,
+	"GetBeds":             permissionBedsRead,
+	"GetBedsByDepartment": permissionBedsRead,
+	"UpdateBed":           permissionBedsWrite,
+	// without beds:write the patch may change only the status of the bed
+	"PatchBed":           permissionBedsStatus,
+	"DeleteBed":          permissionBedsWrite,
+	"RestoreBed":         permissionBedsWrite,
+	"GetBedHistory":      permissionBedsRead,
+	"RestoreBedRevision": permissionBedsWrite,
+
+	"CreatePatient":          permissionPatientsWrite,
+	"GetPatient":             permissionPatientsRead,
+	"GetPatients":            permissionPatientsRead,
+	"SearchPatients":         permissionPatientsRead,
+	"UpdatePatient":          permissionPatientsWrite,
+	"PatchPatient":           permissionPatientsWrite,
+	"DeletePatient":          permissionPatientsWrite,
+	"RestorePatient":         permissionPatientsWrite,
+	"GetPatientHistory":      permissionPatientsRead,
+	"RestorePatientRevision": permissionPatientsWrite,
+
+	"AddHospitalizationRecord":    permissionHospitalizationsWrite,
+	"UpdateHospitalizationRecord": permissionHospitalizationsWrite,
+	"DeleteHospitalizationRecord": permissionHospitalizationsWrite,
+	"GetPatientTransfers":         permissionHospitalizationsRead,
+	"AdmitPatient":                permissionAdmissionsWrite,
+	"DischargePatient":            permissionAdmissionsWrite,
+	"TransferPatient":             permissionAdmissionsWrite,
+
+	"GetAuditEntries": permissionAuditRead,
+
+	"CreateHospital": permissionHospitalsWrite,
+	"GetHospital":    permissionHospitalsRead,
+	"GetHospitals":   permissionHospitalsRead,
+	"UpdateHospital": permissionHospitalsWrite,
+	"DeleteHospital": permissionHospitalsWrite,
+}
+
+// context key of the permissions granted to the request
+const permissionsKey = "permissions"
+
+// NewAuthorizationMiddleware creates a gin middleware refusing requests to the routes of the api
+// with 403 Forbidden unless a role of the authenticated user grants the permission of the route.
+// It has to follow the authentication middleware, requests to other paths pass through.
+func NewAuthorizationMiddleware(handleFunctions ApiHandleFunctions) gin.HandlerFunc {
+	routeNames := map[string]string{}
+	for _, route := range getRoutes(handleFunctions) {
+		routeNames[route.Method+" "+route.Pattern] = route.Name
+	}
+
+	return func(c *gin.Context) {
+		name, ok := routeNames[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		granted := grantedPermissions(auth.Roles(c))
+		c.Set(permissionsKey, granted)
+		permission, ok := routePermissions[name]
+		if !ok || !granted[permission] {
+			reason := fmt.Sprintf("%s requires the %q permission", name, permission)
+			if !ok {
+				reason = fmt.Sprintf("%s is not permitted to any role", name)
+			}
+			respondProblem(c, http.StatusForbidden, codePermissionDenied, "Insufficient permissions, "+reason)
+			return
+		}
+		c.Next()
+	}
+}
+
+func grantedPermissions(roles []string) map[string]bool {
+	granted := map[string]bool{}
+	for _, role := range roles {
+		for _, permission := range rolePermissions[role] {
+			granted[permission] = true
+		}
+	}
+	return granted
+}
+
+// hasPermission returns whether the request is granted the permission, every permission is
+// granted if the api runs without authorization
+func hasPermission(c *gin.Context, permission string) bool {
+	value, ok := c.Get(permissionsKey)
+	if !ok {
+		return true
+	}
+	granted, _ := value.(map[string]bool)
+	return granted[permission]
+}
+
+var errBedStatusOnly = errors.New("only the status of the bed can be changed without the \"beds:write\" permission")
+
+// checkOnlyBedStatusChanged refuses a bed patch of a user allowed to change only the status of
+// beds which changes other fields too
+func checkOnlyBedStatusChanged(before *Bed, after *Bed) error {
+	unchanged := *after
+	unchanged.Status = before.Status
+	unchanged.UpdatedAt = before.UpdatedAt
+	unchanged.Version = before.Version
+	if !reflect.DeepEqual(&unchanged, before) {
+		return errBedStatusOnly
+	}
+	return nil
+}
+
+var errHospitalizationRecordsReadOnly = errors.New(
+	"hospitalization records cannot be changed without the \"hospitalizations:write\" permission")
+
+// checkHospitalizationRecordsUnchanged refuses a patient written by a user not allowed to write
+// hospitalization records which changes the records of the patient
+func checkHospitalizationRecordsUnchanged(before []HospitalizationRecord, after []HospitalizationRecord) error {
+	if len(before) == 0 && len(after) == 0 {
+		return nil
+	}
+	beforeJson, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	afterJson, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(beforeJson, afterJson) {
+		return errHospitalizationRecordsReadOnly
+	}
+	return nil
+}
+
+func respondRecordsNotPermitted(c *gin.Context) {
+	respondProblem(c, http.StatusForbidden, codeRecordsNotPermitted,
+		"Hospitalization records cannot be changed without the \"hospitalizations:write\" permission")
+}
+
+// readablePatient returns the patient as the request may read it, without the hospitalization
+// records unless the request is granted the "hospitalizations:read" permission. The patient
+// itself is never changed, it may be held by the cache.
+func readablePatient(c *gin.Context, patient *Patient) *Patient {
+	if hasPermission(c, permissionHospitalizationsRead) {
+		return patient
+	}
+	readable := *patient
+	readable.HospitalizationRecords = nil
+	return &readable
+}
+
+func readablePatients(c *gin.Context, patients []*Patient) []*Patient {
+	if hasPermission(c, permissionHospitalizationsRead) {
+		return patients
+	}
+	readable := make([]*Patient, len(patients))
+	for i, patient := range patients {
+		readable[i] = readablePatient(c, patient)
+	}
+	return readable
+}
@@ -0,0 +1,284 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	allRoles   = []string{RoleAdmin, RoleDoctor, RoleNurse, RoleClerk}
+	adminOnly  = []string{RoleAdmin}
+	routeParam = regexp.MustCompile(`:[a-zA-Z]+`)
+)
+
+// roles allowed to call each route
+var routeRoles = map[string][]string{
+	"CreateDepartment":            adminOnly,
+	"GetDepartment":               allRoles,
+	"GetDepartments":              allRoles,
+	"UpdateDepartment":            adminOnly,
+	"PatchDepartment":             adminOnly,
+	"DeleteDepartment":            adminOnly,
+	"RestoreDepartment":           adminOnly,
+	"GetDepartmentHistory":        allRoles,
+	"RestoreDepartmentRevision":   adminOnly,
+	"ReconcileDepartmentCapacity": adminOnly,
+
+	"CreateBed":           adminOnly,
+	"GetBed":              allRoles,
+	"GetBeds":             allRoles,
+	"GetBedsByDepartment": allRoles,
+	"UpdateBed":           adminOnly,
+	"PatchBed":            {RoleAdmin, RoleNurse},
+	"DeleteBed":           adminOnly,
+	"RestoreBed":          adminOnly,
+	"GetBedHistory":       allRoles,
+	"RestoreBedRevision":  adminOnly,
+
+	"CreatePatient":          {RoleAdmin, RoleClerk},
+	"GetPatient":             allRoles,
+	"GetPatients":            allRoles,
+	"SearchPatients":         allRoles,
+	"UpdatePatient":          {RoleAdmin, RoleClerk},
+	"PatchPatient":           {RoleAdmin, RoleClerk},
+	"DeletePatient":          {RoleAdmin, RoleClerk},
+	"RestorePatient":         {RoleAdmin, RoleClerk},
+	"GetPatientHistory":      allRoles,
+	"RestorePatientRevision": {RoleAdmin, RoleClerk},
+
+	"AddHospitalizationRecord":    {RoleAdmin, RoleDoctor},
+	"UpdateHospitalizationRecord": {RoleAdmin, RoleDoctor},
+	"DeleteHospitalizationRecord": {RoleAdmin, RoleDoctor},
+	"GetPatientTransfers":         {RoleAdmin, RoleDoctor, RoleNurse},
+	"AdmitPatient":                {RoleAdmin, RoleDoctor, RoleClerk},
+	"DischargePatient":            {RoleAdmin, RoleDoctor, RoleClerk},
+	"TransferPatient":             {RoleAdmin, RoleDoctor, RoleClerk},
+
+	"GetAuditEntries": adminOnly,
+
+	"CreateHospital": adminOnly,
+	"GetHospital":    adminOnly,
+	"GetHospitals":   adminOnly,
+	"UpdateHospital": adminOnly,
+	"DeleteHospital": adminOnly,
+}
+
+// TestRoutePermissions calls every route with a token of every role, the handlers are replaced
+// so only the authorization decides the response
+func TestRoutePermissions(t *testing.T) {
+	authenticate, err := auth.NewMiddleware(auth.Config{Secret: testAuthSecret})
+	require.NoError(t, err)
+	engine := gin.New()
+	engine.Use(authenticate, NewAuthorizationMiddleware(testHandleFunctions()))
+	routes := getRoutes(testHandleFunctions())
+	for _, route := range routes {
+		engine.Handle(route.Method, route.Pattern, func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+	}
+	call := func(route Route, roles ...string) int {
+		req := httptest.NewRequest(route.Method, routeParam.ReplaceAllString(route.Pattern, "id-1"), nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "user-1", roles...))
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	require.Len(t, routeRoles, len(routes))
+	for _, route := range routes {
+		t.Run(route.Name, func(t *testing.T) {
+			allowed, ok := routeRoles[route.Name]
+			require.True(t, ok, "route without expected roles")
+			for _, role := range allRoles {
+				expected := http.StatusForbidden
+				if contains(allowed, role) {
+					expected = http.StatusNoContent
+				}
+				assert.Equal(t, expected, call(route, role), role)
+			}
+			assert.Equal(t, http.StatusForbidden, call(route), "no role")
+			assert.Equal(t, http.StatusForbidden, call(route, "visitor"), "unknown role")
+			assert.Equal(t, http.StatusNoContent, call(route, "visitor", allowed[0]), "one of the roles")
+		})
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestForbiddenRequest(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+
+	body := requireProblem(t, api.do(testRequest{
+		method: http.MethodDelete,
+		path:   "/api/departments/surgery",
+		roles:  []string{RoleClerk},
+	}), http.StatusForbidden, codePermissionDenied)
+	assert.Contains(t, body.Detail, "departments:write")
+	requireStatus(t, api.get("/api/departments/surgery"), http.StatusOK)
+	assert.Len(t, api.auditEntries("entity=department"), 1)
+}
+
+// nurses change the status of beds, nothing else
+func TestPatchBedStatusOnly(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	patch := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		return api.do(testRequest{
+			method:      http.MethodPatch,
+			path:        "/api/beds/bed-1",
+			body:        body,
+			contentType: mergePatchContentType,
+			roles:       []string{RoleNurse},
+		})
+	}
+
+	patched := decode[Bed](t, patch(map[string]interface{}{
+		"status": map[string]interface{}{"description": "being cleaned"},
+	}), http.StatusOK)
+	assert.Equal(t, "being cleaned", patched.Status.Description)
+
+	requireStatus(t, patch(map[string]interface{}{"bed_quality": 0.2}), http.StatusForbidden)
+	requireStatus(t, patch(map[string]interface{}{
+		"status":        map[string]interface{}{"description": "ready"},
+		"department_id": "surgery-2",
+	}), http.StatusForbidden)
+	bed := decode[Bed](t, api.get("/api/beds/bed-1"), http.StatusOK)
+	assert.Equal(t, "being cleaned", bed.Status.Description)
+	assert.EqualValues(t, 2, bed.Version)
+}
+
+// clerks manage patients but not their hospitalization records
+func TestClerkHospitalizationRecordsReadOnly(t *testing.T) {
+	api := newTestApi(t)
+	admittedAt := time.Now().Add(-48 * time.Hour)
+	dischargedAt := time.Now().Add(-24 * time.Hour)
+	requireStatus(t, api.post("/api/patients", Patient{
+		Id:                     "patient-1",
+		FirstName:              "Jana",
+		LastName:               "Novakova",
+		BirthDate:              "1980-01-01",
+		Gender:                 "F",
+		HospitalizationRecords: []HospitalizationRecord{{Id: "record-1", AdmittedAt: &admittedAt, DischargedAt: &dischargedAt}},
+	}), http.StatusCreated)
+	clerk := func(request testRequest) *httptest.ResponseRecorder {
+		request.roles = []string{RoleClerk}
+		return api.do(request)
+	}
+
+	requireProblem(t, clerk(testRequest{method: http.MethodPost, path: "/api/patients", body: Patient{
+		Id:                     "patient-2",
+		FirstName:              "Eva",
+		LastName:               "Kralova",
+		BirthDate:              "1980-01-01",
+		Gender:                 "F",
+		HospitalizationRecords: []HospitalizationRecord{{Id: "record-1", AdmittedAt: &admittedAt, DischargedAt: &dischargedAt}},
+	}}), http.StatusForbidden, codeRecordsNotPermitted)
+	requireStatus(t, api.get("/api/patients/patient-2"), http.StatusNotFound)
+	requireStatus(t, clerk(testRequest{method: http.MethodPost, path: "/api/patients", body: Patient{
+		Id: "patient-2", FirstName: "Eva", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F",
+	}}), http.StatusCreated)
+
+	for name, patch := range map[string]testRequest{
+		"delete closed records": {body: map[string]interface{}{"hospitalization_records": nil}, contentType: mergePatchContentType},
+		"rewrite closed record": {
+			body:        `[{"op": "replace", "path": "/hospitalization_records/0/description", "value": "surgery"}]`,
+			contentType: jsonPatchContentType,
+		},
+		"add record": {
+			body:        `[{"op": "add", "path": "/hospitalization_records/-", "value": {"id": "record-2", "description": "surgery"}}]`,
+			contentType: jsonPatchContentType,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			patch.method = http.MethodPatch
+			patch.path = "/api/patients/patient-1"
+			requireProblem(t, clerk(patch), http.StatusForbidden, codeRecordsNotPermitted)
+		})
+	}
+
+	patched := decode[Patient](t, clerk(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/patients/patient-1",
+		body:        map[string]interface{}{"phone": "+421900000000"},
+		contentType: mergePatchContentType,
+	}), http.StatusOK)
+	assert.Equal(t, "+421900000000", patched.Phone)
+	patient := decode[Patient](t, api.get("/api/patients/patient-1"), http.StatusOK)
+	require.Len(t, patient.HospitalizationRecords, 1)
+	assert.Empty(t, patient.HospitalizationRecords[0].Description)
+	assert.EqualValues(t, 2, patient.Version)
+}
+
+// clerks read patients without their hospitalization records
+func TestClerkHospitalizationRecordsHidden(t *testing.T) {
+	api := newTestApi(t)
+	admittedAt := time.Now().Add(-48 * time.Hour)
+	dischargedAt := time.Now().Add(-24 * time.Hour)
+	requireStatus(t, api.post("/api/patients", Patient{
+		Id:                     "patient-1",
+		FirstName:              "Jana",
+		LastName:               "Novakova",
+		BirthDate:              "1980-01-01",
+		Gender:                 "F",
+		HospitalizationRecords: []HospitalizationRecord{{Id: "record-1", AdmittedAt: &admittedAt, DischargedAt: &dischargedAt}},
+	}), http.StatusCreated)
+	get := func(path string, role string) *httptest.ResponseRecorder {
+		return api.do(testRequest{method: http.MethodGet, path: path, roles: []string{role}})
+	}
+
+	asOf := url.QueryEscape(time.Now().Format(time.RFC3339Nano))
+	for _, path := range []string{"/api/patients/patient-1", "/api/patients/patient-1?asOf=" + asOf} {
+		t.Run(path, func(t *testing.T) {
+			assert.Empty(t, decode[Patient](t, get(path, RoleClerk), http.StatusOK).HospitalizationRecords)
+			assert.Len(t, decode[Patient](t, get(path, RoleNurse), http.StatusOK).HospitalizationRecords, 1)
+		})
+	}
+	for _, path := range []string{"/api/patients", "/api/patients/search?q=novakova"} {
+		t.Run(path, func(t *testing.T) {
+			patients := decode[[]Patient](t, get(path, RoleClerk), http.StatusOK)
+			require.Len(t, patients, 1)
+			assert.Empty(t, patients[0].HospitalizationRecords)
+			patients = decode[[]Patient](t, get(path, RoleNurse), http.StatusOK)
+			require.Len(t, patients, 1)
+			assert.Len(t, patients[0].HospitalizationRecords, 1)
+		})
+	}
+	t.Run("history", func(t *testing.T) {
+		path := historyPath("/api/patients/patient-1")
+		revisions := decode[[]PatientRevision](t, get(path, RoleClerk), http.StatusOK)
+		require.Len(t, revisions, 1)
+		assert.Empty(t, revisions[0].Document.HospitalizationRecords)
+		revisions = decode[[]PatientRevision](t, get(path, RoleNurse), http.StatusOK)
+		require.Len(t, revisions, 1)
+		assert.Len(t, revisions[0].Document.HospitalizationRecords, 1)
+	})
+
+	// the patients written by clerks are answered without the records too, which are kept
+	patched := decode[Patient](t, api.do(testRequest{
+		method:      http.MethodPatch,
+		path:        "/api/patients/patient-1",
+		body:        map[string]interface{}{"phone": "+421900000000"},
+		contentType: mergePatchContentType,
+		roles:       []string{RoleClerk},
+	}), http.StatusOK)
+	assert.Empty(t, patched.HospitalizationRecords)
+	assert.Len(t, decode[Patient](t, api.get("/api/patients/patient-1"), http.StatusOK).HospitalizationRecords, 1)
+}
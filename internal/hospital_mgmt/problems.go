@@ -0,0 +1,116 @@
+package hospital_mgmt
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+)
+
+// codes of the problems the requests fail with, clients branch on them so they never change
+// once released, new ones are only added
+const (
+	codeInvalidRequestBody = "INVALID_REQUEST_BODY"
+	codeValidationFailed   = "VALIDATION_FAILED"
+	codeInvalidQuery       = "INVALID_QUERY"
+
+	codeDepartmentNotFound = "DEPARTMENT_NOT_FOUND"
+	codeBedNotFound        = "BED_NOT_FOUND"
+	codePatientNotFound    = "PATIENT_NOT_FOUND"
+	codeHospitalNotFound   = "HOSPITAL_NOT_FOUND"
+	codeRevisionNotFound   = "REVISION_NOT_FOUND"
+	codeRecordNotFound     = "HOSPITALIZATION_RECORD_NOT_FOUND"
+
+	codeDepartmentExists = "DEPARTMENT_ALREADY_EXISTS"
+	codeBedExists        = "BED_ALREADY_EXISTS"
+	codePatientExists    = "PATIENT_ALREADY_EXISTS"
+	codeHospitalExists   = "HOSPITAL_ALREADY_EXISTS"
+
+	codeBedOccupied            = "BED_OCCUPIED"
+	codePatientAlreadyAdmitted = "PATIENT_ALREADY_ADMITTED"
+	codePatientNotAdmitted     = "PATIENT_NOT_ADMITTED"
+	codeSameBed                = "PATIENT_ALREADY_IN_BED"
+	codeDepartmentFull         = "DEPARTMENT_FULL"
+	codeMaximumBelowActual     = "MAXIMUM_BELOW_ACTUAL_BEDS"
+
+	codeMissingReference     = "MISSING_REFERENCE"
+	codeAdmissionWorkflow    = "ADMISSION_WORKFLOW_REQUIRED"
+	codeDepartmentHasBeds    = "DEPARTMENT_HAS_BEDS"
+	codePatientOccupiesBeds  = "PATIENT_OCCUPIES_BEDS"
+	codeHospitalHasDocuments = "HOSPITAL_HAS_DOCUMENTS"
+
+	codePreconditionFailed     = "PRECONDITION_FAILED"
+	codeConcurrentModification = "CONCURRENT_MODIFICATION"
+	codeNotDeleted             = "NOT_DELETED"
+	codeRevisionNotRestorable  = "REVISION_NOT_RESTORABLE"
+
+	codeUnsupportedPatchType = "UNSUPPORTED_PATCH_TYPE"
+	codeInvalidPatch         = "INVALID_PATCH"
+	codePatchTestFailed      = "PATCH_TEST_FAILED"
+
+	codePermissionDenied    = "PERMISSION_DENIED"
+	codeBedStatusOnly       = "BED_STATUS_ONLY"
+	codeRecordsNotPermitted = "HOSPITALIZATION_RECORDS_NOT_PERMITTED"
+
+	codeHospitalRequired     = "HOSPITAL_REQUIRED"
+	codeHospitalNotPermitted = "HOSPITAL_NOT_PERMITTED"
+	codeHospitalUnbound      = "HOSPITAL_UNBOUND_TOKEN"
+	codeHospitalBound        = "HOSPITAL_BOUND_TOKEN"
+	codeHospitalPathMismatch = "HOSPITAL_PATH_MISMATCH"
+
+	codeDatabaseTimeout     = "DATABASE_TIMEOUT"
+	codeDatabaseUnavailable = "DATABASE_UNAVAILABLE"
+)
+
+// databaseRetryAfter is the Retry-After of the responses failed by an unavailable database, in seconds
+const databaseRetryAfter = "5"
+
+// integrityProblem lists the documents which block the operation
+type integrityProblem struct {
+	problem.Problem
+	Blockers []Blocker `json:"blockers"`
+}
+
+// respondProblem aborts the request with the problem of the status and code, the detail
+// is written for the client and never contains errors of the database
+func respondProblem(c *gin.Context, status int, code string, detail string) {
+	problem.Respond(c, problem.New(status, code, detail))
+}
+
+// respondDatabaseError aborts the request failed by an unexpected error, mostly of the database.
+// A database which cannot be reached is 503 and the request can be retried, a database which does
+// not answer in time is 504, anything else is 500. The error is logged, the client gets the detail.
+func respondDatabaseError(c *gin.Context, err error, detail string) {
+	switch {
+	case db_service.IsUnavailable(err):
+		c.Header("Retry-After", databaseRetryAfter)
+		problem.RespondError(c, err, problem.New(http.StatusServiceUnavailable, codeDatabaseUnavailable,
+			detail+", the database is unavailable"))
+	case db_service.IsTimeout(err):
+		problem.RespondError(c, err, problem.New(http.StatusGatewayTimeout, codeDatabaseTimeout,
+			detail+", the database did not respond in time"))
+	default:
+		problem.RespondError(c, err, problem.New(http.StatusInternalServerError, problem.CodeInternalError, detail))
+	}
+}
+
+// respondInvalidBody aborts the request whose body cannot be decoded, the detail names
+// the field of a wrong type but not the types of the service
+func respondInvalidBody(c *gin.Context, err error) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	detail := "Invalid request body"
+	switch {
+	case errors.Is(err, io.EOF):
+		detail = "Request body is empty"
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		detail = "Request body is not valid JSON"
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		detail = "Request body has a value of a wrong type in " + typeErr.Field
+	}
+	respondProblem(c, http.StatusBadRequest, codeInvalidRequestBody, detail)
+}
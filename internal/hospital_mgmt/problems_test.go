@@ -0,0 +1,182 @@
+package hospital_mgmt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingDbService fails the reads of the documents with err
+type failingDbService[DocType interface{}] struct {
+	db_service.DbService[DocType]
+	err error
+}
+
+func (s *failingDbService[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	return nil, s.err
+}
+
+func (s *failingDbService[DocType]) FindDocumentsPage(
+	ctx context.Context,
+	request db_service.PageRequest,
+) (*db_service.Page[DocType], error) {
+	return nil, s.err
+}
+
+func TestProblemCodes(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	api.admit("patient-1", "bed-1")
+
+	requireProblem(t, api.get("/api/patients/missing"), http.StatusNotFound, codePatientNotFound)
+	requireProblem(t, api.post("/api/departments", Department{Id: "surgery"}), http.StatusConflict, codeDepartmentExists)
+	requireProblem(t, api.post("/api/patients/patient-2/admissions", Admission{BedId: "bed-1"}),
+		http.StatusConflict, codeBedOccupied)
+	requireProblem(t, api.post("/api/patients/patient-1/admissions", Admission{BedId: "bed-1"}),
+		http.StatusConflict, codePatientAlreadyAdmitted)
+	requireProblem(t, api.post("/api/patients/patient-2/discharge", Discharge{}),
+		http.StatusConflict, codePatientNotAdmitted)
+	requireProblem(t, api.post("/api/patients/patient-2/admissions", Admission{}),
+		http.StatusBadRequest, codeValidationFailed)
+	requireProblem(t, api.get("/api/beds?page=0"), http.StatusBadRequest, codeInvalidQuery)
+	requireProblem(t, api.do(testRequest{
+		method:  http.MethodPut,
+		path:    "/api/patients/patient-2",
+		body:    Patient{FirstName: "Eva", LastName: "Kralova", BirthDate: "1980-01-01", Gender: "F"},
+		ifMatch: versionETag(7),
+	}), http.StatusPreconditionFailed, codePreconditionFailed)
+	requireProblem(t, api.do(testRequest{
+		method:    http.MethodGet,
+		path:      "/api/patients",
+		anonymous: true,
+	}), http.StatusUnauthorized, "TOKEN_MISSING")
+
+	// the errors tell what is wrong with the body without the types of the service
+	body := requireProblem(t, api.post("/api/departments", `{"name": 7, "floor": 1}`), http.StatusBadRequest, codeValidationFailed)
+	assert.Equal(t, []FieldError{{Location: "body", Field: "/name", Message: "value must be a string"}}, body.Errors)
+	body = requireProblem(t, api.post("/api/departments", `{"name": `), http.StatusBadRequest, codeInvalidRequestBody)
+	assert.Equal(t, "Request body is not valid JSON", body.Detail)
+}
+
+// failures of the database are told apart by the status, their errors are logged with the trace
+// of the request and never sent to the client
+func TestDatabaseErrorProblems(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	t.Cleanup(func() { log.Logger = logger })
+
+	spans := tracetest.NewSpanRecorder()
+	provider := tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(spans))
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{
+			name:   "timeout",
+			err:    fmt.Errorf("find bed-1: %w", context.DeadlineExceeded),
+			status: http.StatusGatewayTimeout,
+			code:   codeDatabaseTimeout,
+		},
+		{
+			name:   "unavailable",
+			err:    &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect 10.0.0.7:27017: connection refused")},
+			status: http.StatusServiceUnavailable,
+			code:   codeDatabaseUnavailable,
+		},
+		{
+			name:   "other",
+			err:    errors.New("(Unauthorized) command find requires authentication"),
+			status: http.StatusInternalServerError,
+			code:   problem.CodeInternalError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.Reset()
+			engine := gin.New()
+			engine.Use(otelgin.Middleware("hospital-mgmt-test", otelgin.WithTracerProvider(provider)))
+			handleFunctions := testHandleFunctions()
+			handleFunctions.BedsAPI = NewBedsAPI(&failingDbService[Bed]{err: tc.err}, nil, nil, nil)
+			NewRouterWithGinEngine(engine, handleFunctions)
+
+			for _, path := range []string{"/api/beds/bed-1", "/api/beds"} {
+				recorder := httptest.NewRecorder()
+				engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+				body := requireProblem(t, recorder, tc.status, tc.code)
+				assert.NotContains(t, recorder.Body.String(), tc.err.Error())
+				assert.Equal(t, path, body.Instance)
+
+				ended := spans.Ended()
+				traceId := ended[len(ended)-1].SpanContext().TraceID().String()
+				assert.Equal(t, traceId, body.TraceId)
+				assert.Contains(t, logs.String(), tc.err.Error())
+				assert.Contains(t, logs.String(), traceId)
+
+				if tc.status == http.StatusServiceUnavailable {
+					assert.Equal(t, databaseRetryAfter, recorder.Header().Get("Retry-After"))
+				} else {
+					assert.Empty(t, recorder.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+// responses outside of traces carry no trace id
+func TestProblemWithoutTrace(t *testing.T) {
+	api := newTestApi(t)
+	response := api.get("/api/beds/missing")
+	body := requireProblem(t, response, http.StatusNotFound, codeBedNotFound)
+	assert.Empty(t, body.TraceId)
+	require.NotContains(t, response.Body.String(), "trace_id")
+}
+
+// bodies which cannot be decoded are answered with the problem by the handlers themselves,
+// also without the validation of the requests
+func TestInvalidBodyProblems(t *testing.T) {
+	engine := gin.New()
+	NewRouterWithGinEngine(engine, testHandleFunctions())
+
+	for _, tc := range []struct {
+		path   string
+		body   string
+		detail string
+	}{
+		{path: "/api/beds", body: `{"id": `, detail: "Request body is not valid JSON"},
+		{path: "/api/departments", body: `{"floor": "first"}`, detail: "Request body has a value of a wrong type in floor"},
+		{path: "/api/patients/patient-1/admissions", body: "", detail: "Request body is empty"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body))
+			request.Header.Set("Content-Type", "application/json")
+			engine.ServeHTTP(recorder, request)
+			body := requireProblem(t, recorder, http.StatusBadRequest, codeInvalidRequestBody)
+			assert.Equal(t, tc.detail, body.Detail)
+			// the headers as they were sent, before the handler returned
+			assert.Equal(t, problem.ContentType, recorder.Result().Header.Get("Content-Type"))
+		})
+	}
+}
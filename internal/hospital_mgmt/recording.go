@@ -0,0 +1,99 @@
+package hospital_mgmt
+
+import (
+	"context"
+
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+// recordingSvc passes every write of the wrapped db service to record together with the
+// document before and after the write, nil if it does not exist. The write and the record
+// share a transaction, an error of record rolls back the write.
+type recordingSvc[DocType interface{}] struct {
+	db_service.DbService[DocType]
+	record func(ctx context.Context, id string, before *DocType, after *DocType) error
+}
+
+func (m *recordingSvc[DocType]) write(ctx context.Context, id string, fn func(ctx context.Context) error) error {
+	return m.DbService.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := findIfExists(ctx, m.DbService, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(ctx); err != nil {
+			return err
+		}
+		after, err := findIfExists(ctx, m.DbService, id)
+		if err != nil {
+			return err
+		}
+		return m.record(ctx, id, before, after)
+	})
+}
+
+// findIfExists returns the document or nil if it does not exist
+func findIfExists[DocType interface{}](ctx context.Context, db db_service.DbService[DocType], id string) (*DocType, error) {
+	document, err := db.FindDocument(ctx, id)
+	if err == db_service.ErrNotFound {
+		return nil, nil
+	}
+	return document, err
+}
+
+func (m *recordingSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	return m.write(ctx, id, func(ctx context.Context) error {
+		return m.DbService.CreateDocument(ctx, id, document)
+	})
+}
+
+func (m *recordingSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	return m.write(ctx, id, func(ctx context.Context) error {
+		return m.DbService.UpdateDocument(ctx, id, document)
+	})
+}
+
+func (m *recordingSvc[DocType]) UpdateDocumentFields(
+	ctx context.Context,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.write(ctx, id, func(ctx context.Context) error {
+		return m.DbService.UpdateDocumentFields(ctx, id, set, unset)
+	})
+}
+
+func (m *recordingSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return m.write(ctx, id, func(ctx context.Context) error {
+		return m.DbService.DeleteDocument(ctx, id)
+	})
+}
+
+func (m *recordingSvc[DocType]) UpdateDocumentVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	document *DocType,
+) error {
+	return m.write(ctx, id, func(ctx context.Context) error {
+		return m.DbService.UpdateDocumentVersioned(ctx, id, expectedVersion, document)
+	})
+}
+
+func (m *recordingSvc[DocType]) UpdateDocumentFieldsVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	return m.write(ctx, id, func(ctx context.Context) error {
+		return m.DbService.UpdateDocumentFieldsVersioned(ctx, id, expectedVersion, set, unset)
+	})
+}
+
+func (m *recordingSvc[DocType]) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	return m.write(ctx, id, func(ctx context.Context) error {
+		return m.DbService.DeleteDocumentVersioned(ctx, id, expectedVersion)
+	})
+}
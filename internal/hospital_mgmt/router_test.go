@@ -0,0 +1,415 @@
+package hospital_mgmt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	openapi "github.com/psabol571/sarsabsim-webapi/api"
+	"github.com/psabol571/sarsabsim-webapi/internal/auth"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routes requested by the tests, keyed by method and pattern
+var coveredRoutes sync.Map
+
+// TestMain fails a full run of the package which leaves a route without a request,
+// runs selecting tests by -run or -skip are not checked
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	flag.Parse()
+
+	code := m.Run()
+	fullRun := flag.Lookup("test.run").Value.String() == "" && flag.Lookup("test.skip").Value.String() == ""
+	if code == 0 && fullRun {
+		var missing []string
+		for _, route := range getRoutes(testHandleFunctions()) {
+			if _, ok := coveredRoutes.Load(route.Method + " " + route.Pattern); !ok {
+				missing = append(missing, route.Name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			fmt.Printf("routes without tests: %s\n", strings.Join(missing, ", "))
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// testHandleFunctions returns handlers without db services, for the routes of the api only
+func testHandleFunctions() ApiHandleFunctions {
+	return ApiHandleFunctions{
+		DepartmentsAPI: NewDepartmentsAPI(nil, nil, nil, DefaultIntegrityPolicy),
+		BedsAPI:        NewBedsAPI(nil, nil, nil, nil),
+		PatientsAPI:    NewPatientsAPI(nil, nil, nil, nil, DefaultIntegrityPolicy),
+		AuditAPI:       NewAuditAPI(nil),
+		HospitalsAPI:   NewHospitalsAPI(nil, nil, nil, nil),
+	}
+}
+
+// every test api gets its own memory database
+var testApiCount atomic.Int64
+
+// testApi serves the routes over memory db services the way the service binary wires them
+type testApi struct {
+	t      *testing.T
+	engine *gin.Engine
+	// handler serves the engine behind the hospital path prefix as cmd/ambulance-api-service does
+	handler             http.Handler
+	hospitals           db_service.DbService[Hospital]
+	departments         db_service.DbService[Department]
+	beds                db_service.DbService[Bed]
+	patients            db_service.DbService[Patient]
+	audit               db_service.DbService[AuditEntry]
+	departmentRevisions db_service.DbService[DepartmentRevision]
+	bedRevisions        db_service.DbService[BedRevision]
+	patientRevisions    db_service.DbService[PatientRevision]
+	// collections beneath the soft delete, as purged by cmd/ambulance-api-service
+	departmentStore db_service.DbService[Department]
+	bedStore        db_service.DbService[Bed]
+	patientStore    db_service.DbService[Patient]
+}
+
+func newTestApi(t *testing.T) *testApi {
+	return newTestApiWithPolicy(t, DefaultIntegrityPolicy)
+}
+
+// cached puts the collection behind a cache as cmd/ambulance-api-service does, reads made after
+// writes through the api must not see stale results
+func cached[DocType interface{}](db db_service.DbService[DocType]) db_service.DbService[DocType] {
+	return db_service.NewCachedService(db, db_service.CacheConfig{TTL: time.Hour})
+}
+
+// recorded scopes the documents of the collection to the hospital of the request, soft deletes
+// them, keeps their revisions and records their writes in the audit log as
+// cmd/ambulance-api-service does
+func recorded[DocType interface{}, P interface {
+	historyDocument[DocType]
+	softDeletable[DocType]
+	tenantDocument[DocType]
+}](
+	store db_service.DbService[DocType],
+	revisions db_service.DbService[Revision[DocType]],
+	audit db_service.DbService[AuditEntry],
+	entity string,
+) db_service.DbService[DocType] {
+	return NewAuditedService(
+		NewHistoryService[DocType, P](NewSoftDeleteService[DocType, P](NewTenantScopedService[DocType, P](store)), revisions),
+		audit,
+		entity,
+	)
+}
+
+func newTestApiWithPolicy(t *testing.T, policy IntegrityPolicy) *testApi {
+	dbName := fmt.Sprintf("hospital-mgmt-test-%d", testApiCount.Add(1))
+	collection := func(name string) db_service.MemoryServiceConfig {
+		return db_service.MemoryServiceConfig{DbName: dbName, Collection: name}
+	}
+	api := &testApi{
+		t:                   t,
+		engine:              gin.New(),
+		hospitals:           db_service.NewMemoryService[Hospital](collection("hospitals")),
+		audit:               NewAppendOnlyService(NewTenantScopedService(db_service.NewMemoryService[AuditEntry](collection("audit")))),
+		departmentRevisions: NewTenantScopedService(db_service.NewMemoryService[DepartmentRevision](collection("department_revisions"))),
+		bedRevisions:        NewTenantScopedService(db_service.NewMemoryService[BedRevision](collection("bed_revisions"))),
+		patientRevisions:    NewTenantScopedService(db_service.NewMemoryService[PatientRevision](collection("patient_revisions"))),
+	}
+	api.departmentStore = cached(db_service.NewMemoryService[Department](collection("departments")))
+	api.bedStore = cached(db_service.NewMemoryService[Bed](collection("beds")))
+	api.patientStore = cached(db_service.NewMemoryService[Patient](collection("patients")))
+	api.departments = recorded(api.departmentStore, api.departmentRevisions, api.audit, AuditEntityDepartment)
+	api.beds = recorded(api.bedStore, api.bedRevisions, api.audit, AuditEntityBed)
+	api.patients = recorded(api.patientStore, api.patientRevisions, api.audit, AuditEntityPatient)
+	require.NoError(t, api.hospitals.CreateDocument(context.Background(), testHospital, &Hospital{
+		Id:      testHospital,
+		Name:    "Test Hospital",
+		Version: 1,
+	}))
+
+	// same middlewares as set up by cmd/ambulance-api-service
+	authenticate, err := auth.NewMiddleware(auth.Config{Secret: testAuthSecret})
+	require.NoError(t, err)
+	handleFunctions := ApiHandleFunctions{
+		DepartmentsAPI: NewDepartmentsAPI(api.departments, api.beds, api.departmentRevisions, policy),
+		BedsAPI:        NewBedsAPI(api.beds, api.departments, api.patients, api.bedRevisions),
+		PatientsAPI:    NewPatientsAPI(api.patients, api.beds, api.departments, api.patientRevisions, policy),
+		AuditAPI:       NewAuditAPI(api.audit),
+		HospitalsAPI:   NewHospitalsAPI(api.hospitals, api.departments, api.beds, api.patients),
+	}
+	// the responses are validated as in debug mode, a drift from the api description fails the test
+	validate, err := NewValidationMiddleware(handleFunctions, ValidationConfig{
+		Spec:              openapi.OpenApiSpec(),
+		ValidateResponses: true,
+		OnResponseDrift: func(c *gin.Context, err error) {
+			t.Errorf("response of %s %s does not match the api description: %v", c.Request.Method, c.FullPath(), err)
+		},
+	})
+	require.NoError(t, err)
+	api.engine.Use(authenticate, NewAuthorizationMiddleware(handleFunctions), NewTenantMiddleware(api.hospitals), validate)
+	api.engine.Use(func(ctx *gin.Context) {
+		if ctx.FullPath() != "" {
+			coveredRoutes.Store(ctx.Request.Method+" "+ctx.FullPath(), true)
+		}
+		ctx.Next()
+	})
+	NewRouterWithGinEngine(api.engine, handleFunctions)
+	api.handler = NewHospitalPathHandler(api.engine)
+	return api
+}
+
+// testAuthSecret signs the tokens of the test requests
+var testAuthSecret = []byte("hospital-mgmt-test-secret-0123456789")
+
+// testSubject is the subject of the tokens of the test requests without an actor
+const testSubject = "test-user"
+
+// testHospital is the hospital of every test api, selected by the test requests by default
+const testHospital = "hospital-1"
+
+// testRequest describes a request, body is marshalled to JSON unless it is a string. The request
+// is authenticated with a token of the actor with the roles, admin by default, and the claims,
+// unless it is anonymous. It selects the hospital, testHospital by default, by the
+// X-Hospital-Id header unless noHospital is set. Tokens without the admin role are bound to the
+// hospital by their claims unless the claims are given, as are the tokens of hospital staff.
+type testRequest struct {
+	method      string
+	path        string
+	body        interface{}
+	contentType string
+	ifMatch     string
+	actor       string
+	roles       []string
+	anonymous   bool
+	claims      jwt.MapClaims
+	hospital    string
+	noHospital  bool
+}
+
+// testToken returns a bearer token of the subject with the roles valid for the test
+func testToken(t *testing.T, subject string, roles ...string) string {
+	t.Helper()
+	return testTokenWithClaims(t, subject, roles, nil)
+}
+
+// testTokenWithClaims returns a bearer token of the subject with the roles and the claims
+func testTokenWithClaims(t *testing.T, subject string, roles []string, claims jwt.MapClaims) string {
+	t.Helper()
+	signed := jwt.MapClaims{
+		"sub":   subject,
+		"roles": roles,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		signed[name] = value
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, signed).SignedString(testAuthSecret)
+	require.NoError(t, err)
+	return token
+}
+
+func (api *testApi) do(request testRequest) *httptest.ResponseRecorder {
+	api.t.Helper()
+
+	var body bytes.Buffer
+	switch value := request.body.(type) {
+	case nil:
+	case string:
+		body.WriteString(value)
+	default:
+		require.NoError(api.t, json.NewEncoder(&body).Encode(value))
+	}
+
+	req := httptest.NewRequest(request.method, request.path, &body)
+	if request.body != nil {
+		contentType := request.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	if request.ifMatch != "" {
+		req.Header.Set("If-Match", request.ifMatch)
+	}
+	hospital := request.hospital
+	if hospital == "" {
+		hospital = testHospital
+	}
+	if !request.anonymous {
+		actor := request.actor
+		if actor == "" {
+			actor = testSubject
+		}
+		roles := request.roles
+		if roles == nil {
+			roles = []string{RoleAdmin}
+		}
+		claims := request.claims
+		if claims == nil && !slices.Contains(roles, RoleAdmin) {
+			claims = jwt.MapClaims{hospitalClaim: hospital}
+		}
+		req.Header.Set("Authorization", "Bearer "+testTokenWithClaims(api.t, actor, roles, claims))
+	}
+	if !request.noHospital {
+		req.Header.Set(hospitalHeader, hospital)
+	}
+
+	recorder := httptest.NewRecorder()
+	api.handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func (api *testApi) get(path string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.do(testRequest{method: http.MethodGet, path: path})
+}
+
+func (api *testApi) post(path string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.do(testRequest{method: http.MethodPost, path: path, body: body})
+}
+
+func (api *testApi) put(path string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.do(testRequest{method: http.MethodPut, path: path, body: body})
+}
+
+func (api *testApi) mergePatch(path string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.do(testRequest{method: http.MethodPatch, path: path, body: body, contentType: mergePatchContentType})
+}
+
+func (api *testApi) delete(path string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	return api.do(testRequest{method: http.MethodDelete, path: path})
+}
+
+// decode fails the test unless the response has the status, then unmarshals its body
+func decode[T interface{}](t *testing.T, response *httptest.ResponseRecorder, status int) T {
+	t.Helper()
+	require.Equal(t, status, response.Code, response.Body.String())
+	var value T
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &value))
+	return value
+}
+
+// requireStatus fails the test unless the response has the status
+func requireStatus(t *testing.T, response *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	require.Equal(t, status, response.Code, response.Body.String())
+}
+
+// errorBody is the problem+json body of the error responses
+type errorBody struct {
+	problem.Problem
+	Blockers []Blocker    `json:"blockers"`
+	Errors   []FieldError `json:"errors"`
+}
+
+// requireProblem fails the test unless the response is the problem of the status and code
+func requireProblem(t *testing.T, response *httptest.ResponseRecorder, status int, code string) errorBody {
+	t.Helper()
+	require.Equal(t, status, response.Code, response.Body.String())
+	assert.Equal(t, problem.ContentType, response.Header().Get("Content-Type"))
+	body := decode[errorBody](t, response, status)
+	assert.Equal(t, status, body.Status)
+	assert.Equal(t, code, body.Code)
+	assert.Equal(t, problem.TypeOf(code), body.Type)
+	assert.Equal(t, http.StatusText(status), body.Title)
+	assert.NotEmpty(t, body.Detail)
+	return body
+}
+
+// fixtures shared by the tests
+
+func (api *testApi) createDepartment(id string, maximumBeds int) Department {
+	api.t.Helper()
+	return decode[Department](api.t, api.post("/api/departments", Department{
+		Id:       id,
+		Name:     "Department " + id,
+		Floor:    1,
+		Capacity: DepartmentCapacity{MaximumBeds: maximumBeds},
+	}), http.StatusCreated)
+}
+
+func (api *testApi) createBed(id string, departmentId string) Bed {
+	api.t.Helper()
+	return decode[Bed](api.t, api.post("/api/beds", Bed{
+		Id:           id,
+		DepartmentId: departmentId,
+		BedType:      "standard",
+		BedQuality:   0.6,
+		Status:       BedStatus{Description: "Available"},
+	}), http.StatusCreated)
+}
+
+func (api *testApi) createPatient(id string, firstName string, lastName string) Patient {
+	api.t.Helper()
+	return decode[Patient](api.t, api.post("/api/patients", Patient{
+		Id:        id,
+		FirstName: firstName,
+		LastName:  lastName,
+		BirthDate: "1980-01-01",
+		Gender:    "F",
+	}), http.StatusCreated)
+}
+
+func (api *testApi) admit(patientId string, bedId string) HospitalizationRecord {
+	api.t.Helper()
+	return decode[HospitalizationRecord](api.t, api.post("/api/patients/"+patientId+"/admissions", Admission{
+		BedId:       bedId,
+		Description: "observation",
+	}), http.StatusCreated)
+}
+
+// requireCapacity fails the test unless the stored capacity of the department has the bed counts
+func (api *testApi) requireCapacity(departmentId string, actualBeds int, occupiedBeds int) {
+	api.t.Helper()
+	department := decode[Department](api.t, api.get("/api/departments/"+departmentId), http.StatusOK)
+	require.Equal(api.t, actualBeds, department.Capacity.ActualBeds, "actual beds of %s", departmentId)
+	require.Equal(api.t, occupiedBeds, department.Capacity.OccupiedBeds, "occupied beds of %s", departmentId)
+}
+
+// the handlers carry their db services, an engine without any middleware serves the routes
+func TestHandlersWithoutMiddleware(t *testing.T) {
+	api := newTestApi(t)
+	engine := gin.New()
+	NewRouterWithGinEngine(engine, ApiHandleFunctions{
+		DepartmentsAPI: NewDepartmentsAPI(api.departments, api.beds, api.departmentRevisions, DefaultIntegrityPolicy),
+		BedsAPI:        NewBedsAPI(api.beds, api.departments, api.patients, api.bedRevisions),
+		PatientsAPI:    NewPatientsAPI(api.patients, api.beds, api.departments, api.patientRevisions, DefaultIntegrityPolicy),
+		AuditAPI:       NewAuditAPI(api.audit),
+		HospitalsAPI:   NewHospitalsAPI(api.hospitals, api.departments, api.beds, api.patients),
+	})
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	requireStatus(t, serve(http.MethodPost, "/api/departments", `{"id":"surgery","name":"Surgery"}`), http.StatusCreated)
+	requireStatus(t, serve(http.MethodPost, "/api/beds", `{"id":"bed-1","department_id":"surgery"}`), http.StatusCreated)
+	beds := decode[[]Bed](t, serve(http.MethodGet, "/api/departments/surgery/beds", ""), http.StatusOK)
+	assert.Equal(t, []string{"bed-1"}, bedIds(beds))
+	department := decode[Department](t, serve(http.MethodGet, "/api/departments/surgery", ""), http.StatusOK)
+	assert.Equal(t, 1, department.Capacity.ActualBeds)
+}
@@ -67,6 +67,10 @@ type ApiHandleFunctions struct {
 	BedsAPI BedsAPI
 	// Routes for the PatientsAPI part of the API
 	PatientsAPI PatientsAPI
+	// Routes for the AuditAPI part of the API
+	AuditAPI AuditAPI
+	// Routes for the HospitalsAPI part of the API
+	HospitalsAPI HospitalsAPI
 }
 
 func getRoutes(handleFunctions ApiHandleFunctions) []Route {
@@ -96,12 +100,42 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/departments/:departmentId",
 			handleFunctions.DepartmentsAPI.UpdateDepartment,
 		},
+		{
+			"PatchDepartment",
+			http.MethodPatch,
+			"/api/departments/:departmentId",
+			handleFunctions.DepartmentsAPI.PatchDepartment,
+		},
 		{
 			"DeleteDepartment",
 			http.MethodDelete,
 			"/api/departments/:departmentId",
 			handleFunctions.DepartmentsAPI.DeleteDepartment,
 		},
+		{
+			"RestoreDepartment",
+			http.MethodPost,
+			"/api/departments/:departmentId/restore",
+			handleFunctions.DepartmentsAPI.RestoreDepartment,
+		},
+		{
+			"GetDepartmentHistory",
+			http.MethodGet,
+			"/api/departments/:departmentId/history",
+			handleFunctions.DepartmentsAPI.GetDepartmentHistory,
+		},
+		{
+			"RestoreDepartmentRevision",
+			http.MethodPost,
+			"/api/departments/:departmentId/history/:revisionId/restore",
+			handleFunctions.DepartmentsAPI.RestoreDepartmentRevision,
+		},
+		{
+			"ReconcileDepartmentCapacity",
+			http.MethodPost,
+			"/api/departments/reconcile",
+			handleFunctions.DepartmentsAPI.ReconcileDepartmentCapacity,
+		},
 		// Bed routes
 		{
 			"CreateBed",
@@ -133,12 +167,36 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/beds/:bedId",
 			handleFunctions.BedsAPI.UpdateBed,
 		},
+		{
+			"PatchBed",
+			http.MethodPatch,
+			"/api/beds/:bedId",
+			handleFunctions.BedsAPI.PatchBed,
+		},
 		{
 			"DeleteBed",
 			http.MethodDelete,
 			"/api/beds/:bedId",
 			handleFunctions.BedsAPI.DeleteBed,
 		},
+		{
+			"RestoreBed",
+			http.MethodPost,
+			"/api/beds/:bedId/restore",
+			handleFunctions.BedsAPI.RestoreBed,
+		},
+		{
+			"GetBedHistory",
+			http.MethodGet,
+			"/api/beds/:bedId/history",
+			handleFunctions.BedsAPI.GetBedHistory,
+		},
+		{
+			"RestoreBedRevision",
+			http.MethodPost,
+			"/api/beds/:bedId/history/:revisionId/restore",
+			handleFunctions.BedsAPI.RestoreBedRevision,
+		},
 		// Patient routes
 		{
 			"CreatePatient",
@@ -158,18 +216,48 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/patients",
 			handleFunctions.PatientsAPI.GetPatients,
 		},
+		{
+			"SearchPatients",
+			http.MethodGet,
+			"/api/patients/search",
+			handleFunctions.PatientsAPI.SearchPatients,
+		},
 		{
 			"UpdatePatient",
 			http.MethodPut,
 			"/api/patients/:patientId",
 			handleFunctions.PatientsAPI.UpdatePatient,
 		},
+		{
+			"PatchPatient",
+			http.MethodPatch,
+			"/api/patients/:patientId",
+			handleFunctions.PatientsAPI.PatchPatient,
+		},
 		{
 			"DeletePatient",
 			http.MethodDelete,
 			"/api/patients/:patientId",
 			handleFunctions.PatientsAPI.DeletePatient,
 		},
+		{
+			"RestorePatient",
+			http.MethodPost,
+			"/api/patients/:patientId/restore",
+			handleFunctions.PatientsAPI.RestorePatient,
+		},
+		{
+			"GetPatientHistory",
+			http.MethodGet,
+			"/api/patients/:patientId/history",
+			handleFunctions.PatientsAPI.GetPatientHistory,
+		},
+		{
+			"RestorePatientRevision",
+			http.MethodPost,
+			"/api/patients/:patientId/history/:revisionId/restore",
+			handleFunctions.PatientsAPI.RestorePatientRevision,
+		},
 		// Hospitalization record routes
 		{
 			"AddHospitalizationRecord",
@@ -189,5 +277,68 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/patients/:patientId/hospitalizations/:recordId",
 			handleFunctions.PatientsAPI.DeleteHospitalizationRecord,
 		},
+		// Admission routes
+		{
+			"AdmitPatient",
+			http.MethodPost,
+			"/api/patients/:patientId/admissions",
+			handleFunctions.PatientsAPI.AdmitPatient,
+		},
+		{
+			"DischargePatient",
+			http.MethodPost,
+			"/api/patients/:patientId/discharge",
+			handleFunctions.PatientsAPI.DischargePatient,
+		},
+		{
+			"TransferPatient",
+			http.MethodPost,
+			"/api/patients/:patientId/transfers",
+			handleFunctions.PatientsAPI.TransferPatient,
+		},
+		{
+			"GetPatientTransfers",
+			http.MethodGet,
+			"/api/patients/:patientId/transfers",
+			handleFunctions.PatientsAPI.GetPatientTransfers,
+		},
+		// Audit routes
+		{
+			"GetAuditEntries",
+			http.MethodGet,
+			"/api/audit",
+			handleFunctions.AuditAPI.GetAuditEntries,
+		},
+		// Hospital routes
+		{
+			"CreateHospital",
+			http.MethodPost,
+			"/api/hospitals",
+			handleFunctions.HospitalsAPI.CreateHospital,
+		},
+		{
+			"GetHospital",
+			http.MethodGet,
+			"/api/hospitals/:hospitalId",
+			handleFunctions.HospitalsAPI.GetHospital,
+		},
+		{
+			"GetHospitals",
+			http.MethodGet,
+			"/api/hospitals",
+			handleFunctions.HospitalsAPI.GetHospitals,
+		},
+		{
+			"UpdateHospital",
+			http.MethodPut,
+			"/api/hospitals/:hospitalId",
+			handleFunctions.HospitalsAPI.UpdateHospital,
+		},
+		{
+			"DeleteHospital",
+			http.MethodDelete,
+			"/api/hospitals/:hospitalId",
+			handleFunctions.HospitalsAPI.DeleteHospital,
+		},
 	}
 } 
\ No newline at end of file
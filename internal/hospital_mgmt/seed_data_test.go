@@ -0,0 +1,207 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const initDbScript = "../../deployments/kustomize/install/params/init-db.js"
+
+// insertMany calls of the init script with the collection variable and the document array
+var insertManyPattern = regexp.MustCompile(`(?s)db\[(\w+)Collection\]\.insertMany\((\[.*?\n    \])\)`)
+
+// seededDocuments reads the documents inserted into the collection by the init script,
+// they are decoded by their bson tags just like the documents stored in mongo
+func seededDocuments[DocType interface{}](t *testing.T, collection string) []*DocType {
+	t.Helper()
+	script, err := os.ReadFile(initDbScript)
+	require.NoError(t, err)
+
+	for _, match := range insertManyPattern.FindAllSubmatch(script, -1) {
+		if string(match[1]) != collection {
+			continue
+		}
+		documents := strings.ReplaceAll(string(match[2]), "new Date()", `{"$date": "2024-01-01T00:00:00Z"}`)
+		var seeded struct {
+			Documents []*DocType `bson:"documents"`
+		}
+		require.NoError(t, bson.UnmarshalExtJSON([]byte(`{"documents": `+documents+`}`), false, &seeded))
+		require.NotEmpty(t, seeded.Documents)
+		return seeded.Documents
+	}
+	require.Failf(t, "no seeded documents", "collection %s is not seeded by %s", collection, initDbScript)
+	return nil
+}
+
+// seed stores the documents under their keys as the init script does, the documents are left
+// with the ids they are served by
+func seed[DocType interface{}, P tenantDocument[DocType]](
+	t *testing.T,
+	db db_service.DbService[DocType],
+	documents []*DocType,
+) []string {
+	t.Helper()
+	ids := []string{}
+	for _, document := range documents {
+		key := P(document).documentId()
+		require.NoError(t, db.CreateDocument(context.Background(), key, document))
+		id, keyed := strings.CutPrefix(key, HospitalDocumentKey(P(document).documentHospitalId(), ""))
+		require.True(t, keyed, "%s is not stored under the key of its hospital", key)
+		P(document).setDocumentId(id)
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// the data seeded by init-db.js is reachable through every list and get endpoint
+func TestSeedData(t *testing.T) {
+	api := newTestApi(t)
+	departments := seededDocuments[Department](t, "departments")
+	beds := seededDocuments[Bed](t, "beds")
+	patients := seededDocuments[Patient](t, "patients")
+	seededDepartments := seed(t, api.departments, departments)
+	seededBeds := seed(t, api.beds, beds)
+	seededPatients := seed(t, api.patients, patients)
+
+	// the test api serves the seeded hospital, every seeded document belongs to it
+	t.Run("hospitals", func(t *testing.T) {
+		hospitals := seededDocuments[Hospital](t, "hospitals")
+		require.Len(t, hospitals, 1)
+		assert.Equal(t, testHospital, hospitals[0].Id)
+		assert.NotEmpty(t, hospitals[0].Name)
+
+		for _, department := range departments {
+			assert.Equal(t, testHospital, department.HospitalId, "hospital of %s", department.Id)
+		}
+		for _, bed := range beds {
+			assert.Equal(t, testHospital, bed.HospitalId, "hospital of %s", bed.Id)
+		}
+		for _, patient := range patients {
+			assert.Equal(t, testHospital, patient.HospitalId, "hospital of %s", patient.Id)
+		}
+	})
+
+	t.Run("departments", func(t *testing.T) {
+		assert.Equal(t, []string{"internal-med", "pediatric", "surgery"}, seededDepartments)
+
+		listed := decode[[]Department](t, api.get("/api/departments?sort=id"), http.StatusOK)
+		assert.Equal(t, byId(departments), pointers(listed))
+
+		for _, department := range departments {
+			found := decode[Department](t, api.get("/api/departments/"+department.Id), http.StatusOK)
+			assert.Equal(t, *department, found)
+			assert.NotZero(t, found.Capacity.MaximumBeds)
+			assert.Equal(t, 1, int(found.Version))
+		}
+
+		// the seeded bed counts agree with the seeded beds
+		reconciliation := decode[CapacityReconciliation](t, api.post("/api/departments/reconcile?dry_run=true", nil), http.StatusOK)
+		assert.Equal(t, len(departments), reconciliation.Checked)
+		assert.Empty(t, reconciliation.Discrepancies)
+	})
+
+	t.Run("beds", func(t *testing.T) {
+		assert.Equal(t, []string{"int-101", "int-102", "ped-301", "surg-201"}, seededBeds)
+
+		listed := decode[[]Bed](t, api.get("/api/beds?sort=id"), http.StatusOK)
+		assert.Equal(t, byId(beds), pointers(listed))
+
+		for _, bed := range beds {
+			found := decode[Bed](t, api.get("/api/beds/"+bed.Id), http.StatusOK)
+			assert.Equal(t, *bed, found)
+			assert.NotEmpty(t, found.DepartmentId)
+			assert.NotEmpty(t, found.BedType)
+		}
+
+		for departmentId, expected := range map[string][]string{
+			"internal-med": {"int-101", "int-102"},
+			"surgery":      {"surg-201"},
+			"pediatric":    {"ped-301"},
+		} {
+			found := decode[[]Bed](t, api.get("/api/departments/"+departmentId+"/beds?sort=id"), http.StatusOK)
+			assert.Equal(t, expected, bedIds(found), "beds of %s", departmentId)
+
+			found = decode[[]Bed](t, api.get("/api/beds?sort=id&department_id="+departmentId), http.StatusOK)
+			assert.Equal(t, expected, bedIds(found), "beds filtered by %s", departmentId)
+		}
+
+		found := decode[[]Bed](t, api.get("/api/beds?sort=id&occupied=false"), http.StatusOK)
+		assert.Equal(t, seededBeds, bedIds(found))
+		found = decode[[]Bed](t, api.get("/api/beds?sort=id&bed_type=post-op&min_quality=0.9"), http.StatusOK)
+		assert.Equal(t, []string{"surg-201"}, bedIds(found))
+	})
+
+	t.Run("patients", func(t *testing.T) {
+		assert.Equal(t, []string{"pat-001", "pat-002", "pat-003"}, seededPatients)
+
+		listed := decode[[]Patient](t, api.get("/api/patients?sort=id"), http.StatusOK)
+		assert.Equal(t, byId(withoutSearchTerms(patients)), pointers(listed))
+
+		for _, patient := range patients {
+			found := decode[Patient](t, api.get("/api/patients/"+patient.Id), http.StatusOK)
+			assert.Equal(t, *withoutSearchTerms([]*Patient{patient})[0], found)
+			assert.NotEmpty(t, found.LastName)
+			assert.NotEmpty(t, found.HospitalizationRecords)
+
+			transfers := decode[[]BedTransfer](t, api.get("/api/patients/"+patient.Id+"/transfers"), http.StatusOK)
+			assert.Empty(t, transfers)
+		}
+
+		// the seeded search fields match the ones computed by the api
+		for _, patient := range patients {
+			expected := *patient
+			expected.UpdateSearchTerms()
+			assert.Equal(t, expected.SearchTerms, patient.SearchTerms, "search terms of %s", patient.Id)
+		}
+		for query, expected := range map[string][]string{
+			"q=novak":          {"pat-001"},
+			"q=kovacova":       {"pat-002"},
+			"q=Horváth":        {"pat-003"},
+			"phone=%2B421905":  {"pat-002"},
+			"birth_date=2018":  {"pat-003"},
+			"email=jan.novak@": {"pat-001"},
+		} {
+			found := decode[[]Patient](t, api.get("/api/patients/search?"+query), http.StatusOK)
+			assert.Equal(t, expected, patientIds(found), "query %q", query)
+		}
+	})
+}
+
+// byId sorts the documents by their id, as listed with sort=id
+func byId[DocType interface{}, P versionedDocument[DocType]](documents []*DocType) []*DocType {
+	sorted := append([]*DocType{}, documents...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return P(sorted[i]).documentId() < P(sorted[j]).documentId()
+	})
+	return sorted
+}
+
+func pointers[DocType interface{}](documents []DocType) []*DocType {
+	result := make([]*DocType, 0, len(documents))
+	for i := range documents {
+		result = append(result, &documents[i])
+	}
+	return result
+}
+
+// withoutSearchTerms copies the patients without the search fields, which are not part of the api
+func withoutSearchTerms(patients []*Patient) []*Patient {
+	copies := make([]*Patient, 0, len(patients))
+	for _, patient := range patients {
+		copied := *patient
+		copied.SearchTerms = PatientSearchTerms{}
+		copies = append(copies, &copied)
+	}
+	return copies
+}
@@ -0,0 +1,264 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+)
+
+var errNotDeleted = errors.New("document is not deleted")
+
+// softDeletable is a versioned document which can be marked as deleted
+type softDeletable[DocType interface{}] interface {
+	versionedDocument[DocType]
+	documentDeletedAt() *time.Time
+}
+
+func (d *Department) documentDeletedAt() *time.Time { return d.DeletedAt }
+func (b *Bed) documentDeletedAt() *time.Time        { return b.DeletedAt }
+func (p *Patient) documentDeletedAt() *time.Time    { return p.DeletedAt }
+
+// notDeletedFilter matches the documents which are not marked as deleted
+var notDeletedFilter = map[string]interface{}{"deleted_at": map[string]interface{}{"$exists": false}}
+
+type includeDeletedKey struct{}
+
+// withDeleted returns the context showing documents marked as deleted to the soft delete service
+func withDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedKey{}, true)
+}
+
+func includesDeleted(ctx context.Context) bool {
+	include, _ := ctx.Value(includeDeletedKey{}).(bool)
+	return include
+}
+
+// softDeleteSvc marks deleted documents with `deleted_at` instead of removing them, and hides the
+// marked documents from the reads unless the context is made by withDeleted
+type softDeleteSvc[DocType interface{}, P softDeletable[DocType]] struct {
+	db_service.DbService[DocType]
+}
+
+// NewSoftDeleteService wraps the db service so deletes only mark the documents as deleted.
+// The marked documents are removed for good by PurgeDeleted.
+func NewSoftDeleteService[DocType interface{}, P softDeletable[DocType]](
+	db db_service.DbService[DocType],
+) db_service.DbService[DocType] {
+	return &softDeleteSvc[DocType, P]{DbService: db}
+}
+
+func (m *softDeleteSvc[DocType, P]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	document, err := m.DbService.FindDocument(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if P(document).documentDeletedAt() != nil && !includesDeleted(ctx) {
+		return nil, db_service.ErrNotFound
+	}
+	return document, nil
+}
+
+func (m *softDeleteSvc[DocType, P]) FindAllDocuments(ctx context.Context) ([]*DocType, error) {
+	if includesDeleted(ctx) {
+		return m.DbService.FindAllDocuments(ctx)
+	}
+	return m.DbService.FindDocumentsByFilter(ctx, notDeletedFilter)
+}
+
+func (m *softDeleteSvc[DocType, P]) FindDocumentsByFilter(ctx context.Context, filter interface{}) ([]*DocType, error) {
+	return m.DbService.FindDocumentsByFilter(ctx, m.filter(ctx, filter))
+}
+
+func (m *softDeleteSvc[DocType, P]) FindDocumentsPage(
+	ctx context.Context,
+	request db_service.PageRequest,
+) (*db_service.Page[DocType], error) {
+	request.Filter = m.filter(ctx, request.Filter)
+	return m.DbService.FindDocumentsPage(ctx, request)
+}
+
+func (m *softDeleteSvc[DocType, P]) filter(ctx context.Context, filter interface{}) interface{} {
+	switch {
+	case includesDeleted(ctx):
+		return filter
+	case filter == nil:
+		return notDeletedFilter
+	default:
+		return map[string]interface{}{"$and": []interface{}{filter, notDeletedFilter}}
+	}
+}
+
+func (m *softDeleteSvc[DocType, P]) DeleteDocument(ctx context.Context, id string) error {
+	document, err := m.FindDocument(ctx, id)
+	if err != nil {
+		return err
+	}
+	return m.markDeleted(ctx, id, P(document).documentVersion())
+}
+
+func (m *softDeleteSvc[DocType, P]) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	document, err := m.FindDocument(ctx, id)
+	if err != nil {
+		return err
+	}
+	if P(document).documentVersion() != expectedVersion {
+		return db_service.ErrVersionMismatch
+	}
+	return m.markDeleted(ctx, id, expectedVersion)
+}
+
+// markDeleted marks the document as deleted as its next version
+func (m *softDeleteSvc[DocType, P]) markDeleted(ctx context.Context, id string, version int64) error {
+	return m.DbService.UpdateDocumentFieldsVersioned(ctx, id, version, map[string]interface{}{
+		"deleted_at": time.Now().UTC(),
+		"version":    version + 1,
+	}, nil)
+}
+
+// purgeable is a document of a hospital which can be marked as deleted
+type purgeable[DocType interface{}] interface {
+	softDeletable[DocType]
+	documentHospitalId() string
+}
+
+// PurgeDeleted removes the documents marked as deleted before the time for good, together with
+// their revisions. Their audit entries are kept without the values of the documents and the
+// purge is recorded as the last of them. The db service has to be the one wrapped by the soft
+// delete service, revisions and audit the services the entity is recorded with. Documents
+// restored meanwhile are kept.
+func PurgeDeleted[DocType interface{}, P purgeable[DocType]](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	revisions db_service.DbService[Revision[DocType]],
+	audit db_service.DbService[AuditEntry],
+	entity string,
+	deletedBefore time.Time,
+) (int, error) {
+	documents, err := db.FindDocumentsByFilter(ctx, map[string]interface{}{
+		"deleted_at": map[string]interface{}{"$lt": deletedBefore},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, document := range documents {
+		err := db.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := deleteDocument(ctx, db, P(document)); err != nil {
+				return err
+			}
+			return purgeRecords(ctx, revisions, audit, entity, P(document))
+		})
+		switch err {
+		case nil:
+			purged++
+		case db_service.ErrNotFound, db_service.ErrVersionMismatch:
+		default:
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// purgeRecords removes the revisions of the purged document, redacts the values of the document
+// from its audit entries and records the purge. The document is read without a hospital, under
+// the key of its hospital.
+func purgeRecords[DocType interface{}, P purgeable[DocType]](
+	ctx context.Context,
+	revisions db_service.DbService[Revision[DocType]],
+	audit db_service.DbService[AuditEntry],
+	entity string,
+	document P,
+) error {
+	id := document.documentId()
+	if hospitalId := document.documentHospitalId(); hospitalId != "" {
+		ctx = WithHospital(ctx, hospitalId)
+		id = strings.TrimPrefix(id, HospitalDocumentKey(hospitalId, ""))
+	}
+
+	history, err := revisions.FindDocumentsByFilter(ctx, map[string]interface{}{"entity_id": id})
+	if err != nil {
+		return err
+	}
+	for _, revision := range history {
+		if err := revisions.DeleteDocument(ctx, revision.Id); err != nil {
+			return err
+		}
+	}
+
+	entries, err := audit.FindDocumentsByFilter(ctx, map[string]interface{}{"entity": entity, "entity_id": id})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Before == nil && entry.After == nil {
+			continue
+		}
+		if err := audit.UpdateDocumentFields(ctx, entry.Id, nil, auditValueFields); err != nil {
+			return err
+		}
+	}
+
+	entry, err := newAuditEntry(ctx, entity, id, auditActionPurge)
+	if err != nil {
+		return err
+	}
+	return audit.CreateDocument(ctx, entry.Id, entry)
+}
+
+// parseIncludeDeleted returns the context to read the documents of the request with, it shows
+// the documents marked as deleted if the `include_deleted` query parameter is set
+func parseIncludeDeleted(c *gin.Context) (context.Context, error) {
+	value := c.Query("include_deleted")
+	if value == "" {
+		return c, nil
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	if !include {
+		return c, nil
+	}
+	return withDeleted(c), nil
+}
+
+func respondIncludeDeletedError(c *gin.Context, err error) {
+	respondProblem(c, http.StatusBadRequest, codeInvalidQuery,
+		"Invalid include_deleted query parameter, include_deleted must be true or false")
+}
+
+// findDeletedDocument returns the document marked as deleted for its restore
+func findDeletedDocument[DocType interface{}, P softDeletable[DocType]](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	id string,
+	precondition ifMatch,
+) (*DocType, error) {
+	document, err := db.FindDocument(withDeleted(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	if err := precondition.check(P(document).documentVersion()); err != nil {
+		return nil, err
+	}
+	if P(document).documentDeletedAt() == nil {
+		return nil, errNotDeleted
+	}
+	return document, nil
+}
+
+// saveRestoredDocument removes the deleted mark of the document as its next version
+func saveRestoredDocument[DocType interface{}, P softDeletable[DocType]](
+	ctx context.Context,
+	db db_service.DbService[DocType],
+	document P,
+) error {
+	return saveDocument(withDeleted(ctx), db, document)
+}
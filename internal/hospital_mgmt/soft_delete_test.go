@@ -0,0 +1,221 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftDelete(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.createPatient("patient-2", "Eva", "Kralova")
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNoContent)
+
+	requireStatus(t, api.get("/api/patients/patient-1"), http.StatusNotFound)
+	assert.Equal(t, []string{"patient-2"}, patientIds(decode[[]Patient](t, api.get("/api/patients"), http.StatusOK)))
+	requireStatus(t, api.put("/api/patients/patient-1", Patient{FirstName: "Jana", LastName: "Novakova", BirthDate: "1980-01-01", Gender: "F"}), http.StatusNotFound)
+	requireStatus(t, api.mergePatch("/api/patients/patient-1", map[string]interface{}{"phone": "+421900111222"}), http.StatusNotFound)
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNotFound)
+	requireStatus(t, api.post("/api/patients", Patient{Id: "patient-1", FirstName: "Jana", LastName: "Novakova", BirthDate: "1980-01-01", Gender: "F"}), http.StatusConflict)
+
+	response := api.get("/api/patients/patient-1?include_deleted=true")
+	deleted := decode[Patient](t, response, http.StatusOK)
+	require.NotNil(t, deleted.DeletedAt)
+	assert.EqualValues(t, 2, deleted.Version, "the delete is a write of the document")
+	assert.Equal(t, versionETag(2), response.Header().Get("ETag"))
+	assert.Equal(t, []string{"patient-1", "patient-2"},
+		patientIds(decode[[]Patient](t, api.get("/api/patients?include_deleted=true&sort=id"), http.StatusOK)))
+	requireStatus(t, api.get("/api/patients?include_deleted=maybe"), http.StatusBadRequest)
+	requireStatus(t, api.get("/api/patients/patient-1?include_deleted=maybe"), http.StatusBadRequest)
+
+	assert.Equal(t, []string{"patient patient-1 create", "patient patient-1 delete"},
+		auditActions(api.auditEntries("id=patient-1")))
+	revisions := decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-1")), http.StatusOK)
+	require.Len(t, revisions, 2)
+	assert.True(t, revisions[1].Deleted)
+}
+
+func TestSoftDeleteBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	requireStatus(t, api.delete("/api/beds/bed-1"), http.StatusNoContent)
+
+	api.requireCapacity("surgery", 1, 0)
+	assert.Equal(t, []string{"bed-2"}, bedIds(decode[[]Bed](t, api.get("/api/departments/surgery/beds"), http.StatusOK)))
+	assert.Equal(t, []string{"bed-1", "bed-2"},
+		bedIds(decode[[]Bed](t, api.get("/api/beds?include_deleted=true&sort=id"), http.StatusOK)))
+	assert.Equal(t, []string{"bed-1", "bed-2"},
+		bedIds(decode[[]Bed](t, api.get("/api/departments/surgery/beds?include_deleted=1&sort=id"), http.StatusOK)))
+
+	// deleted beds are not counted in the capacity
+	reconciliation := decode[CapacityReconciliation](t, api.post("/api/departments/reconcile?dry_run=true", nil), http.StatusOK)
+	assert.Empty(t, reconciliation.Discrepancies)
+
+	// the remaining beds of a department block its delete, the deleted ones do not
+	requireStatus(t, api.delete("/api/beds/bed-2"), http.StatusNoContent)
+	requireStatus(t, api.delete("/api/departments/surgery"), http.StatusNoContent)
+	assert.Empty(t, decode[[]Department](t, api.get("/api/departments"), http.StatusOK))
+	department := decode[Department](t, api.get("/api/departments/surgery?include_deleted=true"), http.StatusOK)
+	assert.NotNil(t, department.DeletedAt)
+}
+
+func TestRestorePatient(t *testing.T) {
+	api := newTestApi(t)
+	api.createPatient("patient-1", "Jana", "Novakova")
+	requireStatus(t, api.post("/api/patients/patient-1/restore", nil), http.StatusConflict)
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNoContent)
+
+	requireStatus(t, api.do(testRequest{
+		method:  http.MethodPost,
+		path:    "/api/patients/patient-1/restore",
+		ifMatch: versionETag(1),
+	}), http.StatusPreconditionFailed)
+	response := api.do(testRequest{
+		method:  http.MethodPost,
+		path:    "/api/patients/patient-1/restore",
+		ifMatch: versionETag(2),
+	})
+	restored := decode[Patient](t, response, http.StatusOK)
+	assert.Nil(t, restored.DeletedAt)
+	assert.EqualValues(t, 3, restored.Version)
+	assert.Equal(t, versionETag(3), response.Header().Get("ETag"))
+
+	patient := decode[Patient](t, api.get("/api/patients/patient-1"), http.StatusOK)
+	assert.Equal(t, "Novakova", patient.LastName)
+	assert.Nil(t, patient.DeletedAt)
+	found := decode[[]Patient](t, api.get("/api/patients/search?q=novakova"), http.StatusOK)
+	assert.Equal(t, []string{"patient-1"}, patientIds(found))
+
+	requireStatus(t, api.post("/api/patients/patient-1/restore", nil), http.StatusConflict)
+	requireStatus(t, api.post("/api/patients/missing/restore", nil), http.StatusNotFound)
+
+	entries := api.auditEntries("id=patient-1")
+	require.Equal(t, []string{"patient patient-1 create", "patient patient-1 delete", "patient patient-1 update"},
+		auditActions(entries))
+	assert.Equal(t, "POST /api/patients/:patientId/restore", entries[2].Route)
+	assert.Contains(t, entries[2].Before, "deleted_at")
+	assert.NotContains(t, entries[2].After, "deleted_at")
+	revisions := decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-1")), http.StatusOK)
+	require.Len(t, revisions, 3)
+	assert.EqualValues(t, 3, revisions[2].Version)
+}
+
+// the bed of a patient deleted with the cascade policy is freed, the restored patient is not admitted
+func TestRestorePatientReleasedFromBed(t *testing.T) {
+	api := newTestApiWithPolicy(t, IntegrityPolicy{DepartmentBeds: DeleteRestrict, PatientBeds: DeleteCascade})
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+	api.admit("patient-1", "bed-1")
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNoContent)
+	deleted := decode[Patient](t, api.get("/api/patients/patient-1?include_deleted=true"), http.StatusOK)
+
+	restored := decode[Patient](t, api.post("/api/patients/patient-1/restore", nil), http.StatusOK)
+	require.Len(t, restored.HospitalizationRecords, 1)
+	require.NotNil(t, restored.HospitalizationRecords[0].DischargedAt)
+	assert.True(t, restored.HospitalizationRecords[0].DischargedAt.Equal(*deleted.DeletedAt))
+	assert.Nil(t, restored.activeHospitalization())
+
+	api.admit("patient-1", "bed-1")
+	api.requireCapacity("surgery", 1, 1)
+}
+
+func TestRestoreBed(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 1)
+	api.createBed("bed-1", "surgery")
+	requireStatus(t, api.delete("/api/beds/bed-1"), http.StatusNoContent)
+	api.requireCapacity("surgery", 0, 0)
+
+	restored := decode[Bed](t, api.post("/api/beds/bed-1/restore", nil), http.StatusOK)
+	assert.Nil(t, restored.DeletedAt)
+	assert.EqualValues(t, 3, restored.Version)
+	api.requireCapacity("surgery", 1, 0)
+	requireStatus(t, api.post("/api/beds/bed-1/restore", nil), http.StatusConflict)
+
+	// the department has no room for the restored bed
+	requireStatus(t, api.delete("/api/beds/bed-1"), http.StatusNoContent)
+	api.createBed("bed-2", "surgery")
+	requireStatus(t, api.post("/api/beds/bed-1/restore", nil), http.StatusConflict)
+
+	// the department of the restored bed has been deleted
+	requireStatus(t, api.delete("/api/beds/bed-2"), http.StatusNoContent)
+	requireStatus(t, api.delete("/api/departments/surgery"), http.StatusNoContent)
+	body := requireProblem(t, api.post("/api/beds/bed-1/restore", nil), http.StatusConflict, codeMissingReference)
+	assert.Equal(t, []Blocker{{Entity: "department", Id: "surgery", Reason: "does not exist"}}, body.Blockers)
+
+	requireStatus(t, api.post("/api/beds/missing/restore", nil), http.StatusNotFound)
+}
+
+func TestRestoreDepartment(t *testing.T) {
+	api := newTestApiWithPolicy(t, IntegrityPolicy{DepartmentBeds: DeleteCascade, PatientBeds: DeleteRestrict})
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createBed("bed-2", "surgery")
+	requireStatus(t, api.delete("/api/departments/surgery"), http.StatusNoContent)
+	requireStatus(t, api.get("/api/beds/bed-1"), http.StatusNotFound)
+
+	// the beds deleted by the cascade are not restored with the department
+	restored := decode[Department](t, api.post("/api/departments/surgery/restore", nil), http.StatusOK)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, DepartmentCapacity{MaximumBeds: 10}, restored.Capacity)
+	requireStatus(t, api.post("/api/beds/bed-1/restore", nil), http.StatusOK)
+	api.requireCapacity("surgery", 1, 0)
+
+	requireStatus(t, api.post("/api/departments/surgery/restore", nil), http.StatusConflict)
+	requireStatus(t, api.post("/api/departments/missing/restore", nil), http.StatusNotFound)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	api := newTestApi(t)
+	for _, id := range []string{"patient-1", "patient-2", "patient-3"} {
+		api.createPatient(id, "Jana", "Novakova")
+	}
+	requireStatus(t, api.delete("/api/patients/patient-1"), http.StatusNoContent)
+	nextMillisecond()
+	deletedBefore := time.Now()
+	nextMillisecond()
+	requireStatus(t, api.delete("/api/patients/patient-2"), http.StatusNoContent)
+
+	purge := func(deletedBefore time.Time) int {
+		purged, err := PurgeDeleted(t.Context(), api.patientStore, api.patientRevisions, api.audit,
+			AuditEntityPatient, deletedBefore)
+		require.NoError(t, err)
+		return purged
+	}
+
+	assert.Equal(t, 1, purge(deletedBefore))
+	requireStatus(t, api.get("/api/patients/patient-1?include_deleted=true"), http.StatusNotFound)
+	requireStatus(t, api.get("/api/patients/patient-2?include_deleted=true"), http.StatusOK)
+	requireStatus(t, api.post("/api/patients/patient-1/restore", nil), http.StatusNotFound)
+
+	// the history of the purged document goes with it, its audit entries keep no values
+	requireStatus(t, api.get(historyPath("/api/patients/patient-1")), http.StatusNotFound)
+	entries := api.auditEntries("entity=patient&id=patient-1")
+	require.Len(t, entries, 3)
+	assert.Equal(t, []string{auditActionCreate, auditActionDelete, auditActionPurge},
+		[]string{entries[0].Action, entries[1].Action, entries[2].Action})
+	for _, entry := range entries {
+		assert.Nil(t, entry.Before, entry.Action)
+		assert.Nil(t, entry.After, entry.Action)
+		assert.Equal(t, testHospital, entry.HospitalId)
+	}
+	assert.Equal(t, "system", entries[2].Actor)
+	assert.NotEmpty(t, api.auditEntries("entity=patient&id=patient-2")[0].After, "other documents keep their values")
+
+	// restored documents are kept
+	requireStatus(t, api.post("/api/patients/patient-2/restore", nil), http.StatusOK)
+	assert.Zero(t, purge(time.Now().Add(time.Second)))
+	assert.Equal(t, []string{"patient-2", "patient-3"},
+		patientIds(decode[[]Patient](t, api.get("/api/patients?include_deleted=true&sort=id"), http.StatusOK)))
+	assert.Len(t, decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-2")), http.StatusOK), 3)
+
+	api.createPatient("patient-1", "Eva", "Kralova")
+	assert.Len(t, decode[[]PatientRevision](t, api.get(historyPath("/api/patients/patient-1")), http.StatusOK), 1)
+}
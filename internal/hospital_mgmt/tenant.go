@@ -0,0 +1,393 @@
+package hospital_mgmt
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/psabol571/sarsabsim-webapi/internal/auth"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+)
+
+// context key of the hospital the request works with
+const hospitalIdKey = "hospital_id"
+
+// hospitalHeader selects the hospital of the request
+const hospitalHeader = "X-Hospital-Id"
+
+// hospitalClaim binds the token to the only hospital its user works for
+const hospitalClaim = "hospital_id"
+
+// hospitalsPathPrefix selects the hospital of the request by the path, e.g.
+// /api/hospitals/:hospitalId/patients is served as /api/patients of the hospital
+const hospitalsPathPrefix = "/api/hospitals/"
+
+var (
+	errHospitalMissing      = errors.New("the hospital has to be selected by the " + hospitalHeader + " header or the path")
+	errHospitalNotPermitted = errors.New("the token is not permitted to the hospital")
+	errHospitalUnbound      = errors.New("the token is not bound to a hospital by the " + hospitalClaim + " claim")
+	errHospitalBound        = errors.New("hospitals are managed by tokens not bound to a hospital")
+	errHospitalPathMismatch = errors.New("the " + hospitalHeader + " header differs from the hospital of the path")
+)
+
+// tenantDocument is a pointer to a document belonging to a hospital
+type tenantDocument[DocType interface{}] interface {
+	*DocType
+	documentId() string
+	setDocumentId(id string)
+	documentHospitalId() string
+	setDocumentHospitalId(hospitalId string)
+}
+
+func (d *Department) setDocumentId(id string)                 { d.Id = id }
+func (d *Department) documentHospitalId() string              { return d.HospitalId }
+func (d *Department) setDocumentHospitalId(hospitalId string) { d.HospitalId = hospitalId }
+
+func (b *Bed) setDocumentId(id string)                 { b.Id = id }
+func (b *Bed) documentHospitalId() string              { return b.HospitalId }
+func (b *Bed) setDocumentHospitalId(hospitalId string) { b.HospitalId = hospitalId }
+
+func (p *Patient) setDocumentId(id string)                 { p.Id = id }
+func (p *Patient) documentHospitalId() string              { return p.HospitalId }
+func (p *Patient) setDocumentHospitalId(hospitalId string) { p.HospitalId = hospitalId }
+
+func (e *AuditEntry) documentId() string                      { return e.Id }
+func (e *AuditEntry) setDocumentId(id string)                 { e.Id = id }
+func (e *AuditEntry) documentHospitalId() string              { return e.HospitalId }
+func (e *AuditEntry) setDocumentHospitalId(hospitalId string) { e.HospitalId = hospitalId }
+
+func (r *Revision[DocType]) documentId() string                      { return r.Id }
+func (r *Revision[DocType]) setDocumentId(id string)                 { r.Id = id }
+func (r *Revision[DocType]) documentHospitalId() string              { return r.HospitalId }
+func (r *Revision[DocType]) setDocumentHospitalId(hospitalId string) { r.HospitalId = hospitalId }
+
+// HospitalDocumentKey returns the key the document of the hospital is stored under. Documents
+// of different hospitals may have the same id, the key prefixes it with the hospital, which
+// never contains a slash.
+func HospitalDocumentKey(hospitalId string, id string) string {
+	return hospitalId + "/" + id
+}
+
+type hospitalContextKey struct{}
+
+// WithHospital returns the context scoping the db services to the hospital, for work outside
+// of requests
+func WithHospital(ctx context.Context, hospitalId string) context.Context {
+	return context.WithValue(ctx, hospitalContextKey{}, hospitalId)
+}
+
+// hospitalFromContext returns the hospital set by WithHospital or by the tenant middleware for
+// the request the context belongs to
+func hospitalFromContext(ctx context.Context) (string, bool) {
+	if hospitalId, ok := ctx.Value(hospitalContextKey{}).(string); ok {
+		return hospitalId, true
+	}
+	if c, ok := ctx.Value(gin.ContextKey).(*gin.Context); ok {
+		if hospitalId := c.GetString(hospitalIdKey); hospitalId != "" {
+			return hospitalId, true
+		}
+	}
+	return "", false
+}
+
+// tenantSvc scopes the documents to the hospital of the context, documents of other hospitals
+// are not found and cannot be written. The documents are stored under their HospitalDocumentKey,
+// their ids are unique within their hospital only. Contexts without a hospital, e.g. of the purge,
+// see all documents with the keys as their ids.
+type tenantSvc[DocType interface{}, P tenantDocument[DocType]] struct {
+	db_service.DbService[DocType]
+}
+
+// NewTenantScopedService wraps the db service so the documents are stamped with the hospital of
+// the context and only the documents of that hospital are read and written
+func NewTenantScopedService[DocType interface{}, P tenantDocument[DocType]](
+	db db_service.DbService[DocType],
+) db_service.DbService[DocType] {
+	return &tenantSvc[DocType, P]{DbService: db}
+}
+
+func (m *tenantSvc[DocType, P]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	hospitalId, ok := hospitalFromContext(ctx)
+	if !ok {
+		return m.DbService.CreateDocument(ctx, id, document)
+	}
+	P(document).setDocumentHospitalId(hospitalId)
+	key := HospitalDocumentKey(hospitalId, id)
+	return m.DbService.CreateDocument(ctx, key, stored[DocType, P](document, key))
+}
+
+func (m *tenantSvc[DocType, P]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	hospitalId, ok := hospitalFromContext(ctx)
+	if !ok {
+		return m.DbService.FindDocument(ctx, id)
+	}
+	document, err := m.DbService.FindDocument(ctx, HospitalDocumentKey(hospitalId, id))
+	if err != nil {
+		return nil, err
+	}
+	if P(document).documentHospitalId() != hospitalId {
+		return nil, db_service.ErrNotFound
+	}
+	P(document).setDocumentId(id)
+	return document, nil
+}
+
+func (m *tenantSvc[DocType, P]) FindAllDocuments(ctx context.Context) ([]*DocType, error) {
+	if _, ok := hospitalFromContext(ctx); !ok {
+		return m.DbService.FindAllDocuments(ctx)
+	}
+	return m.FindDocumentsByFilter(ctx, nil)
+}
+
+func (m *tenantSvc[DocType, P]) FindDocumentsByFilter(ctx context.Context, filter interface{}) ([]*DocType, error) {
+	documents, err := m.DbService.FindDocumentsByFilter(ctx, m.filter(ctx, filter))
+	if err != nil {
+		return nil, err
+	}
+	m.unkey(ctx, documents)
+	return documents, nil
+}
+
+func (m *tenantSvc[DocType, P]) FindDocumentsPage(
+	ctx context.Context,
+	request db_service.PageRequest,
+) (*db_service.Page[DocType], error) {
+	request.Filter = m.filter(ctx, request.Filter)
+	page, err := m.DbService.FindDocumentsPage(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	m.unkey(ctx, page.Documents)
+	return page, nil
+}
+
+func (m *tenantSvc[DocType, P]) filter(ctx context.Context, filter interface{}) interface{} {
+	hospitalId, ok := hospitalFromContext(ctx)
+	switch {
+	case !ok:
+		return filter
+	case filter == nil:
+		return map[string]interface{}{"hospital_id": hospitalId}
+	default:
+		return map[string]interface{}{"$and": []interface{}{filter, map[string]interface{}{"hospital_id": hospitalId}}}
+	}
+}
+
+// unkey sets the ids of the documents read from the hospital of the context back from their keys
+func (m *tenantSvc[DocType, P]) unkey(ctx context.Context, documents []*DocType) {
+	hospitalId, ok := hospitalFromContext(ctx)
+	if !ok {
+		return
+	}
+	prefix := HospitalDocumentKey(hospitalId, "")
+	for _, document := range documents {
+		if id, keyed := strings.CutPrefix(P(document).documentId(), prefix); keyed {
+			P(document).setDocumentId(id)
+		}
+	}
+}
+
+func (m *tenantSvc[DocType, P]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	key, document, err := m.stamp(ctx, id, document)
+	if err != nil {
+		return err
+	}
+	return m.DbService.UpdateDocument(ctx, key, document)
+}
+
+func (m *tenantSvc[DocType, P]) UpdateDocumentVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	document *DocType,
+) error {
+	key, document, err := m.stamp(ctx, id, document)
+	if err != nil {
+		return err
+	}
+	return m.DbService.UpdateDocumentVersioned(ctx, key, expectedVersion, document)
+}
+
+func (m *tenantSvc[DocType, P]) UpdateDocumentFields(
+	ctx context.Context,
+	id string,
+	set map[string]interface{},
+	unset []string,
+) error {
+	set, unset = keepHospital(set, unset)
+	return m.DbService.UpdateDocumentFields(ctx, m.key(ctx, id), set, unset)
+}
+
+func (m *tenantSvc[DocType, P]) UpdateDocumentFieldsVersioned(
+	ctx context.Context,
+	id string,
+	expectedVersion int64,
+	set map[string]interface{},
+	unset []string,
+) error {
+	set, unset = keepHospital(set, unset)
+	return m.DbService.UpdateDocumentFieldsVersioned(ctx, m.key(ctx, id), expectedVersion, set, unset)
+}
+
+func (m *tenantSvc[DocType, P]) DeleteDocument(ctx context.Context, id string) error {
+	return m.DbService.DeleteDocument(ctx, m.key(ctx, id))
+}
+
+func (m *tenantSvc[DocType, P]) DeleteDocumentVersioned(ctx context.Context, id string, expectedVersion int64) error {
+	return m.DbService.DeleteDocumentVersioned(ctx, m.key(ctx, id), expectedVersion)
+}
+
+// key returns the key the document of the hospital of the context is stored under
+func (m *tenantSvc[DocType, P]) key(ctx context.Context, id string) string {
+	if hospitalId, ok := hospitalFromContext(ctx); ok {
+		return HospitalDocumentKey(hospitalId, id)
+	}
+	return id
+}
+
+// stamp returns the key and the copy of the replacing document to store, kept in the hospital
+// of the context
+func (m *tenantSvc[DocType, P]) stamp(ctx context.Context, id string, document *DocType) (string, *DocType, error) {
+	hospitalId, ok := hospitalFromContext(ctx)
+	if !ok {
+		return id, document, nil
+	}
+	P(document).setDocumentHospitalId(hospitalId)
+	key := HospitalDocumentKey(hospitalId, id)
+	return key, stored[DocType, P](document, key), nil
+}
+
+// stored returns a copy of the document with the key as its id, the caller keeps its document
+func stored[DocType interface{}, P tenantDocument[DocType]](document *DocType, key string) *DocType {
+	copied := *document
+	P(&copied).setDocumentId(key)
+	return &copied
+}
+
+// keepHospital drops the changes of the hospital of the document from a field update
+func keepHospital(set map[string]interface{}, unset []string) (map[string]interface{}, []string) {
+	if _, ok := set["hospital_id"]; ok {
+		set = maps.Clone(set)
+		delete(set, "hospital_id")
+	}
+	if slices.Contains(unset, "hospital_id") {
+		unset = slices.DeleteFunc(slices.Clone(unset), func(field string) bool { return field == "hospital_id" })
+	}
+	return set, unset
+}
+
+// NewTenantMiddleware creates a gin middleware resolving the hospital of the requests to the
+// routes of the api. The hospital is selected by the X-Hospital-Id header or the path prefix,
+// a token bound to a hospital by its `hospital_id` claim works only with that hospital and
+// other tokens need the admin role to select one. The hospital has to exist. Requests to
+// the hospitals routes need a token not bound to a hospital. It has to follow the
+// authentication middleware.
+func NewTenantMiddleware(hospitals db_service.DbService[Hospital]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		if !strings.HasPrefix(path, "/api/") {
+			c.Next()
+			return
+		}
+		_, bound := auth.Claims(c)[hospitalClaim]
+		if path == "/api/hospitals" || strings.HasPrefix(path, hospitalsPathPrefix) {
+			if bound {
+				respondHospitalError(c, http.StatusForbidden, errHospitalBound)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		hospitalId, status, err := resolveHospital(c)
+		if err != nil {
+			respondHospitalError(c, status, err)
+			return
+		}
+		_, err = hospitals.FindDocument(c, hospitalId)
+		switch err {
+		case nil:
+		case db_service.ErrNotFound:
+			respondProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+			return
+		default:
+			respondDatabaseError(c, err, "Failed to retrieve hospital from database")
+			return
+		}
+
+		c.Set(hospitalIdKey, hospitalId)
+		c.Next()
+	}
+}
+
+// resolveHospital returns the hospital of the request, or the status and the error to refuse it with
+func resolveHospital(c *gin.Context) (string, int, error) {
+	requested := strings.TrimSpace(c.GetHeader(hospitalHeader))
+	claim, bound := auth.Claims(c)[hospitalClaim]
+	_, authenticated := auth.Subject(c)
+
+	switch {
+	case bound:
+		hospitalId, _ := claim.(string)
+		if hospitalId == "" || (requested != "" && requested != hospitalId) {
+			return "", http.StatusForbidden, errHospitalNotPermitted
+		}
+		return hospitalId, 0, nil
+	case requested == "":
+		return "", http.StatusBadRequest, errHospitalMissing
+	case authenticated && !slices.Contains(auth.Roles(c), RoleAdmin):
+		return "", http.StatusForbidden, errHospitalUnbound
+	default:
+		return requested, 0, nil
+	}
+}
+
+func respondHospitalError(c *gin.Context, status int, err error) {
+	problem.Respond(c, hospitalProblem(status, err))
+}
+
+func hospitalProblem(status int, err error) *problem.Problem {
+	var code string
+	switch err {
+	case errHospitalMissing:
+		code = codeHospitalRequired
+	case errHospitalNotPermitted:
+		code = codeHospitalNotPermitted
+	case errHospitalUnbound:
+		code = codeHospitalUnbound
+	case errHospitalBound:
+		code = codeHospitalBound
+	case errHospitalPathMismatch:
+		code = codeHospitalPathMismatch
+	}
+	return problem.New(status, code, "Cannot work with the hospital, "+err.Error())
+}
+
+// NewHospitalPathHandler serves the requests to /api/hospitals/:hospitalId/<route> as requests
+// to /api/<route> with the hospital in the X-Hospital-Id header
+func NewHospitalPathHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, hospitalsPathPrefix)
+		hospitalId, route, nested := strings.Cut(rest, "/")
+		if !ok || !nested || hospitalId == "" || route == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if requested := r.Header.Get(hospitalHeader); requested != "" && requested != hospitalId {
+			problem.Write(w, r, hospitalProblem(http.StatusBadRequest, errHospitalPathMismatch))
+			return
+		}
+
+		scoped := r.Clone(r.Context())
+		scoped.URL.Path = "/api/" + route
+		scoped.URL.RawPath = ""
+		scoped.Header.Set(hospitalHeader, hospitalId)
+		next.ServeHTTP(w, scoped)
+	})
+}
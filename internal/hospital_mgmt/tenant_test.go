@@ -0,0 +1,145 @@
+package hospital_mgmt
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// departmentIds returns the ids of the departments in the order they were listed
+func departmentIds(departments []Department) []string {
+	ids := []string{}
+	for _, department := range departments {
+		ids = append(ids, department.Id)
+	}
+	return ids
+}
+
+// the documents of one hospital cannot be read or written in another one
+func TestHospitalIsolation(t *testing.T) {
+	api := newTestApi(t)
+	api.createHospital("hospital-2")
+	created := api.createDepartment("surgery", 10)
+	assert.Equal(t, testHospital, created.HospitalId)
+	api.createBed("bed-1", "surgery")
+	other := func(method string, path string, body interface{}) testRequest {
+		return testRequest{method: method, path: path, body: body, hospital: "hospital-2"}
+	}
+
+	assert.Empty(t, decode[[]Department](t, api.do(other(http.MethodGet, "/api/departments", nil)), http.StatusOK))
+	assert.Empty(t, decode[[]Bed](t, api.do(other(http.MethodGet, "/api/beds?include_deleted=true", nil)), http.StatusOK))
+	requireStatus(t, api.do(other(http.MethodGet, "/api/departments/surgery", nil)), http.StatusNotFound)
+	requireStatus(t, api.do(other(http.MethodPut, "/api/departments/surgery", Department{Name: "Taken"})), http.StatusNotFound)
+	requireStatus(t, api.do(other(http.MethodDelete, "/api/beds/bed-1", nil)), http.StatusNotFound)
+	assert.Empty(t, decode[[]AuditEntry](t, api.do(other(http.MethodGet, "/api/audit", nil)), http.StatusOK))
+
+	// ids are unique within a hospital, references reach only the same hospital
+	requireStatus(t, api.do(other(http.MethodPost, "/api/beds", Bed{Id: "bed-1", DepartmentId: "surgery"})), http.StatusConflict)
+	surgery := decode[Department](t, api.do(other(http.MethodPost, "/api/departments", Department{Id: "surgery", Name: "Surgery 2"})),
+		http.StatusCreated)
+	assert.Equal(t, "hospital-2", surgery.HospitalId)
+	requireStatus(t, api.do(other(http.MethodPost, "/api/departments", Department{Id: "surgery", Name: "Surgery 2"})),
+		http.StatusConflict)
+	requireStatus(t, api.do(other(http.MethodPost, "/api/beds", Bed{Id: "bed-1", DepartmentId: "surgery"})), http.StatusCreated)
+	icu := decode[Department](t, api.do(other(http.MethodPost, "/api/departments", Department{Id: "icu", Name: "ICU"})), http.StatusCreated)
+	assert.Equal(t, "hospital-2", icu.HospitalId)
+
+	assert.Equal(t, []string{"surgery"}, departmentIds(decode[[]Department](t, api.get("/api/departments"), http.StatusOK)))
+	assert.Equal(t, []string{"icu", "surgery"},
+		departmentIds(decode[[]Department](t, api.do(other(http.MethodGet, "/api/departments?sort=id", nil)), http.StatusOK)))
+	assert.Equal(t, "surgery", decode[Bed](t, api.get("/api/beds/bed-1"), http.StatusOK).DepartmentId)
+	api.requireCapacity("surgery", 1, 0)
+	entries := decode[[]AuditEntry](t, api.do(other(http.MethodGet, "/api/audit", nil)), http.StatusOK)
+	require.Len(t, entries, 4)
+	for _, entry := range entries {
+		assert.Equal(t, "hospital-2", entry.HospitalId)
+	}
+
+	// the hospital of a document cannot be changed
+	patched := decode[Department](t, api.mergePatch("/api/departments/surgery", map[string]interface{}{
+		"hospital_id": "hospital-2",
+	}), http.StatusOK)
+	assert.Equal(t, testHospital, patched.HospitalId)
+	replaced := decode[Department](t, api.put("/api/departments/surgery", Department{Name: "Surgery", HospitalId: "hospital-2"}), http.StatusOK)
+	assert.Equal(t, testHospital, replaced.HospitalId)
+	found := decode[Department](t, api.do(other(http.MethodGet, "/api/departments/surgery", nil)), http.StatusOK)
+	assert.Equal(t, "Surgery 2", found.Name)
+	assert.Equal(t, "hospital-2", found.HospitalId)
+}
+
+func TestHospitalResolution(t *testing.T) {
+	api := newTestApi(t)
+	api.createHospital("hospital-2")
+	list := func(request testRequest) int {
+		request.method = http.MethodGet
+		request.path = "/api/departments"
+		return api.do(request).Code
+	}
+	bound := func(hospital string) jwt.MapClaims {
+		return jwt.MapClaims{hospitalClaim: hospital}
+	}
+
+	assert.Equal(t, http.StatusBadRequest, list(testRequest{noHospital: true}), "no hospital")
+	assert.Equal(t, http.StatusNotFound, list(testRequest{hospital: "unknown"}), "unknown hospital")
+	assert.Equal(t, http.StatusOK, list(testRequest{hospital: "hospital-2"}), "admin selects any hospital")
+
+	// tokens bound to a hospital work only with it
+	clerk := []string{RoleClerk}
+	assert.Equal(t, http.StatusOK, list(testRequest{roles: clerk, claims: bound("hospital-2"), noHospital: true}))
+	assert.Equal(t, http.StatusOK, list(testRequest{roles: clerk, claims: bound("hospital-2"), hospital: "hospital-2"}))
+	assert.Equal(t, http.StatusForbidden, list(testRequest{roles: clerk, claims: bound("hospital-2")}))
+	assert.Equal(t, http.StatusForbidden, list(testRequest{claims: bound("hospital-2")}), "bound admin")
+	assert.Equal(t, http.StatusForbidden, list(testRequest{roles: clerk, claims: bound("")}), "empty claim")
+	assert.Equal(t, http.StatusNotFound, list(testRequest{roles: clerk, claims: bound("unknown"), noHospital: true}))
+	assert.Equal(t, http.StatusForbidden, list(testRequest{roles: clerk, claims: jwt.MapClaims{}}), "unbound clerk")
+
+	// hospitals are managed by administrators not bound to one of them
+	requireStatus(t, api.do(testRequest{
+		method:     http.MethodGet,
+		path:       "/api/hospitals",
+		claims:     bound(testHospital),
+		noHospital: true,
+	}), http.StatusForbidden)
+	requireStatus(t, api.do(testRequest{method: http.MethodGet, path: "/api/hospitals", noHospital: true}), http.StatusOK)
+}
+
+func TestHospitalPathPrefix(t *testing.T) {
+	api := newTestApi(t)
+	api.createHospital("hospital-2")
+	api.createDepartment("surgery", 10)
+
+	created := decode[Department](t, api.do(testRequest{
+		method:     http.MethodPost,
+		path:       "/api/hospitals/hospital-2/departments",
+		body:       Department{Id: "icu", Name: "ICU"},
+		noHospital: true,
+	}), http.StatusCreated)
+	assert.Equal(t, "hospital-2", created.HospitalId)
+
+	listed := decode[[]Department](t, api.do(testRequest{
+		method:     http.MethodGet,
+		path:       "/api/hospitals/hospital-2/departments",
+		noHospital: true,
+	}), http.StatusOK)
+	assert.Equal(t, []string{"icu"}, departmentIds(listed))
+	found := decode[Department](t, api.do(testRequest{
+		method:   http.MethodGet,
+		path:     "/api/hospitals/hospital-2/departments/icu",
+		hospital: "hospital-2",
+	}), http.StatusOK)
+	assert.Equal(t, "ICU", found.Name)
+
+	body := requireProblem(t, api.get("/api/hospitals/hospital-2/departments/surgery"), http.StatusBadRequest,
+		codeHospitalPathMismatch)
+	assert.Contains(t, body.Detail, hospitalHeader)
+	requireStatus(t, api.do(testRequest{
+		method:     http.MethodGet,
+		path:       "/api/hospitals/unknown/departments",
+		noHospital: true,
+	}), http.StatusNotFound)
+	// the hospital itself is not prefixed
+	requireStatus(t, api.do(testRequest{method: http.MethodGet, path: "/api/hospitals/hospital-2", noHospital: true}), http.StatusOK)
+}
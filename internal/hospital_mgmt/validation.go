@@ -0,0 +1,397 @@
+package hospital_mgmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/psabol571/sarsabsim-webapi/internal/problem"
+	"github.com/rs/zerolog/log"
+)
+
+// locations of the invalid values of a request
+const (
+	locationBody     = "body"
+	locationDocument = "document"
+)
+
+// FieldError is a value of a request which does not match the api description
+type FieldError struct {
+	// Location is the part of the request holding the value: body, path, query or header, or the
+	// document a patch would produce
+	Location string `json:"location"`
+	// Field is the name of the parameter, or the JSON pointer of the value in the body
+	Field string `json:"field"`
+	// Message tells what is wrong with the value without repeating it
+	Message string `json:"message"`
+}
+
+// validationProblem lists the invalid values of the request
+type validationProblem struct {
+	problem.Problem
+	Errors []FieldError `json:"errors"`
+}
+
+// ValidationConfig configures the validation of the requests by the api description
+type ValidationConfig struct {
+	// Spec is the OpenAPI description of the api
+	Spec []byte
+	// ValidateResponses checks the responses of the handlers against the description too, meant
+	// for the debug mode to catch the drift between them. Every response is buffered for it.
+	ValidateResponses bool
+	// OnResponseDrift is called with a response which does not match the description, the drift
+	// is logged if it is nil. The response has been sent already.
+	OnResponseDrift func(c *gin.Context, err error)
+}
+
+// context key of the validator of the documents produced by patches
+const documentValidatorKey = "documentValidator"
+
+// documentValidator validates the JSON of a document by the schema of its updates
+type documentValidator func(document []byte) error
+
+// operation is the description of a route of the api
+type operation struct {
+	route *routers.Route
+	// document validates the result of a PATCH by the body of the PUT of the same path
+	document documentValidator
+}
+
+// NewValidationMiddleware creates a gin middleware refusing requests to the routes of the api
+// whose path, query or header parameters or body do not match the api description with
+// 400 Bad Request listing the invalid values. Every route has to be described by the operation
+// named after it. Requests to other paths pass through.
+func NewValidationMiddleware(handleFunctions ApiHandleFunctions, config ValidationConfig) (gin.HandlerFunc, error) {
+	loader := openapi3.NewLoader()
+	spec, err := loader.LoadFromData(config.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("load api description: %w", err)
+	}
+	if err := spec.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid api description: %w", err)
+	}
+
+	described := map[string]*routers.Route{}
+	for path, pathItem := range spec.Paths.Map() {
+		for method, specOperation := range pathItem.Operations() {
+			described[specOperation.OperationID] = &routers.Route{
+				Spec:      spec,
+				Path:      path,
+				PathItem:  pathItem,
+				Method:    method,
+				Operation: specOperation,
+			}
+		}
+	}
+
+	operations := map[string]*operation{}
+	for _, route := range getRoutes(handleFunctions) {
+		// the operations are named after the routes in camel case
+		operationId := strings.ToLower(route.Name[:1]) + route.Name[1:]
+		specRoute, ok := described[operationId]
+		if !ok {
+			return nil, fmt.Errorf("route %s is not described by the %s operation", route.Name, operationId)
+		}
+		if specRoute.Method != route.Method {
+			return nil, fmt.Errorf("route %s is %s, the %s operation is %s",
+				route.Name, route.Method, operationId, specRoute.Method)
+		}
+		operations[route.Method+" "+route.Pattern] = &operation{
+			route:    specRoute,
+			document: newDocumentValidator(specRoute),
+		}
+	}
+
+	options := openapi3filter.Options{
+		MultiError: true,
+		// the token is checked by the authentication middleware
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		// the handlers apply the defaults, the request is not rewritten
+		SkipSettingDefaults: true,
+		// documents read from the api are sent back with their read-only fields, which are ignored
+		ExcludeReadOnlyValidations: true,
+	}
+
+	return func(c *gin.Context) {
+		operation, ok := operations[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		input, err := requestValidationInput(c, operation.route, options)
+		if err != nil {
+			respondInvalidBody(c, err)
+			return
+		}
+		if err := openapi3filter.ValidateRequest(c, input); err != nil {
+			respondValidationError(c, err)
+			return
+		}
+		if operation.document != nil {
+			c.Set(documentValidatorKey, operation.document)
+		}
+
+		if !config.ValidateResponses {
+			c.Next()
+			return
+		}
+		writer := &bufferedResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		if err := validateResponse(c, input, writer); err != nil {
+			if config.OnResponseDrift != nil {
+				config.OnResponseDrift(c, err)
+			} else {
+				log.Error().Err(err).
+					Str("method", c.Request.Method).
+					Str("route", c.FullPath()).
+					Int("status", writer.Status()).
+					Msg("Response does not match the api description")
+			}
+		}
+	}, nil
+}
+
+// requestValidationInput prepares the request for the validation, its body stays readable
+// by the handler
+func requestValidationInput(
+	c *gin.Context,
+	route *routers.Route,
+	options openapi3filter.Options,
+) (*openapi3filter.RequestValidationInput, error) {
+	pathParams := map[string]string{}
+	for _, param := range c.Params {
+		pathParams[param.Key] = param.Value
+	}
+
+	request := c.Request
+	if requestBody := route.Operation.RequestBody; requestBody != nil {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		request = c.Request.Clone(c.Request.Context())
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		content := requestBody.Value.Content
+		if content.Get(c.ContentType()) == nil {
+			if content.Get(binding.MIMEJSON) != nil {
+				// the handlers bind JSON bodies whatever their content type is
+				request.Header.Set("Content-Type", binding.MIMEJSON)
+			} else {
+				// patches of an unsupported type are refused by the handlers
+				options.ExcludeRequestBody = true
+			}
+		}
+	}
+
+	return &openapi3filter.RequestValidationInput{
+		Request:    request,
+		PathParams: pathParams,
+		Route:      route,
+		Options:    &options,
+	}, nil
+}
+
+// newDocumentValidator returns the validator of the documents patched by the PATCH operation
+// by the body of the PUT of the same path, nil for other operations
+func newDocumentValidator(route *routers.Route) documentValidator {
+	if route.Method != http.MethodPatch || route.PathItem.Put == nil || route.PathItem.Put.RequestBody == nil {
+		return nil
+	}
+	mediaType := route.PathItem.Put.RequestBody.Value.Content.Get(binding.MIMEJSON)
+	if mediaType == nil || mediaType.Schema == nil {
+		return nil
+	}
+	schema := mediaType.Schema.Value
+	return func(document []byte) error {
+		var value interface{}
+		if err := json.Unmarshal(document, &value); err != nil {
+			return err
+		}
+		err := schema.VisitJSON(value,
+			openapi3.VisitAsRequest(), openapi3.MultiErrors(), openapi3.DisableReadOnlyValidation())
+		if err != nil {
+			return &documentValidationError{errors: schemaFieldErrors(locationDocument, err)}
+		}
+		return nil
+	}
+}
+
+// requestDocumentValidator returns the validator of the documents patched by the request, nil if
+// the request is not validated
+func requestDocumentValidator(c *gin.Context) documentValidator {
+	value, ok := c.Get(documentValidatorKey)
+	if !ok {
+		return nil
+	}
+	return value.(documentValidator)
+}
+
+// documentValidationError refuses a patch producing a document which does not match the api
+// description
+type documentValidationError struct {
+	errors []FieldError
+}
+
+func (e *documentValidationError) Error() string {
+	messages := make([]string, len(e.errors))
+	for i, fieldError := range e.errors {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return "patched document is invalid: " + strings.Join(messages, ", ")
+}
+
+// respondValidationError aborts the request refused by the validation, a body which cannot be
+// decoded is refused as by the handlers
+func respondValidationError(c *gin.Context, err error) {
+	var fieldErrors []FieldError
+	onlyQuery := true
+	for _, err := range unwrapMultiError(err) {
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			// security requirements are left to the authentication middleware
+			continue
+		}
+
+		switch {
+		case requestErr.Parameter != nil:
+			parameter := requestErr.Parameter
+			onlyQuery = onlyQuery && parameter.In == openapi3.ParameterInQuery
+			for _, message := range parameterMessages(requestErr) {
+				fieldErrors = append(fieldErrors, FieldError{Location: parameter.In, Field: parameter.Name, Message: message})
+			}
+		case requestErr.RequestBody != nil:
+			if errors.Is(requestErr, openapi3filter.ErrInvalidRequired) {
+				respondInvalidBody(c, io.EOF)
+				return
+			}
+			var parseErr *openapi3filter.ParseError
+			if errors.As(requestErr, &parseErr) {
+				respondInvalidBody(c, parseErr.Cause)
+				return
+			}
+			onlyQuery = false
+			fieldErrors = append(fieldErrors, schemaFieldErrors(locationBody, requestErr.Err)...)
+		}
+	}
+	code := codeValidationFailed
+	if onlyQuery {
+		code = codeInvalidQuery
+	}
+	respondFieldErrors(c, code, "Request does not match the api description", fieldErrors)
+}
+
+// respondFieldErrors aborts the request with 400 Bad Request listing the invalid values
+func respondFieldErrors(c *gin.Context, code string, detail string, fieldErrors []FieldError) {
+	problem.Respond(c, &validationProblem{
+		Problem: *problem.New(http.StatusBadRequest, code, detail),
+		Errors:  fieldErrors,
+	})
+}
+
+// parameterMessages describes the errors of a parameter value without the value itself
+func parameterMessages(err *openapi3filter.RequestError) []string {
+	var parseErr *openapi3filter.ParseError
+	switch {
+	case errors.Is(err, openapi3filter.ErrInvalidRequired):
+		return []string{"value is required"}
+	case errors.Is(err, openapi3filter.ErrInvalidEmptyValue):
+		return []string{"value must not be empty"}
+	case errors.As(err, &parseErr):
+		if parseErr.Reason != "" {
+			return []string{"value is " + parseErr.Reason}
+		}
+		return []string{"value cannot be parsed"}
+	}
+
+	var messages []string
+	for _, fieldError := range schemaFieldErrors("", err.Err) {
+		messages = append(messages, fieldError.Message)
+	}
+	if len(messages) == 0 {
+		messages = append(messages, err.Reason)
+	}
+	return messages
+}
+
+// schemaFieldErrors lists the values violating the schemas, named by their JSON pointers
+func schemaFieldErrors(location string, err error) []FieldError {
+	var fieldErrors []FieldError
+	for _, err := range unwrapMultiError(err) {
+		var schemaErr *openapi3.SchemaError
+		if !errors.As(err, &schemaErr) {
+			continue
+		}
+		// the reason never contains the value, the one of a format tells its pattern
+		message := schemaErr.Reason
+		if schemaErr.SchemaField == "format" {
+			message = fmt.Sprintf("string doesn't match the format %q", schemaErr.Schema.Format)
+		}
+		fieldErrors = append(fieldErrors, FieldError{
+			Location: location,
+			Field:    "/" + strings.Join(schemaErr.JSONPointer(), "/"),
+			Message:  message,
+		})
+	}
+	return fieldErrors
+}
+
+// unwrapMultiError flattens the errors collected by the validation, the errors wrapping them
+// are kept
+func unwrapMultiError(err error) []error {
+	multiErr, ok := err.(openapi3.MultiError)
+	if !ok {
+		return []error{err}
+	}
+	var flattened []error
+	for _, err := range multiErr {
+		flattened = append(flattened, unwrapMultiError(err)...)
+	}
+	return flattened
+}
+
+// bufferedResponseWriter keeps a copy of the response body for its validation
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// validateResponse checks the sent response against the responses of the operation
+func validateResponse(
+	c *gin.Context,
+	input *openapi3filter.RequestValidationInput,
+	writer *bufferedResponseWriter,
+) error {
+	options := *input.Options
+	options.IncludeResponseStatus = true
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 writer.Status(),
+		Header:                 writer.Header(),
+		Options:                &options,
+	}
+	responseInput.SetBodyBytes(writer.body.Bytes())
+	return openapi3filter.ValidateResponse(c, responseInput)
+}
@@ -0,0 +1,193 @@
+package hospital_mgmt
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	openapi "github.com/psabol571/sarsabsim-webapi/api"
+	"github.com/psabol571/sarsabsim-webapi/internal/db_service"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storedDbService finds the document whatever its id is
+type storedDbService[DocType interface{}] struct {
+	db_service.DbService[DocType]
+	document *DocType
+}
+
+func (s *storedDbService[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	return s.document, nil
+}
+
+func TestRequestValidation(t *testing.T) {
+	api := newTestApi(t)
+	api.createDepartment("surgery", 10)
+	api.createBed("bed-1", "surgery")
+	api.createPatient("patient-1", "Jana", "Novakova")
+
+	for _, tc := range []struct {
+		name     string
+		response *httptest.ResponseRecorder
+		code     string
+		errors   []FieldError
+	}{
+		{
+			name:     "negative floor",
+			response: api.post("/api/departments", Department{Id: "cellar", Name: "Cellar", Floor: -1}),
+			code:     codeValidationFailed,
+			errors:   []FieldError{{Location: "body", Field: "/floor", Message: "number must be at least 0"}},
+		},
+		{
+			name:     "bed quality out of range",
+			response: api.post("/api/beds", Bed{Id: "bed-2", DepartmentId: "surgery", BedType: "icu", BedQuality: 7.3}),
+			code:     codeValidationFailed,
+			errors:   []FieldError{{Location: "body", Field: "/bed_quality", Message: "number must be at most 1"}},
+		},
+		{
+			name: "patient fields",
+			response: api.post("/api/patients", Patient{
+				Id:        "patient-2",
+				FirstName: "Eva",
+				LastName:  "Kralova",
+				BirthDate: "15.3.1985",
+				Gender:    "banana",
+			}),
+			code: codeValidationFailed,
+			errors: []FieldError{
+				{Location: "body", Field: "/birth_date", Message: `string doesn't match the format "date"`},
+				{Location: "body", Field: "/gender", Message: `value is not one of the allowed values ["M","F","Other"]`},
+			},
+		},
+		{
+			name:     "missing field",
+			response: api.post("/api/patients", map[string]interface{}{"first_name": "Eva", "last_name": "Kralova", "gender": "F"}),
+			code:     codeValidationFailed,
+			errors:   []FieldError{{Location: "body", Field: "/birth_date", Message: `property "birth_date" is missing`}},
+		},
+		{
+			name:     "query",
+			response: api.get("/api/beds?page=0&page_size=many"),
+			code:     codeInvalidQuery,
+			errors: []FieldError{
+				{Location: "query", Field: "page", Message: "number must be at least 1"},
+				{Location: "query", Field: "page_size", Message: "value is an invalid integer"},
+			},
+		},
+		{
+			name:     "boolean query",
+			response: api.post("/api/departments/reconcile?dry_run=maybe", nil),
+			code:     codeInvalidQuery,
+			errors:   []FieldError{{Location: "query", Field: "dry_run", Message: "value is an invalid boolean"}},
+		},
+		{
+			name: "json patch",
+			response: api.do(testRequest{
+				method:      http.MethodPatch,
+				path:        "/api/beds/bed-1",
+				body:        `[{"op": "rename", "path": "/bed_type"}]`,
+				contentType: jsonPatchContentType,
+			}),
+			code: codeValidationFailed,
+			errors: []FieldError{{Location: "body", Field: "/0/op",
+				Message: `value is not one of the allowed values ["add","remove","replace","move","copy","test"]`}},
+		},
+		{
+			name:     "patched document",
+			response: api.mergePatch("/api/beds/bed-1", map[string]interface{}{"bed_quality": 7.3, "bed_type": nil}),
+			code:     codeValidationFailed,
+			errors: []FieldError{
+				{Location: "document", Field: "/bed_quality", Message: "number must be at most 1"},
+				{Location: "document", Field: "/bed_type", Message: `property "bed_type" is missing`},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := requireProblem(t, tc.response, http.StatusBadRequest, tc.code)
+			assert.ElementsMatch(t, tc.errors, body.Errors)
+		})
+	}
+
+	// nothing was written by the refused requests
+	bed := decode[Bed](t, api.get("/api/beds/bed-1"), http.StatusOK)
+	assert.Equal(t, 0.6, bed.BedQuality)
+	assert.Equal(t, int64(1), bed.Version)
+	requireStatus(t, api.get("/api/patients/patient-2"), http.StatusNotFound)
+
+	// JSON bodies are validated whatever their content type is, as the handlers bind them anyway
+	body := requireProblem(t, api.do(testRequest{
+		method:      http.MethodPut,
+		path:        "/api/beds/bed-1",
+		body:        Bed{DepartmentId: "surgery", BedType: "icu", BedQuality: -1},
+		contentType: "text/plain",
+	}), http.StatusBadRequest, codeValidationFailed)
+	assert.Equal(t, []FieldError{{Location: "body", Field: "/bed_quality", Message: "number must be at least 0"}}, body.Errors)
+	requireProblem(t, api.do(testRequest{method: http.MethodPost, path: "/api/patients/patient-1/admissions"}),
+		http.StatusBadRequest, codeInvalidRequestBody)
+
+	// documents read from the api may be sent back with their read-only fields
+	requireStatus(t, api.put("/api/beds/bed-1", bed), http.StatusOK)
+}
+
+// a response which does not match the api description is reported, it is still sent
+func TestResponseValidation(t *testing.T) {
+	drifted := &Bed{Id: "bed-1", DepartmentId: "surgery", BedType: "icu", BedQuality: 7.3}
+
+	serve := func(config ValidationConfig) *httptest.ResponseRecorder {
+		engine := gin.New()
+		handleFunctions := testHandleFunctions()
+		handleFunctions.BedsAPI = NewBedsAPI(&storedDbService[Bed]{document: drifted}, nil, nil, nil)
+		config.Spec = openapi.OpenApiSpec()
+		validate, err := NewValidationMiddleware(handleFunctions, config)
+		require.NoError(t, err)
+		engine.Use(validate)
+		NewRouterWithGinEngine(engine, handleFunctions)
+
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/beds/bed-1", nil))
+		return recorder
+	}
+
+	var drift error
+	response := serve(ValidationConfig{
+		ValidateResponses: true,
+		OnResponseDrift: func(c *gin.Context, err error) {
+			assert.Equal(t, "/api/beds/:bedId", c.FullPath())
+			drift = err
+		},
+	})
+	assert.Equal(t, 7.3, decode[Bed](t, response, http.StatusOK).BedQuality)
+	require.Error(t, drift)
+	assert.Contains(t, drift.Error(), `Error at "/bed_quality": number must be at most 1`)
+
+	// the drift is logged by default
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	t.Cleanup(func() { log.Logger = logger })
+	requireStatus(t, serve(ValidationConfig{ValidateResponses: true}), http.StatusOK)
+	assert.Contains(t, logs.String(), "Response does not match the api description")
+	assert.Contains(t, logs.String(), "/api/beds/:bedId")
+
+	// the responses are not validated unless configured
+	logs.Reset()
+	requireStatus(t, serve(ValidationConfig{}), http.StatusOK)
+	assert.Empty(t, logs.String())
+}
+
+// every route has to be described by its operation
+func TestValidationOfUndescribedRoutes(t *testing.T) {
+	_, err := NewValidationMiddleware(testHandleFunctions(), ValidationConfig{
+		Spec: []byte("openapi: 3.0.0\ninfo:\n  title: Empty\n  version: \"1.0.0\"\npaths: {}\n"),
+	})
+	require.ErrorContains(t, err, "route CreateDepartment is not described by the createDepartment operation")
+
+	_, err = NewValidationMiddleware(testHandleFunctions(), ValidationConfig{Spec: []byte("openapi: [")})
+	require.Error(t, err)
+}
@@ -0,0 +1,127 @@
+// Package problem writes the error responses of the api as RFC 7807 problem details
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ContentType of every error response
+	ContentType = "application/problem+json"
+
+	// TypePrefix of the problem types, the type is the prefix followed by the code in kebab case
+	TypePrefix = "urn:problem-type:hospital-mgmt:"
+
+	// CodeInternalError is the code of failures the client can do nothing about
+	CodeInternalError = "INTERNAL_ERROR"
+	// CodeRouteNotFound is the code of requests to paths the api does not serve
+	CodeRouteNotFound = "ROUTE_NOT_FOUND"
+)
+
+// Problem describes why a request failed. Clients branch on the code, the title and
+// the detail are meant for people and may change.
+type Problem struct {
+	// URI identifying the type of the problem, derived from the code
+	Type string `json:"type"`
+	// Short summary of the type of the problem
+	Title string `json:"title"`
+	// HTTP status code of the response
+	Status int `json:"status"`
+	// Explanation of this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Path of the request which failed
+	Instance string `json:"instance,omitempty"`
+	// Stable machine readable code of the problem, e.g. BED_OCCUPIED
+	Code string `json:"code"`
+	// Trace of the request, correlates the response with the logs of the service
+	TraceId string `json:"trace_id,omitempty"`
+}
+
+// Body is a Problem or a struct embedding it which adds extension members
+type Body interface {
+	problem() *Problem
+}
+
+func (p *Problem) problem() *Problem {
+	return p
+}
+
+// New creates the problem of the status with the code
+func New(status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   TypeOf(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// TypeOf returns the type URI of the code
+func TypeOf(code string) string {
+	return TypePrefix + strings.ToLower(strings.ReplaceAll(code, "_", "-"))
+}
+
+// Respond aborts the request with the problem
+func Respond(c *gin.Context, body Body) {
+	p := body.problem()
+	p.Instance = instance(c.Request)
+	p.TraceId = traceId(c.Request)
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, body)
+}
+
+// RespondError aborts the request with the problem caused by an error of the service. The error
+// is logged together with the trace id of the request, it never reaches the client.
+func RespondError(c *gin.Context, err error, body Body) {
+	p := body.problem()
+	log.Error().
+		Err(err).
+		Str("code", p.Code).
+		Str("trace_id", traceId(c.Request)).
+		Str("method", c.Request.Method).
+		Str("path", instance(c.Request)).
+		Msg(p.Detail)
+	c.Error(err)
+	Respond(c, body)
+}
+
+// Write writes the problem as the response of a request not handled by gin
+func Write(w http.ResponseWriter, r *http.Request, body Body) {
+	p := body.problem()
+	p.Instance = instance(r)
+	p.TraceId = traceId(r)
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, p.Detail, p.Status)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	w.Write(encoded)
+}
+
+// instance returns the path the client requested, the request may have been routed by another one.
+// The query is left out, it can hold personal data.
+func instance(r *http.Request) string {
+	if r.RequestURI == "" {
+		return r.URL.Path
+	}
+	path, _, _ := strings.Cut(r.RequestURI, "?")
+	return path
+}
+
+// traceId returns the id of the trace the request is part of, empty outside of traces
+func traceId(r *http.Request) string {
+	spanContext := trace.SpanContextFromContext(r.Context())
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
@@ -0,0 +1,55 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// extended adds an extension member to the problem
+type extended struct {
+	Problem
+	Items []string `json:"items"`
+}
+
+func TestTypeOf(t *testing.T) {
+	assert.Equal(t, "urn:problem-type:hospital-mgmt:bed-occupied", TypeOf("BED_OCCUPIED"))
+	assert.Equal(t, TypeOf(CodeInternalError), New(http.StatusInternalServerError, CodeInternalError, "").Type)
+}
+
+func TestWrite(t *testing.T) {
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:  trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+	})
+	request := httptest.NewRequest(http.MethodGet, "/api/hospitals/hospital-1/beds?q=Novakova", nil)
+	// the request is routed by another path, the problem names the requested one
+	request.URL.Path = "/api/beds"
+	request = request.WithContext(trace.ContextWithSpanContext(request.Context(), spanContext))
+
+	recorder := httptest.NewRecorder()
+	Write(recorder, request, &extended{
+		Problem: *New(http.StatusConflict, "BED_OCCUPIED", "Bed is occupied"),
+		Items:   []string{"bed-1"},
+	})
+
+	require.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Equal(t, ContentType, recorder.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, map[string]interface{}{
+		"type":     "urn:problem-type:hospital-mgmt:bed-occupied",
+		"title":    "Conflict",
+		"status":   float64(http.StatusConflict),
+		"detail":   "Bed is occupied",
+		"instance": "/api/hospitals/hospital-1/beds",
+		"code":     "BED_OCCUPIED",
+		"trace_id": "0102030405060708090a0b0c0d0e0f10",
+		"items":    []interface{}{"bed-1"},
+	}, body)
+}